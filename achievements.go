@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// milestone is a play-time threshold that unlocks a badge
+type milestone struct {
+	threshold time.Duration
+	badge     string
+}
+
+// gameMilestones are unlocked by play time on a single game, in ascending order
+var gameMilestones = []milestone{
+	{threshold: 1 * time.Hour, badge: "Rookie"},
+	{threshold: 10 * time.Hour, badge: "Dedicated"},
+	{threshold: 50 * time.Hour, badge: "Veteran"},
+	{threshold: 100 * time.Hour, badge: "Legend"},
+}
+
+// totalMilestones are unlocked by play time across all games, in ascending order
+var totalMilestones = []milestone{
+	{threshold: 10 * time.Hour, badge: "Casual Gamer"},
+	{threshold: 100 * time.Hour, badge: "Hardcore Gamer"},
+	{threshold: 500 * time.Hour, badge: "Gaming Machine"},
+	{threshold: 1000 * time.Hour, badge: "Living Legend"},
+}
+
+// highestMilestone returns the highest milestone reached by d, if any
+func highestMilestone(milestones []milestone, d time.Duration) (milestone, bool) {
+	var reached milestone
+	ok := false
+	for _, candidate := range milestones {
+		if d >= candidate.threshold {
+			reached = candidate
+			ok = true
+		}
+	}
+	return reached, ok
+}
+
+// handleAchievements implements the !achievements command: the milestones the user has unlocked
+func handleAchievements(s messageSender, m *discordgo.MessageCreate, args string) {
+	userID := m.Author.ID
+	username := m.Author.Username
+
+	var playTimes map[string]time.Duration
+	format := formatDuration
+	if userData, ok := data.snapshotUser(m.GuildID, userID); ok {
+		format = userDurationFormat(userData)
+		playTimes = gamePlayTimes(userData, time.Now())
+	}
+
+	var total time.Duration
+	for _, duration := range playTimes {
+		total += duration
+	}
+
+	response := fmt.Sprintf("Achievements for %s:\n", username)
+	unlocked := 0
+	for _, reached := range totalMilestones {
+		if total >= reached.threshold {
+			response += fmt.Sprintf("- **%s**: %s played in total\n", reached.badge, format(reached.threshold))
+			unlocked++
+		}
+	}
+
+	for _, game := range rankGames(playTimes) {
+		if reached, ok := highestMilestone(gameMilestones, game.duration); ok {
+			response += fmt.Sprintf("- **%s** on %s (%s)\n", reached.badge, sanitizeName(game.name), format(game.duration))
+			unlocked++
+		}
+	}
+
+	if unlocked == 0 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you haven't unlocked any achievements yet. Play a game for %s to get your first one!", username, format(gameMilestones[0].threshold)))
+		return
+	}
+	sendChunked(s, m.ChannelID, response)
+}
+
+// checkMilestoneLocked reports whether a session that was just added pushed the user's total on
+// that game past a milestone, returning the milestone and the new total. Each milestone is only
+// reported once per game. The caller must hold data.mu.
+func checkMilestoneLocked(userData *UserGameData, session GameSession) (milestone, time.Duration, bool) {
+	total := time.Duration(userData.TrimmedTotals[session.GameName]) * time.Second
+	for _, s := range userData.Sessions {
+		if s.GameName == session.GameName {
+			total += time.Duration(s.Duration) * time.Second
+		}
+	}
+	previous := total - time.Duration(session.Duration)*time.Second
+
+	reached, ok := highestMilestone(gameMilestones, total)
+	if !ok || previous >= reached.threshold {
+		return milestone{}, 0, false // No milestone, or it was already reached before this session
+	}
+	if userData.NotifiedMilestones[session.GameName] >= reached.threshold.Seconds() {
+		return milestone{}, 0, false
+	}
+
+	if userData.NotifiedMilestones == nil {
+		userData.NotifiedMilestones = make(map[string]float64)
+	}
+	userData.NotifiedMilestones[session.GameName] = reached.threshold.Seconds()
+	return reached, total, true
+}
+
+// claimMilestoneLocked records a milestone of a game the user reached in one guild as notified in
+// every other guild they're tracked in, and reports whether none of them was notified of it yet.
+// Users sharing several servers with the bot are congratulated once, not once per server. The
+// caller must hold data.mu.
+func (ds *DataStore) claimMilestoneLocked(guildID, userID, gameName string, reached milestone) bool {
+	claimed := true
+	for otherGuildID, users := range ds.Guilds {
+		userData, ok := users[userID]
+		if !ok || otherGuildID == guildID {
+			continue
+		}
+		if userData.NotifiedMilestones[gameName] >= reached.threshold.Seconds() {
+			claimed = false
+			continue
+		}
+		if userData.NotifiedMilestones == nil {
+			userData.NotifiedMilestones = make(map[string]float64)
+		}
+		userData.NotifiedMilestones[gameName] = reached.threshold.Seconds()
+	}
+	return claimed
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMilestoneDMs checks that a milestone is congratulated once per user, however many servers
+// they're tracked in, and not at all once they turned it off
+func TestMilestoneDMs(t *testing.T) {
+	tests := []struct {
+		name    string
+		guilds  []string
+		muted   bool
+		wantDMs int
+	}{
+		{name: "one server", guilds: []string{"guild"}, wantDMs: 1},
+		{name: "two servers", guilds: []string{"guild", "other"}, wantDMs: 1},
+		{name: "turned off", guilds: []string{"guild"}, muted: true, wantDMs: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &emptyActivityGrace, 0)
+			setForTest(t, &mergeWindow, 0)
+			s := newFakeSession()
+			if tt.muted {
+				store.mu.Lock()
+				store.getOrCreateUser("guild", "1").MuteMilestones = true
+				store.mu.Unlock()
+			}
+
+			for _, guildID := range tt.guilds {
+				started := testPresence("1", time.Now().Add(-2*time.Hour), "Minecraft")
+				started.GuildID = guildID
+				handlePresence(s, started, time.Time{})
+				stopped := testPresence("1", time.Time{})
+				stopped.GuildID = guildID
+				handlePresence(s, stopped, time.Time{})
+			}
+
+			s.waitForMessages("dm-1", tt.wantDMs)
+			// Give a duplicate DM the chance to arrive as well
+			time.Sleep(50 * time.Millisecond)
+			dms := s.messages("dm-1")
+			if len(dms) != tt.wantDMs {
+				t.Fatalf("milestone DMs = %q, want %d", dms, tt.wantDMs)
+			}
+			if tt.wantDMs > 0 && !strings.Contains(dms[0], "**Rookie** on **Minecraft**") {
+				t.Errorf("milestone DM = %q, want the Rookie badge on Minecraft", dms[0])
+			}
+		})
+	}
+}
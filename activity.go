@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// trackableActivityTypes lists the activity types that can be tracked, in display order
+var trackableActivityTypes = []discordgo.ActivityType{
+	discordgo.ActivityTypeGame,
+	discordgo.ActivityTypeStreaming,
+	discordgo.ActivityTypeListening,
+}
+
+// Names of the activity types that can be tracked, as used in TRACK_ACTIVITY_TYPES and commands.
+// Sessions store an empty activity type for games, which keeps files from before this was tracked valid.
+var activityTypeNames = map[discordgo.ActivityType]string{
+	discordgo.ActivityTypeGame:      "game",
+	discordgo.ActivityTypeStreaming: "streaming",
+	discordgo.ActivityTypeListening: "listening",
+}
+
+// trackedActivityTypes are the activity types presenceUpdate records, only games by default
+var trackedActivityTypes = map[discordgo.ActivityType]bool{
+	discordgo.ActivityTypeGame: true,
+}
+
+// parseTrackedActivityTypes reads a comma-separated list of activity type names such as "game,listening"
+func parseTrackedActivityTypes(value string) (map[discordgo.ActivityType]bool, error) {
+	tracked := make(map[discordgo.ActivityType]bool)
+	for _, name := range strings.Split(value, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		activityType, ok := activityTypeByName(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown activity type %q", name)
+		}
+		tracked[activityType] = true
+	}
+	if len(tracked) == 0 {
+		return nil, fmt.Errorf("no activity types given")
+	}
+	return tracked, nil
+}
+
+// activityTypeByName looks up a trackable activity type by its name
+func activityTypeByName(name string) (discordgo.ActivityType, bool) {
+	for _, activityType := range trackableActivityTypes {
+		if activityTypeNames[activityType] == name {
+			return activityType, true
+		}
+	}
+	return 0, false
+}
+
+// sessionActivityType returns the value stored in GameSession.ActivityType for an activity type
+func sessionActivityType(activityType discordgo.ActivityType) string {
+	if activityType == discordgo.ActivityTypeGame {
+		return ""
+	}
+	return activityTypeNames[activityType]
+}
+
+// filterByActivityType returns a copy of the user's data with only sessions and active games of the
+// given type, named as in activityTypeNames. It lets any command report on a single activity type.
+func filterByActivityType(userData *UserGameData, typeName string) *UserGameData {
+	stored := typeName
+	if typeName == "game" {
+		stored = ""
+	}
+
+	filtered := newUserGameData()
+	for _, session := range userData.Sessions {
+		if session.ActivityType == stored {
+			filtered.Sessions = append(filtered.Sessions, session)
+		}
+	}
+	for gameName, startTime := range userData.ActiveGames {
+		if userData.ActiveTypes[gameName] == stored {
+			filtered.ActiveGames[gameName] = startTime
+		}
+	}
+	return filtered
+}
+
+// activityTypeNameList lists the trackable activity type names for usage messages
+func activityTypeNameList() string {
+	names := make([]string, 0, len(trackableActivityTypes))
+	for _, activityType := range trackableActivityTypes {
+		names = append(names, activityTypeNames[activityType])
+	}
+	return strings.Join(names, "|")
+}
+
+// matchByApplicationID makes sessions follow the Discord application ID of an activity instead
+// of its name, so games that change their name mid-session aren't split. Activities without an
+// application ID are still matched by name. Configurable via MATCH_BY_APPLICATION_ID.
+var matchByApplicationID bool
+
+// activityMatchKey returns the key used to match an activity with an active session
+func activityMatchKey(activity *discordgo.Activity) string {
+	if matchByApplicationID && activity.ApplicationID != "" {
+		return "app:" + activity.ApplicationID
+	}
+	return activityKey(activity.Name)
+}
+
+// activeGameKey returns the key of an active session, matching what activityMatchKey returns
+// for the activity that started it
+func activeGameKey(userData *UserGameData, gameName string) string {
+	if appID := userData.ActiveAppIDs[gameName]; matchByApplicationID && appID != "" {
+		return "app:" + appID
+	}
+	return activityKey(gameName)
+}
+
+// activityKey normalizes an activity name for comparison, so names that differ only by case or
+// surrounding whitespace are treated as the same game
+func activityKey(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// trackedActivities returns the normalized, non-ignored activities of a tracked type in a
+// presence, keeping only the first of any duplicates Discord reports
+func trackedActivities(activities []*discordgo.Activity) []*discordgo.Activity {
+	seen := make(map[string]bool)
+	var tracked []*discordgo.Activity
+	for _, activity := range activities {
+		if activity == nil || !trackedActivityTypes[activity.Type] || isIgnored(activity.Name) {
+			continue
+		}
+		if activityKey(activity.Name) == "" {
+			continue
+		}
+		trimmed := *activity
+		trimmed.Name = normalizeGameName(activity.Name)
+		if isIgnored(trimmed.Name) {
+			continue
+		}
+
+		key := activityMatchKey(&trimmed)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		tracked = append(tracked, &trimmed)
+	}
+	return tracked
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// TestDuplicateActivities feeds a presence listing the same game several times and checks that
+// it is tracked as one session
+func TestDuplicateActivities(t *testing.T) {
+	store := newTestStore(t)
+	setForTest(t, &emptyActivityGrace, 0)
+	setForTest(t, &mergeWindow, 0)
+	s := newFakeSession()
+	startedAt := time.Now().Add(-time.Hour)
+
+	p := testPresence("1", startedAt, "Minecraft", " minecraft", "MINECRAFT ", "Tetris")
+	var names []string
+	for _, activity := range trackedActivities(p.Activities) {
+		names = append(names, activity.Name)
+	}
+	if len(names) != 2 || names[0] != "Minecraft" || names[1] != "Tetris" {
+		t.Errorf("tracked activities = %q, want Minecraft and Tetris once", names)
+	}
+	handlePresence(s, p, time.Time{})
+	userData, _ := store.snapshotUser("guild", "1")
+	if len(userData.ActiveGames) != 2 {
+		t.Errorf("active games = %v, want Minecraft and Tetris", userData.ActiveGames)
+	}
+
+	handlePresence(s, testPresence("1", time.Time{}), time.Time{})
+	userData, _ = store.snapshotUser("guild", "1")
+	if len(userData.Sessions) != 2 {
+		t.Errorf("sessions = %+v, want one of each game", userData.Sessions)
+	}
+}
+
+// TestMatchByApplicationID renames a running game and checks that the session continues when
+// games are matched by application ID, and is split otherwise
+func TestMatchByApplicationID(t *testing.T) {
+	tests := []struct {
+		name         string
+		matchByAppID bool
+		appID        string
+		wantSessions int
+	}{
+		{"by application ID", true, "42", 1},
+		{"without application ID", true, "", 2},
+		{"by name", false, "42", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &emptyActivityGrace, 0)
+			setForTest(t, &mergeWindow, 0)
+			setForTest(t, &matchByApplicationID, tt.matchByAppID)
+			s := newFakeSession()
+			startedAt := time.Now().Add(-2 * time.Hour)
+			presence := func(name string) *discordgo.PresenceUpdate {
+				p := testPresence("1", startedAt, name)
+				p.Activities[0].ApplicationID = tt.appID
+				return p
+			}
+
+			handlePresence(s, presence("Deep Rock Galactic - Space Rig"), time.Time{})
+			handlePresence(s, presence("Deep Rock Galactic - Mission"), time.Time{})
+			handlePresence(s, testPresence("1", time.Time{}), time.Time{})
+
+			userData, _ := store.snapshotUser("guild", "1")
+			if len(userData.Sessions) != tt.wantSessions {
+				t.Fatalf("sessions = %+v, want %d", userData.Sessions, tt.wantSessions)
+			}
+			if tt.wantSessions == 1 {
+				if got := gamePlayTimes(userData, time.Now()); len(got) != 1 || got["Deep Rock Galactic - Space Rig"] < 2*time.Hour-time.Minute {
+					t.Errorf("play times = %v, want about 2h under the first name", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	// Game milestones from this threshold on are announced, smaller ones only get the DM
+	announceMilestoneThreshold = 50 * time.Hour
+	announceTimeout            = 10 * time.Second // How long posting an announcement may take
+)
+
+// announceWebhookURL is the Discord webhook new server records and big milestones are posted
+// to, configurable via ANNOUNCE_WEBHOOK_URL. Empty disables announcements.
+var announceWebhookURL string
+
+// announceClient posts announcements to the webhook
+var announceClient = &http.Client{Timeout: announceTimeout}
+
+// serverLongestSessionLocked returns the duration in seconds of the longest session recorded in a
+// guild. The caller must hold data.mu.
+func serverLongestSessionLocked(guildID string) float64 {
+	return data.guildTotalsLocked(guildID).longest.Duration
+}
+
+// announce posts content to the announcement webhook in the background, if one is configured
+func announce(content string) {
+	if announceWebhookURL == "" {
+		return
+	}
+	go func() {
+		if err := postWebhook(announceWebhookURL, content); err != nil {
+			slog.Warn("Could not post announcement", "error", err)
+		}
+	}()
+}
+
+// webhookMessage is the body of a webhook execution, with only the fields announcements use
+type webhookMessage struct {
+	Content         string `json:"content"`
+	AllowedMentions struct {
+		Parse []string `json:"parse"`
+	} `json:"allowed_mentions"`
+}
+
+// webhookPayload builds the body of a webhook message. Users are mentioned without being pinged.
+func webhookPayload(content string) ([]byte, error) {
+	message := webhookMessage{Content: content}
+	message.AllowedMentions.Parse = []string{}
+	return json.Marshal(message)
+}
+
+// postWebhook executes a Discord webhook with a message
+func postWebhook(url, content string) error {
+	payload, err := webhookPayload(content)
+	if err != nil {
+		return fmt.Errorf("error encoding webhook payload: %w", err)
+	}
+	resp, err := announceClient.Post(url, "application/json", bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("error posting to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("webhook returned %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWebhookPayload(t *testing.T) {
+	payload, err := webhookPayload("<@1> unlocked **Veteran** on **Minecraft**")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]any
+	if err := json.Unmarshal(payload, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["content"] != "<@1> unlocked **Veteran** on **Minecraft**" {
+		t.Errorf("content = %v, want the announcement", got["content"])
+	}
+	// An empty parse list is what keeps the mention from pinging, a missing one pings
+	mentions, _ := got["allowed_mentions"].(map[string]any)
+	if parse, ok := mentions["parse"].([]any); !ok || len(parse) != 0 {
+		t.Errorf("allowed_mentions = %v, want an empty parse list", got["allowed_mentions"])
+	}
+}
+
+func TestPostWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{"accepted", http.StatusNoContent, false},
+		{"rejected", http.StatusBadRequest, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got webhookMessage
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+					t.Errorf("%s with content type %q, want a JSON POST", r.Method, r.Header.Get("Content-Type"))
+				}
+				body, _ := io.ReadAll(r.Body)
+				if err := json.Unmarshal(body, &got); err != nil {
+					t.Errorf("body %s isn't a webhook message: %v", body, err)
+				}
+				w.WriteHeader(tt.status)
+			}))
+			defer server.Close()
+
+			err := postWebhook(server.URL, "New server record!")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("postWebhook error = %v, want an error: %v", err, tt.wantErr)
+			}
+			if got.Content != "New server record!" || got.AllowedMentions.Parse == nil {
+				t.Errorf("posted %+v, want the content without pings", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	apiLeaderboardLimit = 10              // Entries returned by /leaderboard unless ?limit= is given
+	apiShutdownTimeout  = 5 * time.Second // How long in-flight requests get to finish on shutdown
+)
+
+// apiGameStats is one game in a /users/{id}/stats response
+type apiGameStats struct {
+	Game            string  `json:"game"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Sessions        int     `json:"sessions"`
+}
+
+// apiUserStats is the response of /users/{id}/stats
+type apiUserStats struct {
+	UserID               string         `json:"user_id"`
+	TotalDurationSeconds float64        `json:"total_duration_seconds"`
+	Games                []apiGameStats `json:"games"`
+}
+
+// apiLeaderboardEntry is one user in a /leaderboard response
+type apiLeaderboardEntry struct {
+	Rank                 int     `json:"rank"`
+	UserID               string  `json:"user_id"`
+	TotalDurationSeconds float64 `json:"total_duration_seconds"`
+}
+
+// startAPIServer serves the JSON API on addr in the background. Both endpoints take an optional
+// ?guild= parameter and cover every guild the bot tracks without it.
+func startAPIServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}/stats", handleAPIUserStats)
+	mux.HandleFunc("GET /leaderboard", handleAPILeaderboard)
+
+	return startHTTPServer("HTTP API", addr, mux)
+}
+
+// startHTTPServer serves handler on addr in the background, name is used in log messages
+func startHTTPServer(name, addr string, handler http.Handler) *http.Server {
+	server := &http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		slog.Info("HTTP server listening", "server", name, "addr", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP server stopped", "server", name, "error", err)
+		}
+	}()
+	return server
+}
+
+// stopHTTPServer shuts a server down, waiting briefly for requests in flight
+func stopHTTPServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), apiShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Error("Error shutting down HTTP server", "addr", server.Addr, "error", err)
+	}
+}
+
+// apiGuildIDs returns the guilds a request covers, the one given by ?guild= or all of them
+func apiGuildIDs(r *http.Request) []string {
+	if guildID := r.URL.Query().Get("guild"); guildID != "" {
+		return []string{guildID}
+	}
+
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	guildIDs := make([]string, 0, len(data.Guilds))
+	for guildID := range data.Guilds {
+		guildIDs = append(guildIDs, guildID)
+	}
+	return guildIDs
+}
+
+// handleAPIUserStats implements GET /users/{id}/stats: the user's play time and session count per game
+func handleAPIUserStats(w http.ResponseWriter, r *http.Request) {
+	userID := r.PathValue("id")
+	now := time.Now()
+
+	playTimes := make(map[string]time.Duration)
+	counts := make(map[string]int)
+	found := false
+	for _, guildID := range apiGuildIDs(r) {
+		userData, ok := data.snapshotUser(guildID, userID)
+		if !ok {
+			continue
+		}
+		found = true
+		for gameName, duration := range gamePlayTimes(userData, now) {
+			playTimes[gameName] += duration
+		}
+		for gameName, count := range gameSessionCounts(userData) {
+			counts[gameName] += count
+		}
+	}
+	if !found {
+		writeAPIError(w, http.StatusNotFound, "user not found")
+		return
+	}
+
+	stats := apiUserStats{UserID: userID, Games: []apiGameStats{}}
+	for _, game := range rankGames(playTimes) {
+		stats.Games = append(stats.Games, apiGameStats{
+			Game:            game.name,
+			DurationSeconds: game.duration.Seconds(),
+			Sessions:        counts[game.name],
+		})
+		stats.TotalDurationSeconds += game.duration.Seconds()
+	}
+	writeAPIJSON(w, stats)
+}
+
+// handleAPILeaderboard implements GET /leaderboard: users ranked by total play time
+func handleAPILeaderboard(w http.ResponseWriter, r *http.Request) {
+	limit := apiLeaderboardLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			writeAPIError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = parsed
+	}
+
+	guildIDs := apiGuildIDs(r)
+	now := time.Now()
+
+	// Totals are cheap to add up, so they're computed under the lock and everything else after
+	totals := make(map[string]time.Duration)
+	data.mu.Lock()
+	for _, guildID := range guildIDs {
+		for userID, userData := range data.Guilds[guildID] {
+			for _, duration := range gamePlayTimes(userData, now) {
+				totals[userID] += duration
+			}
+		}
+	}
+	data.mu.Unlock()
+
+	entries := make([]apiLeaderboardEntry, 0, len(totals))
+	for userID, total := range totals {
+		if total > 0 {
+			entries = append(entries, apiLeaderboardEntry{UserID: userID, TotalDurationSeconds: total.Seconds()})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].TotalDurationSeconds != entries[j].TotalDurationSeconds {
+			return entries[i].TotalDurationSeconds > entries[j].TotalDurationSeconds
+		}
+		return entries[i].UserID < entries[j].UserID
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	for i := range entries {
+		entries[i].Rank = i + 1
+	}
+	writeAPIJSON(w, entries)
+}
+
+// writeAPIJSON writes v as a JSON response
+func writeAPIJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Error writing HTTP API response", "error", err)
+	}
+}
+
+// writeAPIError writes a JSON error response with the given status
+func writeAPIError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const archiveCheckInterval = time.Hour // How often the month is checked for a rollover
+
+// archiveSessions moves sessions from before the current month out of the data file into one
+// compressed archive file per month, configurable via ARCHIVE_SESSIONS. Only the JSON backend
+// archives, databases don't slow down with history the way a rewritten file does.
+var archiveSessions bool
+
+// archiveMu serializes changes to the archive files. It is never taken while holding data.mu, so
+// archives are written without stalling presence updates.
+var archiveMu sync.Mutex
+
+// guildSessions groups sessions by guild ID, then user ID
+type guildSessions map[string]map[string][]GameSession
+
+func (g guildSessions) add(guildID, userID string, session GameSession) {
+	if g[guildID] == nil {
+		g[guildID] = make(map[string][]GameSession)
+	}
+	g[guildID][userID] = append(g[guildID][userID], session)
+}
+
+// count returns how many sessions there are in all guilds
+func (g guildSessions) count() int {
+	n := 0
+	for _, users := range g {
+		for _, sessions := range users {
+			n += len(sessions)
+		}
+	}
+	return n
+}
+
+// archivePath returns the archive file of a month (YYYY-MM), next to the data file, e.g.
+// game_data_2024-05.json.gz
+func archivePath(month string) string {
+	return archiveBasePath() + "_" + month + ".json.gz"
+}
+
+// archivePaths returns the archive files that exist, oldest month first
+func archivePaths() ([]string, error) {
+	// Glob sorts its matches, and YYYY-MM sorts by date
+	return filepath.Glob(archiveBasePath() + "_[0-9][0-9][0-9][0-9]-[0-9][0-9].json.gz")
+}
+
+// archiveBasePath is the data file path without its extensions
+func archiveBasePath() string {
+	return strings.TrimSuffix(strings.TrimSuffix(dataFilePath, ".gz"), ".json")
+}
+
+// archiveBackend stores an archive file in the data file format, with only sessions set
+func archiveBackend(path string) *jsonBackend {
+	return &jsonBackend{path: path, backupPath: path + backupFileSuffix, compress: true}
+}
+
+// readArchive reads an archive file, a missing file reads as an empty archive
+func readArchive(path string) (persistedData, error) {
+	archive, _, err := readDataFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return persistedData{Version: currentSchemaVersion, Guilds: make(map[string]map[string]*UserGameData)}, nil
+	}
+	return archive, err
+}
+
+// sessionKey identifies a session across the data file and the archives, which both hold it for
+// a moment while it is archived
+func sessionKey(session GameSession) string {
+	if session.ID != "" {
+		return session.ID
+	}
+	return session.GameName + "|" + session.StartTime.UTC().Format(time.RFC3339Nano) // Recorded before sessions had IDs
+}
+
+// archiveOldSessions moves the sessions that ended before the month of now into the archive of the
+// month they started in, and returns how many were moved. Their play time is folded into
+// TrimmedTotals, so totals don't change. Sessions whose archive can't be written stay in the data
+// file and are tried again at the next rollover.
+func (ds *DataStore) archiveOldSessions(now time.Time) (int, error) {
+	// Held throughout, so data deleted while the archives are written is deleted from them after
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+	archivable := func(session GameSession) bool {
+		// Sessions that could still be resumed stay until the merge window has passed
+		return session.EndTime.Before(monthStart) && now.Sub(session.EndTime) > mergeWindow
+	}
+	monthOf := func(session GameSession) string {
+		return session.StartTime.In(now.Location()).Format("2006-01")
+	}
+
+	// Copy the sessions to archive, so the files are written without holding ds.mu
+	byMonth := make(map[string]guildSessions)
+	ds.mu.Lock()
+	for guildID, users := range ds.Guilds {
+		for userID, userData := range users {
+			for _, session := range userData.Sessions {
+				if !archivable(session) {
+					continue
+				}
+				month := monthOf(session)
+				if byMonth[month] == nil {
+					byMonth[month] = make(guildSessions)
+				}
+				byMonth[month].add(guildID, userID, session)
+			}
+		}
+	}
+	ds.mu.Unlock()
+	if len(byMonth) == 0 {
+		return 0, nil
+	}
+
+	// Write the archives first, so a crash before the data file is saved leaves sessions in both
+	// places, which readers tell apart by sessionKey, rather than in neither
+	var errs []error
+	archived := make(map[string]bool) // Key: sessionKey
+	for month, guilds := range byMonth {
+		if err := appendToArchive(archivePath(month), guilds); err != nil {
+			errs = append(errs, fmt.Errorf("error archiving %s: %w", month, err))
+			continue
+		}
+		for _, users := range guilds {
+			for _, sessions := range users {
+				for _, session := range sessions {
+					archived[sessionKey(session)] = true
+				}
+			}
+		}
+	}
+
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	moved := 0
+	for guildID, users := range ds.Guilds {
+		for _, userData := range users {
+			kept := userData.Sessions[:0]
+			for _, session := range userData.Sessions {
+				if archivable(session) && archived[sessionKey(session)] {
+					foldSession(userData, session)
+					moved++
+					ds.invalidateTotalsLocked(guildID)
+					continue
+				}
+				kept = append(kept, session)
+			}
+			userData.Sessions = kept
+		}
+	}
+
+	if moved > 0 {
+		ds.markDirtyLocked()
+		slog.Info("Archived sessions", "sessions", moved, "before", monthStart.Format("2006-01"))
+	}
+	return moved, errors.Join(errs...)
+}
+
+// appendToArchive adds sessions to an archive file, skipping any it already holds
+func appendToArchive(path string, guilds guildSessions) error {
+	archive, err := readArchive(path)
+	if err != nil {
+		return err
+	}
+	for guildID, users := range guilds {
+		if archive.Guilds[guildID] == nil {
+			archive.Guilds[guildID] = make(map[string]*UserGameData)
+		}
+		for userID, sessions := range users {
+			archived := archive.Guilds[guildID][userID]
+			if archived == nil {
+				archived = &UserGameData{}
+				archive.Guilds[guildID][userID] = archived
+			}
+			seen := make(map[string]bool, len(archived.Sessions))
+			for _, session := range archived.Sessions {
+				seen[sessionKey(session)] = true
+			}
+			for _, session := range sessions {
+				if !seen[sessionKey(session)] {
+					archived.Sessions = append(archived.Sessions, session)
+				}
+			}
+		}
+	}
+	return archiveBackend(path).save(archive)
+}
+
+// archivedSessions returns a user's sessions from every archive, oldest month first
+func archivedSessions(guildID, userID string) ([]GameSession, error) {
+	paths, err := archivePaths()
+	if err != nil {
+		return nil, fmt.Errorf("error listing archives: %w", err)
+	}
+	var sessions []GameSession
+	for _, path := range paths {
+		archive, err := readArchive(path)
+		if err != nil {
+			return nil, err
+		}
+		if archived := archive.Guilds[guildID][userID]; archived != nil {
+			sessions = append(sessions, archived.Sessions...)
+		}
+	}
+	return sessions, nil
+}
+
+// withArchivedSessions adds a user's archived sessions to a snapshot of their data, for commands
+// that report on each session of their history. They are taken out of TrimmedTotals and
+// TrimmedCounts again, so they aren't counted twice. The snapshot is returned as is if nothing is archived or the
+// archives can't be read.
+func withArchivedSessions(userData *UserGameData, guildID, userID string) *UserGameData {
+	if !archiveSessions {
+		return userData
+	}
+	archived, err := archivedSessions(guildID, userID)
+	if err != nil {
+		slog.Warn("Could not read archived sessions, using recent sessions only", "user_id", userID, "guild_id", guildID, "error", err)
+		return userData
+	}
+	if len(archived) == 0 {
+		return userData
+	}
+
+	// A session being archived right now can be in both
+	live := make(map[string]bool, len(userData.Sessions))
+	for _, session := range userData.Sessions {
+		live[sessionKey(session)] = true
+	}
+	sessions := make([]GameSession, 0, len(archived)+len(userData.Sessions))
+	for _, session := range archived {
+		if live[sessionKey(session)] {
+			continue // Not folded into TrimmedTotals yet either
+		}
+		sessions = append(sessions, session)
+		unfoldSession(userData, session)
+	}
+	userData.Sessions = append(sessions, userData.Sessions...)
+	return userData
+}
+
+// unfoldSession takes an archived session out of TrimmedTotals and TrimmedCounts again, for when
+// the session itself is counted or was deleted
+func unfoldSession(userData *UserGameData, session GameSession) {
+	if count := userData.TrimmedCounts[session.GameName]; count > 1 {
+		userData.TrimmedCounts[session.GameName] = count - 1
+	} else {
+		delete(userData.TrimmedCounts, session.GameName)
+	}
+	remaining, ok := userData.TrimmedTotals[session.GameName]
+	if !ok {
+		return
+	}
+	// Below a second is what float rounding leaves of a total that was all archived sessions
+	if remaining -= session.Duration; remaining < 1 {
+		delete(userData.TrimmedTotals, session.GameName)
+	} else {
+		userData.TrimmedTotals[session.GameName] = remaining
+	}
+}
+
+// unfoldDeletedLocked takes archived sessions that were deleted out of the totals of their users.
+// The caller must hold ds.mu.
+func (ds *DataStore) unfoldDeletedLocked(deleted guildSessions) {
+	for guildID, users := range deleted {
+		for userID, sessions := range users {
+			userData, ok := ds.Guilds[guildID][userID]
+			if !ok {
+				continue
+			}
+			for _, session := range sessions {
+				unfoldSession(userData, session)
+			}
+			ds.invalidateTotalsLocked(guildID)
+			ds.markDirtyLocked()
+		}
+	}
+}
+
+// deleteArchivedSessions removes the archived sessions keep rejects, so deleting data also
+// reaches history that was archived, and returns the removed sessions. The previous copy of a
+// rewritten archive is deleted too, as it still holds them. It must not be called with data.mu held.
+func deleteArchivedSessions(keep func(guildID, userID string, session GameSession) bool) (guildSessions, error) {
+	if !archiveSessions {
+		return nil, nil
+	}
+	archiveMu.Lock()
+	defer archiveMu.Unlock()
+	paths, err := archivePaths()
+	if err != nil {
+		return nil, fmt.Errorf("error listing archives: %w", err)
+	}
+
+	removed := make(guildSessions)
+	var errs []error
+	for _, path := range paths {
+		archive, err := readArchive(path)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		removedHere := make(guildSessions)
+		for guildID, users := range archive.Guilds {
+			for userID, archived := range users {
+				if archived == nil {
+					continue
+				}
+				kept := archived.Sessions[:0]
+				for _, session := range archived.Sessions {
+					if keep(guildID, userID, session) {
+						kept = append(kept, session)
+					} else {
+						removedHere.add(guildID, userID, session)
+					}
+				}
+				archived.Sessions = kept
+				if len(kept) == 0 {
+					delete(users, userID)
+				}
+			}
+			if len(users) == 0 {
+				delete(archive.Guilds, guildID)
+			}
+		}
+		if len(removedHere) == 0 {
+			continue
+		}
+
+		backend := archiveBackend(path)
+		if err := backend.save(archive); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := os.Remove(backend.backupPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("error removing archive backup: %w", err))
+		}
+		for guildID, users := range removedHere {
+			for userID, sessions := range users {
+				for _, session := range sessions {
+					removed.add(guildID, userID, session)
+				}
+			}
+		}
+	}
+	return removed, errors.Join(errs...)
+}
+
+// runArchive archives old sessions whenever a new month starts, checking every
+// archiveCheckInterval until stop is closed
+func runArchive(stop <-chan struct{}) {
+	ticker := time.NewTicker(archiveCheckInterval)
+	defer ticker.Stop()
+
+	lastMonth := time.Now().Format("2006-01")
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			if month := now.Format("2006-01"); month != lastMonth {
+				if _, err := data.archiveOldSessions(now); err != nil {
+					slog.Error("Error archiving sessions", "error", err)
+				}
+				lastMonth = month
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// setUpArchives points the data file at a temporary directory and enables archiving
+func setUpArchives(t *testing.T) *DataStore {
+	t.Helper()
+	store := newTestStore(t)
+	setForTest(t, &dataFilePath, filepath.Join(t.TempDir(), "game_data.json"))
+	setForTest(t, &archiveSessions, true)
+	setForTest(t, &mergeWindow, 0)
+	return store
+}
+
+func TestArchivePath(t *testing.T) {
+	tests := []struct {
+		dataFile string
+		want     string
+	}{
+		{"game_data.json", "game_data_2024-05.json.gz"},
+		{"game_data.json.gz", "game_data_2024-05.json.gz"},
+		{"/var/lib/tracker/data", "/var/lib/tracker/data_2024-05.json.gz"},
+	}
+	for _, tt := range tests {
+		setForTest(t, &dataFilePath, tt.dataFile)
+		if got := archivePath("2024-05"); got != tt.want {
+			t.Errorf("archivePath with data file %q = %q, want %q", tt.dataFile, got, tt.want)
+		}
+	}
+}
+
+func TestArchiveOldSessions(t *testing.T) {
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		starts       []time.Time
+		wantMoved    int
+		wantLive     int
+		wantArchives int
+	}{
+		{"nothing old", []time.Time{now.Add(-48 * time.Hour)}, 0, 1, 0},
+		{"one month", []time.Time{time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC), now.Add(-48 * time.Hour)}, 1, 1, 1},
+		{"two months", []time.Time{time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC), time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC)}, 2, 0, 2},
+		{"ends after the rollover", []time.Time{time.Date(2024, 5, 31, 23, 30, 0, 0, time.UTC)}, 0, 1, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := setUpArchives(t)
+			for _, start := range tt.starts {
+				addSession(store, "1", "Minecraft", start, time.Hour)
+			}
+			userData, _ := store.snapshotUser("guild", "1")
+			before := gamePlayTimes(userData, now)
+
+			moved, err := store.archiveOldSessions(now)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if moved != tt.wantMoved {
+				t.Errorf("moved %d sessions, want %d", moved, tt.wantMoved)
+			}
+			userData, _ = store.snapshotUser("guild", "1")
+			if len(userData.Sessions) != tt.wantLive {
+				t.Errorf("%d sessions left in the data file, want %d", len(userData.Sessions), tt.wantLive)
+			}
+			if after := gamePlayTimes(userData, now); after["Minecraft"] != before["Minecraft"] {
+				t.Errorf("total after archiving = %v, want %v as before", after["Minecraft"], before["Minecraft"])
+			}
+			paths, err := archivePaths()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(paths) != tt.wantArchives {
+				t.Errorf("archive files = %v, want %d", paths, tt.wantArchives)
+			}
+
+			// Reading the archives back gives every session, counted once
+			full := withArchivedSessions(userData, "guild", "1")
+			if len(full.Sessions) != len(tt.starts) {
+				t.Errorf("%d sessions with the archives, want %d", len(full.Sessions), len(tt.starts))
+			}
+			if got := gamePlayTimes(full, now); got["Minecraft"] != before["Minecraft"] {
+				t.Errorf("total with the archives = %v, want %v", got["Minecraft"], before["Minecraft"])
+			}
+
+			// Archiving again finds nothing new
+			if moved, err := store.archiveOldSessions(now); err != nil || moved != 0 {
+				t.Errorf("archiving again moved %d sessions with error %v, want none", moved, err)
+			}
+		})
+	}
+}
+
+func TestWithArchivedSessionsSkipsLiveCopies(t *testing.T) {
+	store := setUpArchives(t)
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	session := addSession(store, "1", "Minecraft", time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC), time.Hour)
+
+	// As after a crash between writing the archive and saving the data file
+	if err := appendToArchive(archivePath("2024-05"), guildSessions{"guild": {"1": {session}}}); err != nil {
+		t.Fatal(err)
+	}
+	userData, _ := store.snapshotUser("guild", "1")
+	full := withArchivedSessions(userData, "guild", "1")
+	if len(full.Sessions) != 1 {
+		t.Errorf("%d sessions, want the one in both places once", len(full.Sessions))
+	}
+	if got := gamePlayTimes(full, now)["Minecraft"]; got != time.Hour {
+		t.Errorf("total = %v, want 1h", got)
+	}
+}
+
+func TestDeleteArchivedSessions(t *testing.T) {
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		keep        func(guildID, userID string, session GameSession) bool
+		wantRemoved int
+		wantLeft    map[string]int // Sessions left in the archives per user
+	}{
+		{"keep all", func(string, string, GameSession) bool { return true }, 0, map[string]int{"1": 2, "2": 1}},
+		{"one user", func(_, userID string, _ GameSession) bool { return userID != "1" }, 2, map[string]int{"1": 0, "2": 1}},
+		{"one game", func(_, _ string, s GameSession) bool { return s.GameName != "Tetris" }, 1, map[string]int{"1": 1, "2": 1}},
+		{"whole guild", func(guildID, _ string, _ GameSession) bool { return guildID != "guild" }, 3, map[string]int{"1": 0, "2": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := setUpArchives(t)
+			addSession(store, "1", "Minecraft", time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC), time.Hour)
+			addSession(store, "1", "Tetris", time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC), time.Hour)
+			addSession(store, "2", "Minecraft", time.Date(2024, 5, 11, 0, 0, 0, 0, time.UTC), time.Hour)
+			if _, err := store.archiveOldSessions(now); err != nil {
+				t.Fatal(err)
+			}
+
+			removed, err := deleteArchivedSessions(tt.keep)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if removed.count() != tt.wantRemoved {
+				t.Errorf("removed %d sessions, want %d", removed.count(), tt.wantRemoved)
+			}
+			for userID, want := range tt.wantLeft {
+				sessions, err := archivedSessions("guild", userID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(sessions) != want {
+					t.Errorf("user %s has %d archived sessions, want %d", userID, len(sessions), want)
+				}
+			}
+		})
+	}
+}
+
+func TestPruneOldSessionsUnfoldsArchives(t *testing.T) {
+	store := setUpArchives(t)
+	now := time.Date(2024, 6, 3, 12, 0, 0, 0, time.UTC)
+	addSession(store, "1", "Minecraft", time.Date(2024, 4, 10, 0, 0, 0, 0, time.UTC), time.Hour)
+	addSession(store, "1", "Minecraft", time.Date(2024, 5, 10, 0, 0, 0, 0, time.UTC), 2*time.Hour)
+	if _, err := store.archiveOldSessions(now); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed := store.pruneOldSessions(time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)); removed != 1 {
+		t.Errorf("pruned %d sessions, want the April one", removed)
+	}
+	userData, _ := store.snapshotUser("guild", "1")
+	if got := gamePlayTimes(userData, now)["Minecraft"]; got != 2*time.Hour {
+		t.Errorf("total after pruning = %v, want 2h of the May session", got)
+	}
+}
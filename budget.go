@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	sweepInterval   = time.Minute // How often active sessions are checked for budgets and break reminders
+	budgetWarnRatio = 0.8         // Fraction of the daily budget at which the first warning is sent
+)
+
+// Budget warning levels, stored in UserGameData.BudgetWarnLevel
+const (
+	budgetWarnNone = iota
+	budgetWarnApproaching
+	budgetWarnReached
+)
+
+// handleBudget implements the !budget command: show, set or clear the user's daily budget
+func handleBudget(s messageSender, m *discordgo.MessageCreate, args string) {
+	userID := m.Author.ID
+	username := m.Author.Username
+
+	if args == "" {
+		userData, ok := data.snapshotUser(m.GuildID, userID)
+		if !ok || userData.DailyBudget <= 0 {
+			sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you don't have a daily budget set. Use `%sbudget 3h` to set one.", username, commandPrefix))
+			return
+		}
+		now := time.Now().In(userLocation(userData))
+		budget := time.Duration(userData.DailyBudget) * time.Second
+		played := playTimeBetween(userData, startOfDay(now), now)
+		format := userDurationFormat(userData)
+
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, your daily budget is %s and you've played %s today.", username, format(budget), format(played)))
+		return
+	}
+
+	var budget time.Duration
+	if !strings.EqualFold(args, "off") {
+		var err error
+		budget, err = parsePlayDuration(args)
+		if err != nil || budget <= 0 {
+			sendUsage(s, m.ChannelID, "budget")
+			return
+		}
+	}
+
+	data.mu.Lock()
+	userData := data.getOrCreateUser(m.GuildID, userID)
+	format := userDurationFormat(userData)
+	userData.DailyBudget = budget.Seconds()
+	// Reset the warning state so the new budget gets its own warnings today
+	userData.BudgetWarnDay = ""
+	userData.BudgetWarnLevel = budgetWarnNone
+	if err := data.saveLocked(); err != nil {
+		slog.Error("Error saving budget", "username", username, "error", err)
+	}
+	data.mu.Unlock()
+
+	if budget == 0 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, your daily budget has been removed.", username))
+		return
+	}
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, your daily budget is now %s. I'll DM you when you're getting close and when you reach it.", username, format(budget)))
+}
+
+// runSweeper periodically checks active sessions until stop is closed
+func runSweeper(s *discordgo.Session, stop <-chan struct{}) {
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			checkBudgets(s, now)
+			checkBreakReminders(s, now)
+		}
+	}
+}
+
+// checkBudgets compares each user's play time today against their daily budget and
+// DMs a warning when they approach or reach it. Each warning is sent at most once per day, days
+// going by the user's timezone. Users can turn the warnings off with !notify, and warnings due
+// during their quiet hours wait until the quiet hours end.
+func checkBudgets(s messageSender, now time.Time) {
+	type warning struct {
+		userID  string
+		message string
+	}
+	var warnings []warning
+
+	data.mu.Lock()
+	for _, users := range data.Guilds {
+		for userID, userData := range users {
+			if userData.DailyBudget <= 0 || userData.MuteBudgetWarnings {
+				continue
+			}
+
+			local := now.In(userLocation(userData))
+			today := local.Format("2006-01-02")
+			warned := userData.BudgetWarnLevel
+			if userData.BudgetWarnDay != today {
+				warned = budgetWarnNone // Warnings from a previous day don't count
+			}
+
+			budget := time.Duration(userData.DailyBudget) * time.Second
+			played := playTimeBetween(userData, startOfDay(local), now)
+			format := userDurationFormat(userData)
+
+			level := budgetWarnNone
+			var message string
+			if played >= budget {
+				level = budgetWarnReached
+				message = fmt.Sprintf("You've reached your daily play-time budget of %s. Time for a break!", format(budget))
+			} else if float64(played) >= float64(budget)*budgetWarnRatio {
+				level = budgetWarnApproaching
+				message = fmt.Sprintf("Heads up: you've played %s today, close to your daily budget of %s.", format(played), format(budget))
+			}
+			if level <= warned || inQuietHours(userData, now) {
+				continue
+			}
+
+			userData.BudgetWarnDay = today
+			userData.BudgetWarnLevel = level
+			warnings = append(warnings, warning{userID: userID, message: message})
+		}
+	}
+	// Persist the warning state so a restart doesn't send the same warning again
+	if len(warnings) > 0 {
+		data.markDirtyLocked()
+	}
+	data.mu.Unlock()
+
+	for _, w := range warnings {
+		if err := sendDM(s, w.userID, w.message); err != nil {
+			slog.Warn("Could not send budget warning", "user_id", w.userID, "error", err)
+		}
+	}
+}
+
+// playTimeBetween sums the portion of a user's sessions, including active ones, that falls within [from, to)
+func playTimeBetween(userData *UserGameData, from, to time.Time) time.Duration {
+	var total time.Duration
+	for _, session := range userData.Sessions {
+		total += overlap(session.StartTime, session.EndTime, from, to)
+	}
+	for gameName, startTime := range userData.ActiveGames {
+		total += overlap(activeStart(userData, gameName, startTime), capSessionEnd(startTime, to), from, to)
+	}
+	return total
+}
+
+// overlap returns how much of the interval [start, end) lies within [from, to)
+func overlap(start, end, from, to time.Time) time.Duration {
+	if start.Before(from) {
+		start = from
+	}
+	if end.After(to) {
+		end = to
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// startOfDay returns midnight of the day containing t, in t's location
+func startOfDay(t time.Time) time.Time {
+	year, month, day := t.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCheckBudgetsUsesUserSettings(t *testing.T) {
+	now := time.Date(2024, 6, 10, 2, 0, 0, 0, time.UTC)
+	// 06:00 to 08:00 on June 10 at UTC+10, but still June 9 in UTC
+	sessionStart := time.Date(2024, 6, 9, 20, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		timezone string
+		format   string
+		wantDM   string // Empty for no warning
+		wantDay  string
+	}{
+		{"utc", "", "", "", ""},
+		{"ahead of utc", "Etc/GMT-10", "", "budget of 2h", "2024-06-10"},
+		{"verbose format", "Etc/GMT-10", "verbose", "budget of 2 hours", "2024-06-10"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			addSession(store, "1", "Minecraft", sessionStart, 2*time.Hour)
+			store.mu.Lock()
+			userData := store.Guilds["guild"]["1"]
+			userData.DailyBudget = (2 * time.Hour).Seconds()
+			userData.Timezone = tt.timezone
+			userData.DurationFormat = tt.format
+			store.mu.Unlock()
+			s := newFakeSession()
+
+			checkBudgets(s, now)
+
+			dms := s.messages("dm-1")
+			if tt.wantDM == "" {
+				if len(dms) > 0 {
+					t.Errorf("budget warnings = %q, want none", dms)
+				}
+			} else if len(dms) != 1 || !strings.Contains(dms[0], tt.wantDM) {
+				t.Errorf("budget warnings = %q, want one containing %q", dms, tt.wantDM)
+			}
+			snapshot, _ := store.snapshotUser("guild", "1")
+			if snapshot.BudgetWarnDay != tt.wantDay {
+				t.Errorf("warning day = %q, want %q", snapshot.BudgetWarnDay, tt.wantDay)
+			}
+		})
+	}
+}
+
+func TestCheckBudgetsRespectsPreferences(t *testing.T) {
+	now := time.Date(2024, 6, 10, 23, 30, 0, 0, time.UTC)
+	tests := []struct {
+		name       string
+		muted      bool
+		quietHours string
+		wantDM     bool
+	}{
+		{"no preferences", false, "", true},
+		{"warnings off", true, "", false},
+		{"quiet hours", false, "23-7", false},
+		{"quiet hours over", false, "7-23", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			addSession(store, "1", "Minecraft", now.Add(-3*time.Hour), 2*time.Hour)
+			store.mu.Lock()
+			userData := store.Guilds["guild"]["1"]
+			userData.DailyBudget = (2 * time.Hour).Seconds()
+			userData.MuteBudgetWarnings = tt.muted
+			userData.QuietHours = tt.quietHours
+			store.mu.Unlock()
+			s := newFakeSession()
+
+			checkBudgets(s, now)
+
+			if dms := s.messages("dm-1"); (len(dms) > 0) != tt.wantDM {
+				t.Errorf("budget warnings = %q, want a warning: %v", dms, tt.wantDM)
+			}
+		})
+	}
+}
+
+// TestQuietHoursDelayWarning checks that a warning held back during quiet hours is sent once they end
+func TestQuietHoursDelayWarning(t *testing.T) {
+	store := newTestStore(t)
+	night := time.Date(2024, 6, 10, 6, 0, 0, 0, time.UTC)
+	addSession(store, "1", "Minecraft", night.Add(-3*time.Hour), 2*time.Hour)
+	store.mu.Lock()
+	userData := store.Guilds["guild"]["1"]
+	userData.DailyBudget = (time.Hour).Seconds()
+	userData.QuietHours = "23-7"
+	store.mu.Unlock()
+	s := newFakeSession()
+
+	checkBudgets(s, night)
+	if dms := s.messages("dm-1"); len(dms) > 0 {
+		t.Fatalf("budget warnings during quiet hours = %q, want none", dms)
+	}
+	checkBudgets(s, night.Add(time.Hour))
+	if dms := s.messages("dm-1"); len(dms) != 1 {
+		t.Errorf("budget warnings after quiet hours = %q, want one", dms)
+	}
+}
+
+func TestInQuietHours(t *testing.T) {
+	tests := []struct {
+		quietHours string
+		timezone   string
+		hour       int // UTC
+		want       bool
+	}{
+		{"", "", 3, false},
+		{"23-7", "", 23, true},
+		{"23-7", "", 3, true},
+		{"23-7", "", 7, false},
+		{"23-7", "", 12, false},
+		{"9-17", "", 9, true},
+		{"9-17", "", 17, false},
+		{"23-7", "Etc/GMT-10", 14, true}, // Midnight at UTC+10
+		{"23-7", "Etc/GMT-10", 23, false},
+	}
+	for _, tt := range tests {
+		userData := newUserGameData()
+		userData.QuietHours = tt.quietHours
+		userData.Timezone = tt.timezone
+		at := time.Date(2024, 6, 10, tt.hour, 0, 0, 0, time.UTC)
+		if got := inQuietHours(userData, at); got != tt.want {
+			t.Errorf("inQuietHours(%q in %q at %02d:00 UTC) = %v, want %v", tt.quietHours, tt.timezone, tt.hour, got, tt.want)
+		}
+	}
+}
+
+func TestParseQuietHours(t *testing.T) {
+	tests := []struct {
+		value      string
+		start, end int
+		wantErr    bool
+	}{
+		{"23-7", 23, 7, false},
+		{" 9 - 17 ", 9, 17, false},
+		{"0-23", 0, 23, false},
+		{"7", 0, 0, true},
+		{"7-7", 0, 0, true},
+		{"22-24", 0, 0, true},
+		{"-1-5", 0, 0, true},
+		{"a-b", 0, 0, true},
+	}
+	for _, tt := range tests {
+		start, end, err := parseQuietHours(tt.value)
+		if (err != nil) != tt.wantErr || start != tt.start || end != tt.end {
+			t.Errorf("parseQuietHours(%q) = %d, %d, %v, want %d, %d, error: %v", tt.value, start, end, err, tt.start, tt.end, tt.wantErr)
+		}
+	}
+}
+
+func TestBudgetCommand(t *testing.T) {
+	tests := []struct {
+		content    string
+		wantBudget float64
+	}{
+		{"!budget 3h", 3 * 3600},
+		{"!budget off", 0},
+		{"!budget OFF", 0},
+		{"!budget nonsense", 3600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			store.mu.Lock()
+			store.getOrCreateUser("guild", "1").DailyBudget = 3600
+			store.mu.Unlock()
+
+			dispatchCommand(newFakeSession(), testMessage("1", tt.content))
+
+			if snapshot, _ := store.snapshotUser("guild", "1"); snapshot.DailyBudget != tt.wantBudget {
+				t.Errorf("budget = %v, want %v", snapshot.DailyBudget, tt.wantBudget)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const clearAllConfirmTimeout = 30 * time.Second // How long an admin has to confirm !clearall
+
+// pendingClear is a !clearall waiting for the admin who ran it to confirm
+type pendingClear struct {
+	channelID string
+	expires   time.Time
+}
+
+// Pending !clearall confirmations, keyed by guild ID and user ID
+var (
+	pendingClears   = make(map[string]pendingClear)
+	pendingClearsMu sync.Mutex
+)
+
+// handleClearAll implements the !clearall command: ask an admin to confirm wiping everyone's data in this guild
+func handleClearAll(s messageSender, m *discordgo.MessageCreate, args string) {
+	if !canAdminister(s, m) {
+		sendChunked(s, m.ChannelID, "Sorry, only admins can clear everyone's data.")
+		return
+	}
+	if m.GuildID == "" {
+		sendChunked(s, m.ChannelID, "This command only works in a server.")
+		return
+	}
+
+	pendingClearsMu.Lock()
+	pendingClears[m.GuildID+":"+m.Author.ID] = pendingClear{channelID: m.ChannelID, expires: time.Now().Add(clearAllConfirmTimeout)}
+	pendingClearsMu.Unlock()
+
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, this deletes the tracked data of **everyone** in this server and can't be undone. Reply `confirm` within %s to go ahead.", m.Author.Username, formatDuration(clearAllConfirmTimeout)))
+}
+
+// handleClearAllConfirmation handles the reply to a pending !clearall and reports whether the
+// message was one. Any other reply cancels the pending clear.
+func handleClearAllConfirmation(s messageSender, m *discordgo.MessageCreate) bool {
+	key := m.GuildID + ":" + m.Author.ID
+	now := time.Now()
+
+	pendingClearsMu.Lock()
+	pending, ok := pendingClears[key]
+	expired := ok && now.After(pending.expires)
+	if expired || (ok && pending.channelID == m.ChannelID) {
+		delete(pendingClears, key)
+	}
+	pendingClearsMu.Unlock()
+
+	// Too late or somewhere else, handle the message normally
+	if !ok || expired || pending.channelID != m.ChannelID {
+		return false
+	}
+	if !strings.EqualFold(strings.TrimSpace(m.Content), "confirm") {
+		sendChunked(s, m.ChannelID, "Clearing everyone's data was cancelled.")
+		return false
+	}
+
+	// Games being played right now keep being tracked, from this moment on
+	data.mu.Lock()
+	users := data.Guilds[m.GuildID]
+	cleared := len(users)
+	for userID, userData := range users {
+		if len(userData.ActiveGames) == 0 {
+			delete(users, userID)
+			continue
+		}
+		users[userID] = clearedUserData(userData, now)
+	}
+	data.invalidateTotalsLocked(m.GuildID)
+	if err := data.saveLocked(); err != nil {
+		slog.Error("Error saving after clearing guild", "guild_id", m.GuildID, "error", err)
+	}
+	data.mu.Unlock()
+	if _, err := deleteArchivedSessions(func(guildID, userID string, session GameSession) bool {
+		return guildID != m.GuildID
+	}); err != nil {
+		slog.Error("Error clearing archived sessions of guild", "guild_id", m.GuildID, "error", err)
+	}
+
+	slog.Info("Cleared guild data", "username", m.Author.Username, "guild_id", m.GuildID, "users", cleared)
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Done, I've cleared the tracked data of everyone in this server, %s.", m.Author.Username))
+	return true
+}
@@ -0,0 +1,396 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	defaultCommandPrefix = "!"
+	// Minimum time between "unknown command" replies in the same channel
+	unknownCommandCooldown = 30 * time.Second
+	// Minimum time between two commands of the same user by default
+	defaultCommandCooldown = 5 * time.Second
+	// Discord rejects messages longer than this many characters
+	maxMessageLength = 2000
+	// How often safeSend tries to send a message before giving up
+	sendAttempts = 3
+)
+
+// sendRetryDelay is how long safeSend waits before its first retry, doubling with each further one
+var sendRetryDelay = time.Second
+
+var (
+	// commandPrefix starts every command, configurable via COMMAND_PREFIX
+	commandPrefix = defaultCommandPrefix
+	// commandCooldown is the minimum time between two commands of a user, configurable via
+	// COMMAND_COOLDOWN. 0 disables it.
+	commandCooldown = defaultCommandCooldown
+	// botAdmins are the IDs of users allowed to run admin commands anywhere, configurable via
+	// BOT_ADMINS as a comma-separated list
+	botAdmins = make(map[string]bool)
+)
+
+// command describes a text command handled by messageCreate
+type command struct {
+	name        string
+	usage       string // Arguments shown in !help and usage replies, empty if the command takes none
+	description string
+	// argsRequired makes messageCreate reply with the usage instead of running the handler when no
+	// arguments are given
+	argsRequired bool
+	// storesData marks commands that save settings or sessions of the user, which are refused for
+	// users who opted out so nothing about them is stored again
+	storesData bool
+	handler    func(s messageSender, m *discordgo.MessageCreate, args string)
+}
+
+// messageSender is the part of *discordgo.Session that commands and presence updates use to reply.
+// They take it rather than the session, so they work with anything that can stand in for one.
+type messageSender interface {
+	ChannelMessageSend(channelID, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelMessageEdit(channelID, messageID, content string, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	ChannelFileSend(channelID, name string, r io.Reader, options ...discordgo.RequestOption) (*discordgo.Message, error)
+	MessageReactionAdd(channelID, messageID, emojiID string, options ...discordgo.RequestOption) error
+	MessageReactionRemove(channelID, messageID, emojiID, userID string, options ...discordgo.RequestOption) error
+	MessageReactionsRemoveAll(channelID, messageID string, options ...discordgo.RequestOption) error
+	UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error)
+	UserChannelPermissions(userID, channelID string, fetchOptions ...discordgo.RequestOption) (int64, error)
+}
+
+var _ messageSender = (*discordgo.Session)(nil)
+
+// commands lists every command the bot understands, in the order shown by !help.
+// It is populated in init because handleHelp refers back to it.
+var commands []command
+
+var (
+	// Last time an "unknown command" reply was sent, keyed by channel ID
+	unknownReplies   = make(map[string]time.Time)
+	unknownRepliesMu sync.Mutex
+
+	// Last time each user ran a command, keyed by user ID
+	lastCommands   = make(map[string]time.Time)
+	lastCommandsMu sync.Mutex
+)
+
+func init() {
+	commands = []command{
+		{name: "mygames", usage: "[game|streaming|listening]", description: "Show your total play time per game, optionally for one activity type", handler: handleMyGames},
+		{name: "profile", description: "Show your key stats at a glance", handler: handleProfile},
+		{name: "favorite", description: "Show your most played game", handler: handleFavorite},
+		{name: "longest", description: "Show your longest session ever", handler: handleLongest},
+		{name: "streak", description: "Show how many days in a row you've played", handler: handleStreak},
+		{name: "weekly", description: "Show what you played in the last 7 days", handler: handleWeekly},
+		{name: "monthly", description: "Show what you played this month", handler: handleMonthly},
+		{name: "sessions", usage: "[game name]", description: "List your most recent sessions, optionally for one game", handler: handleSessions},
+		{name: "gamestats", usage: "<game name>", description: "Show detailed stats for one of your games", handler: handleGameStats, argsRequired: true},
+		{name: "heatmap", description: "Show what time of day you play the most", handler: handleHeatmap},
+		{name: "achievements", description: "Show the play-time milestones you've unlocked", handler: handleAchievements},
+		{name: "topgames", description: "Show the most played games in this server", handler: handleTopGames},
+		{name: "nowplaying", description: "Show who in this server is playing something right now", handler: handleNowPlaying},
+		{name: "records", description: "Show the longest sessions anyone in this server has played", handler: handleRecords},
+		{name: "mvp", description: "Show who played the most in this server this week", handler: handleMVP},
+		{name: "rank", description: "Show where you stand on this server's play-time leaderboard", handler: handleRank},
+		{name: "compare", usage: "@member", description: "Compare your play time with another member on the games you both play", handler: handleCompare, argsRequired: true},
+		{name: "resetgame", usage: "<game name>", description: "Delete your history of one game", handler: handleResetGame, argsRequired: true},
+		{name: "cleargames", description: "Delete all of your tracked game data", handler: handleClearGames},
+		{name: "clearall", description: "Delete everyone's tracked data in this server (admins only)", handler: handleClearAll},
+		{name: "optout", description: "Stop tracking you and delete all of your data", handler: handleOptOut},
+		{name: "optin", description: "Start tracking you again after opting out", handler: handleOptIn},
+		{name: "export", usage: "[csv|json]", description: "Get a file with all of your sessions in a DM", handler: handleExport},
+		{name: "import", description: "Add sessions from an attached JSON file in the format of a JSON export", handler: handleImport, storesData: true},
+		{name: "budget", usage: "[duration|off]", description: "Show or set a daily play-time budget, e.g. `3h` or `90m`", handler: handleBudget, storesData: true},
+		{name: "playtime", usage: "@member", description: "Show a member's total play time and top games (admins only)", handler: handlePlaytime, argsRequired: true},
+		{name: "remind", usage: "[duration|off]", description: "Get a DM reminding you to take a break after playing for a while, e.g. `2h`", handler: handleRemind, storesData: true},
+		{name: "notify", usage: "[sessions|budget|milestones] [on|off]", description: "Choose which DMs you get: session summaries, budget warnings or milestone congratulations", handler: handleNotify, storesData: true},
+		{name: "quiet", usage: "[start-end|off]", description: "Hold back budget warnings during these hours of your day, e.g. `23-7`", handler: handleQuiet, storesData: true},
+		{name: "goal", usage: "[set <duration>|off|<game> <duration>|<game> off]", description: "Show your progress towards your play-time goals, or set a weekly one or one for a game, e.g. `10h`", handler: handleGoal, storesData: true},
+		{name: "stats", description: "Show tracking totals for this server (admins only)", handler: handleStats},
+		{name: "debug", usage: "@member", description: "DM you a member's raw tracking state, to troubleshoot missing play time (admins only)", handler: handleDebug, argsRequired: true},
+		{name: "whenjoined", description: "Show since when you've been tracked", handler: handleWhenJoined},
+		{name: "format", usage: "[hours|compact|verbose]", description: "Choose how durations are shown to you, e.g. `42.5h` or `1d 2h 3m`", handler: handleFormat, storesData: true},
+		{name: "settz", usage: "<timezone>", description: "Set the timezone your dates are shown in, e.g. `America/New_York`, or `UTC` to reset", handler: handleSetTZ, argsRequired: true, storesData: true},
+		{name: "botinfo", description: "Show the bot's uptime and how much it is tracking", handler: handleBotInfo},
+		{name: "help", description: "List the available commands", handler: handleHelp},
+	}
+}
+
+// parseCommand splits a message into the command word after the prefix and the remaining arguments
+func parseCommand(content string) (name, args string) {
+	content = strings.TrimSpace(strings.TrimPrefix(content, commandPrefix))
+	if i := strings.IndexFunc(content, func(r rune) bool { return r == ' ' || r == '\n' || r == '\t' }); i >= 0 {
+		return strings.ToLower(content[:i]), strings.TrimSpace(content[i:])
+	}
+	return strings.ToLower(content), ""
+}
+
+// findCommand looks up a command by name
+func findCommand(name string) (command, bool) {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+	return command{}, false
+}
+
+// commandUsage returns how a command is invoked, e.g. "!gamestats <game name>"
+func commandUsage(cmd command) string {
+	if cmd.usage == "" {
+		return commandPrefix + cmd.name
+	}
+	return commandPrefix + cmd.name + " " + cmd.usage
+}
+
+// sendUsage replies with the usage of a command, for handlers given arguments they can't use
+func sendUsage(s messageSender, channelID, name string) {
+	cmd, ok := findCommand(name)
+	if !ok {
+		return
+	}
+	sendChunked(s, channelID, fmt.Sprintf("Usage: `%s`", commandUsage(cmd)))
+}
+
+// handleHelp implements the !help command
+func handleHelp(s messageSender, m *discordgo.MessageCreate, args string) {
+	response := "Here's what I can do:\n"
+	for _, cmd := range commands {
+		response += fmt.Sprintf("- `%s`: %s\n", commandUsage(cmd), cmd.description)
+	}
+	sendChunked(s, m.ChannelID, response)
+}
+
+// handleUnknownCommand replies to an unrecognized command, at most once per cooldown per channel
+func handleUnknownCommand(s messageSender, m *discordgo.MessageCreate, name string) {
+	now := time.Now()
+
+	unknownRepliesMu.Lock()
+	// Drop expired entries so the map doesn't grow with every channel ever seen
+	for channelID, sentAt := range unknownReplies {
+		if now.Sub(sentAt) >= unknownCommandCooldown {
+			delete(unknownReplies, channelID)
+		}
+	}
+	if _, recent := unknownReplies[m.ChannelID]; recent {
+		unknownRepliesMu.Unlock()
+		return
+	}
+	unknownReplies[m.ChannelID] = now
+	unknownRepliesMu.Unlock()
+
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Unknown command `%s%s`, try `%shelp`.", commandPrefix, name, commandPrefix))
+}
+
+// onCommandCooldown reports whether the user ran a command less than commandCooldown ago.
+// Otherwise the command is recorded as run at now.
+func onCommandCooldown(userID string, now time.Time) bool {
+	if commandCooldown <= 0 {
+		return false
+	}
+
+	lastCommandsMu.Lock()
+	defer lastCommandsMu.Unlock()
+
+	// Drop expired entries so the map doesn't grow with every user ever seen
+	for id, ranAt := range lastCommands {
+		if now.Sub(ranAt) >= commandCooldown {
+			delete(lastCommands, id)
+		}
+	}
+	if _, recent := lastCommands[userID]; recent {
+		return true
+	}
+	lastCommands[userID] = now
+	return false
+}
+
+// markdownEscaper escapes the characters Discord treats as markdown, and breaks up mentions with a
+// zero-width space so names can't ping @everyone, roles or users
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"*", `\*`,
+	"_", `\_`,
+	"~", `\~`,
+	"`", "\\`",
+	"|", `\|`,
+	">", `\>`,
+	"#", `\#`,
+	"[", `\[`,
+	"]", `\]`,
+	"@", "@\u200b",
+)
+
+// sanitizeName makes a name that came from Discord, like a game name, safe to show in a message
+func sanitizeName(name string) string {
+	return markdownEscaper.Replace(name)
+}
+
+// sendChunked sends text to a channel, split on line boundaries into as many messages as
+// needed to stay under Discord's length limit. Lines that are too long on their own are
+// split wherever the limit falls.
+func sendChunked(s messageSender, channelID, text string) error {
+	for _, chunk := range splitMessage(text, maxMessageLength) {
+		if _, err := safeSend(s, channelID, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendChunkedWithoutPings is sendChunked for text that mentions members, which shows the mentions
+// without notifying anyone
+func sendChunkedWithoutPings(s messageSender, channelID, text string) error {
+	for _, chunk := range splitMessage(text, maxMessageLength) {
+		if _, err := safeSendComplex(s, channelID, &discordgo.MessageSend{
+			Content:         chunk,
+			AllowedMentions: &discordgo.MessageAllowedMentions{},
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// safeSend sends a message to a channel, retrying with backoff when Discord fails temporarily.
+// Failures are logged, so callers that have nothing better to do with the error can ignore it.
+func safeSend(s messageSender, channelID, content string) (*discordgo.Message, error) {
+	return sendWithRetry(channelID, func() (*discordgo.Message, error) {
+		return s.ChannelMessageSend(channelID, content)
+	})
+}
+
+// safeSendComplex is safeSend for messages with more than text, such as allowed mentions
+func safeSendComplex(s messageSender, channelID string, message *discordgo.MessageSend) (*discordgo.Message, error) {
+	return sendWithRetry(channelID, func() (*discordgo.Message, error) {
+		return s.ChannelMessageSendComplex(channelID, message)
+	})
+}
+
+// sendWithRetry calls send until it succeeds, fails permanently or sendAttempts is reached
+func sendWithRetry(channelID string, send func() (*discordgo.Message, error)) (*discordgo.Message, error) {
+	delay := sendRetryDelay
+	for attempt := 1; ; attempt++ {
+		message, err := send()
+		if err == nil {
+			return message, nil
+		}
+		if !isTransientSendError(err) {
+			var restErr *discordgo.RESTError
+			if errors.As(err, &restErr) && restErr.Message != nil &&
+				(restErr.Message.Code == discordgo.ErrCodeMissingPermissions || restErr.Message.Code == discordgo.ErrCodeMissingAccess) {
+				slog.Error("Missing permission to send messages, check the bot's role", "channel_id", channelID, "error", err)
+			} else {
+				slog.Error("Error sending message", "channel_id", channelID, "error", err)
+			}
+			return nil, fmt.Errorf("error sending message: %w", err)
+		}
+		if attempt == sendAttempts {
+			slog.Error("Error sending message, giving up", "channel_id", channelID, "attempts", attempt, "error", err)
+			return nil, fmt.Errorf("error sending message: %w", err)
+		}
+		slog.Warn("Error sending message, retrying", "channel_id", channelID, "delay", delay, "error", err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// isTransientSendError reports whether sending a message may succeed when tried again. Discord
+// server errors and rate limits are, as are network errors, rejected requests are not.
+func isTransientSendError(err error) bool {
+	var restErr *discordgo.RESTError
+	if !errors.As(err, &restErr) || restErr.Response == nil {
+		return true
+	}
+	status := restErr.Response.StatusCode
+	return status >= http.StatusInternalServerError || status == http.StatusTooManyRequests
+}
+
+// splitMessage splits text into chunks of at most limit characters, preferring line boundaries
+func splitMessage(text string, limit int) []string {
+	var chunks []string
+	var current strings.Builder
+	currentLen := 0
+	flush := func() {
+		if currentLen > 0 {
+			chunks = append(chunks, current.String())
+			current.Reset()
+			currentLen = 0
+		}
+	}
+
+	for _, line := range strings.SplitAfter(text, "\n") {
+		lineLen := utf8.RuneCountInString(line)
+		if currentLen+lineLen > limit {
+			flush()
+		}
+		for lineLen > limit {
+			runes := []rune(line)
+			chunks = append(chunks, string(runes[:limit]))
+			line = string(runes[limit:])
+			lineLen -= limit
+		}
+		current.WriteString(line)
+		currentLen += lineLen
+	}
+	flush()
+	return chunks
+}
+
+// parseBotAdmins reads a comma-separated list of user IDs
+func parseBotAdmins(value string) (map[string]bool, error) {
+	admins := make(map[string]bool)
+	for _, userID := range strings.Split(value, ",") {
+		userID = strings.TrimSpace(userID)
+		if userID == "" {
+			continue
+		}
+		if strings.Trim(userID, "0123456789") != "" {
+			return nil, fmt.Errorf("invalid user ID %q", userID)
+		}
+		admins[userID] = true
+	}
+	return admins, nil
+}
+
+// isAdmin reports whether a user is listed in BOT_ADMINS
+func isAdmin(userID string) bool {
+	return botAdmins[userID]
+}
+
+// canAdminister reports whether the message author may run admin commands: bot admins always can,
+// other members need the Manage Server permission in the guild
+func canAdminister(s messageSender, m *discordgo.MessageCreate) bool {
+	return isAdmin(m.Author.ID) || hasManageServer(s, m)
+}
+
+// hasManageServer reports whether the message author has the Manage Server permission in the guild
+func hasManageServer(s messageSender, m *discordgo.MessageCreate) bool {
+	if m.GuildID == "" {
+		return false
+	}
+	var perms int64
+	err := errors.New("no state cache")
+	if session, ok := s.(*discordgo.Session); ok && session.State != nil {
+		perms, err = session.State.UserChannelPermissions(m.Author.ID, m.ChannelID)
+	}
+	if err != nil {
+		// The state cache may not have the member, or there is none, ask the API instead
+		perms, err = s.UserChannelPermissions(m.Author.ID, m.ChannelID)
+		if err != nil {
+			slog.Warn("Could not check permissions", "user_id", m.Author.ID, "username", m.Author.Username, "error", err)
+			return false
+		}
+	}
+	return perms&discordgo.PermissionManageGuild != 0
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestParseCommand(t *testing.T) {
+	tests := []struct {
+		content  string
+		wantName string
+		wantArgs string
+	}{
+		{"!mygames", "mygames", ""},
+		{"!GameStats  Elden Ring ", "gamestats", "Elden Ring"},
+		{"!goal\nset 10h", "goal", "set 10h"},
+		{"!", "", ""},
+	}
+	for _, tt := range tests {
+		name, args := parseCommand(tt.content)
+		if name != tt.wantName || args != tt.wantArgs {
+			t.Errorf("parseCommand(%q) = %q, %q, want %q, %q", tt.content, name, args, tt.wantName, tt.wantArgs)
+		}
+	}
+}
+
+// TestDispatchUsage checks that commands missing their arguments or given ones they can't use
+// reply with their usage from the command table
+func TestDispatchUsage(t *testing.T) {
+	tests := []struct {
+		content   string
+		wantReply string
+	}{
+		{"!gamestats", "Usage: `!gamestats <game name>`"},
+		{"!resetgame", "Usage: `!resetgame <game name>`"},
+		{"!compare", "Usage: `!compare @member`"},
+		{"!playtime", "Usage: `!playtime @member`"},
+		{"!debug", "Usage: `!debug @member`"},
+		{"!settz", "Usage: `!settz <timezone>`"},
+		{"!budget lots", "Usage: `!budget [duration|off]`"},
+		{"!remind soon", "Usage: `!remind [duration|off]`"},
+		{"!goal set lots", "Usage: `!goal [set <duration>|off|<game> <duration>|<game> off]`"},
+		{"!goal lots", "Usage: `!goal [set <duration>|off|<game> <duration>|<game> off]`"},
+		{"!goal Elden Ring lots", "Usage: `!goal [set <duration>|off|<game> <duration>|<game> off]`"},
+		{"!format fancy", "Usage: `!format [hours|compact|verbose]`"},
+		{"!mygames chess", "Usage: `!mygames [game|streaming|listening]`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			s := newFakeSession()
+
+			dispatchCommand(s, testMessage("1", tt.content))
+			if reply := s.lastMessage(t, "channel"); reply != tt.wantReply {
+				t.Errorf("reply = %q, want %q", reply, tt.wantReply)
+			}
+		})
+	}
+}
+
+// TestDispatchOptionalArgs checks that commands whose arguments are optional show the current
+// setting instead of their usage when run without any
+func TestDispatchOptionalArgs(t *testing.T) {
+	tests := []struct {
+		content   string
+		wantReply string
+	}{
+		{"!budget", "you don't have a daily budget set"},
+		{"!remind", "you don't have a break reminder set"},
+		{"!goal", "you don't have a weekly goal set"},
+		{"!format", "your durations are shown as `compact`"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			s := newFakeSession()
+
+			dispatchCommand(s, testMessage("1", tt.content))
+			if reply := s.lastMessage(t, "channel"); !strings.Contains(reply, tt.wantReply) {
+				t.Errorf("reply = %q, want it to contain %q", reply, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestSplitMessage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{"short", "one\ntwo\n", []string{"one\ntwo\n"}},
+		{"exactly the limit", "abcd\nefg\n", []string{"abcd\nefg\n"}},
+		{"two chunks", "abcd\nefgh\nij\n", []string{"abcd\nefgh\n", "ij\n"}},
+		{"long line", "abcdefghijklmnopqrstuvwxyz", []string{"abcdefghij", "klmnopqrst", "uvwxyz"}},
+		{"multibyte", "ééééééééééé", []string{"éééééééééé", "é"}},
+		{"empty", "", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := splitMessage(tt.text, 10)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("splitMessage(%q) = %q, want %q", tt.text, got, tt.want)
+			}
+			for _, chunk := range got {
+				if n := len([]rune(chunk)); n > 10 {
+					t.Errorf("chunk %q has %d characters, over the limit", chunk, n)
+				}
+			}
+		})
+	}
+}
+
+func TestSendChunked(t *testing.T) {
+	tests := []struct {
+		name       string
+		lines      int
+		wantChunks int
+	}{
+		{"one message", 10, 1},
+		{"two messages", 150, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lines []string
+			for i := 0; i < tt.lines; i++ {
+				lines = append(lines, fmt.Sprintf("- **Game %03d**: 1h 2m", i))
+			}
+			text := strings.Join(lines, "\n")
+			s := newFakeSession()
+
+			if err := sendChunked(s, "channel", text); err != nil {
+				t.Fatal(err)
+			}
+			sent := s.messages("channel")
+			if len(sent) != tt.wantChunks {
+				t.Fatalf("sent %d messages, want %d", len(sent), tt.wantChunks)
+			}
+			for _, message := range sent {
+				if len(message) > maxMessageLength {
+					t.Errorf("message of %d characters, over Discord's limit", len(message))
+				}
+			}
+			if strings.Join(sent, "") != text {
+				t.Error("the messages don't add up to the text")
+			}
+		})
+	}
+}
+
+func TestOnCommandCooldown(t *testing.T) {
+	type call struct {
+		userID string
+		after  time.Duration // Since the first call
+		want   bool
+	}
+	tests := []struct {
+		name     string
+		cooldown time.Duration
+		calls    []call
+	}{
+		{"disabled", 0, []call{{"1", 0, false}, {"1", time.Second, false}}},
+		{"spamming", 5 * time.Second, []call{{"1", 0, false}, {"1", time.Second, true}, {"1", 4 * time.Second, true}}},
+		{"after the cooldown", 5 * time.Second, []call{{"1", 0, false}, {"1", 5 * time.Second, false}, {"1", 6 * time.Second, true}}},
+		{"other users", 5 * time.Second, []call{{"1", 0, false}, {"2", time.Second, false}, {"1", 2 * time.Second, true}}},
+		// Ignored commands don't extend the cooldown
+		{"ignored commands", 5 * time.Second, []call{{"1", 0, false}, {"1", 4 * time.Second, true}, {"1", 5 * time.Second, false}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setForTest(t, &commandCooldown, tt.cooldown)
+			setForTest(t, &lastCommands, make(map[string]time.Time))
+			start := time.Now()
+			for i, c := range tt.calls {
+				if got := onCommandCooldown(c.userID, start.Add(c.after)); got != c.want {
+					t.Errorf("call %d by user %s after %v: on cooldown = %v, want %v", i, c.userID, c.after, got, c.want)
+				}
+			}
+		})
+	}
+}
+
+func TestOnCommandCooldownPrunes(t *testing.T) {
+	setForTest(t, &commandCooldown, 5*time.Second)
+	setForTest(t, &lastCommands, make(map[string]time.Time))
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		onCommandCooldown(fmt.Sprint(i), start)
+	}
+	onCommandCooldown("late", start.Add(time.Minute))
+	if len(lastCommands) != 1 {
+		t.Errorf("%d users remembered, want only the one within the cooldown", len(lastCommands))
+	}
+}
+
+// TestDispatchCooldown checks that a command sent during the cooldown gets no reply
+func TestDispatchCooldown(t *testing.T) {
+	newTestStore(t)
+	setForTest(t, &commandCooldown, time.Hour)
+	setForTest(t, &lastCommands, make(map[string]time.Time))
+	s := newFakeSession()
+
+	dispatchCommand(s, testMessage("1", "!mygames"))
+	dispatchCommand(s, testMessage("1", "!mygames"))
+	dispatchCommand(s, testMessage("2", "!mygames"))
+	if sent := s.messages("channel"); len(sent) != 2 {
+		t.Errorf("%d replies, want one for each user", len(sent))
+	}
+}
+
+func TestParseBotAdmins(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"123", []string{"123"}, false},
+		{" 123 , 456,,", []string{"123", "456"}, false},
+		{"123,abc", nil, true},
+		{"<@123>", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			admins, err := parseBotAdmins(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseBotAdmins(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if len(admins) != len(tt.want) {
+				t.Errorf("parseBotAdmins(%q) = %v, want %v", tt.value, admins, tt.want)
+			}
+			for _, userID := range tt.want {
+				if !admins[userID] {
+					t.Errorf("parseBotAdmins(%q) is missing %s", tt.value, userID)
+				}
+			}
+		})
+	}
+}
+
+func TestCanAdminister(t *testing.T) {
+	tests := []struct {
+		name    string
+		userID  string
+		guildID string
+		perms   int64
+		want    bool
+	}{
+		{"bot admin", "1", "guild", 0, true},
+		{"bot admin in a DM", "1", "", 0, true},
+		{"manage server", "2", "guild", discordgo.PermissionManageGuild, true},
+		{"manage server in a DM", "2", "", discordgo.PermissionManageGuild, false},
+		{"other permissions", "2", "guild", discordgo.PermissionSendMessages, false},
+		{"member", "2", "guild", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setForTest(t, &botAdmins, map[string]bool{"1": true})
+			s := newFakeSession()
+			s.perms = tt.perms
+			m := testMessage(tt.userID, "!stats")
+			m.GuildID = tt.guildID
+
+			if got := canAdminister(s, m); got != tt.want {
+				t.Errorf("canAdminister = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestAdminCommandsGated checks that admin commands refuse members who aren't admins
+func TestAdminCommandsGated(t *testing.T) {
+	tests := []struct {
+		content   string
+		wantReply string
+	}{
+		{"!stats", "only admins can use this command"},
+		{"!playtime <@2>", "only admins can look up other members"},
+		{"!debug <@2>", "only admins can inspect tracking state"},
+		{"!clearall", "only admins can clear everyone's data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			setForTest(t, &botAdmins, map[string]bool{"1": true})
+			s := newFakeSession()
+
+			dispatchCommand(s, testMessage("3", tt.content, &discordgo.User{ID: "2", Username: "user2"}))
+			if reply := s.lastMessage(t, "channel"); !strings.Contains(reply, tt.wantReply) {
+				t.Errorf("reply to a member = %q, want it to contain %q", reply, tt.wantReply)
+			}
+
+			dispatchCommand(s, testMessage("1", tt.content, &discordgo.User{ID: "2", Username: "user2"}))
+			if replies := s.messages("channel"); strings.Contains(replies[len(replies)-1], "only admins") {
+				t.Errorf("reply to a bot admin = %q, want the command to run", replies[len(replies)-1])
+			}
+		})
+	}
+}
+
+// restError builds the error discordgo returns for a failed request with the given status and code
+func restError(status, code int) error {
+	return &discordgo.RESTError{
+		Response: &http.Response{StatusCode: status},
+		Message:  &discordgo.APIErrorMessage{Code: code},
+	}
+}
+
+func TestSafeSend(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     []error
+		wantErr      bool
+		wantAttempts int
+	}{
+		{"first try", nil, false, 1},
+		{"network error then success", []error{errors.New("connection reset")}, false, 2},
+		{"server error then success", []error{restError(http.StatusBadGateway, 0)}, false, 2},
+		{"rate limited then success", []error{restError(http.StatusTooManyRequests, 0)}, false, 2},
+		{"server down", []error{restError(500, 0), restError(500, 0), restError(500, 0)}, true, sendAttempts},
+		{"missing permissions", []error{restError(http.StatusForbidden, discordgo.ErrCodeMissingPermissions)}, true, 1},
+		{"bad request", []error{restError(http.StatusBadRequest, 0)}, true, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setForTest(t, &sendRetryDelay, time.Millisecond)
+			s := newFakeSession()
+			s.failures = tt.failures
+
+			message, err := safeSend(s, "channel", "hello")
+			if (err != nil) != tt.wantErr {
+				t.Errorf("error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && (message == nil || message.Content != "hello") {
+				t.Errorf("message = %+v, want the sent message", message)
+			}
+			if s.attempts != tt.wantAttempts {
+				t.Errorf("%d attempts, want %d", s.attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
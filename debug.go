@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// debugSessionsShown is how many of the most recent sessions !debug previews
+const debugSessionsShown = 5
+
+// handleDebug implements the !debug command: DM an admin the raw tracking state of a member, to
+// diagnose reports of play time not counting. It goes to a DM so the state isn't posted in the server.
+func handleDebug(s messageSender, m *discordgo.MessageCreate, args string) {
+	if !canAdminister(s, m) {
+		sendChunked(s, m.ChannelID, "Sorry, only admins can inspect tracking state.")
+		return
+	}
+	if len(m.Mentions) != 1 {
+		sendUsage(s, m.ChannelID, "debug")
+		return
+	}
+	target := m.Mentions[0]
+
+	dump, ok := debugState(m.GuildID, target.ID)
+	if !ok {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("I have no data for %s in this server.", target.Username))
+		return
+	}
+	dump = fmt.Sprintf("Tracking state of %s (`%s`) in server `%s`:\n", target.Username, target.ID, m.GuildID) + dump
+
+	channel, err := s.UserChannelCreate(m.Author.ID)
+	if err == nil {
+		err = sendChunked(s, channel.ID, dump)
+	}
+	if err != nil {
+		slog.Warn("Could not send debug DM", "user_id", m.Author.ID, "username", m.Author.Username, "error", err)
+		sendChunked(s, m.ChannelID, "Sorry, I couldn't DM you. Please check that you allow direct messages from server members.")
+		return
+	}
+	sendChunked(s, m.ChannelID, fmt.Sprintf("I've sent you the tracking state of %s in a DM.", target.Username))
+}
+
+// debugState formats a user's active games, with everything kept about them, and their most
+// recent sessions. Times are in UTC and names are quoted, so the raw values are shown as stored.
+func debugState(guildID, userID string) (string, bool) {
+	data.mu.Lock()
+	defer data.mu.Unlock()
+
+	userData, ok := data.Guilds[guildID][userID]
+	if !ok {
+		return "", false
+	}
+
+	var b strings.Builder
+	if data.optedOut[userID] {
+		b.WriteString("Opted out: yes\n")
+	}
+	fmt.Fprintf(&b, "**Active games** (%d):\n", len(userData.ActiveGames))
+	gameNames := make([]string, 0, len(userData.ActiveGames))
+	for gameName := range userData.ActiveGames {
+		gameNames = append(gameNames, gameName)
+	}
+	sort.Strings(gameNames)
+	for _, gameName := range gameNames {
+		fmt.Fprintf(&b, "- %s started `%s`", debugQuote(gameName), userData.ActiveGames[gameName].UTC().Format(time.RFC3339))
+		if activityType := userData.ActiveTypes[gameName]; activityType != "" {
+			fmt.Fprintf(&b, " type `%s`", activityType)
+		}
+		if appID := userData.ActiveAppIDs[gameName]; appID != "" {
+			fmt.Fprintf(&b, " app `%s`", appID)
+		}
+		if sessionID := userData.ActiveIDs[gameName]; sessionID != "" {
+			fmt.Fprintf(&b, " id `%s`", sessionID)
+		}
+		if userData.restoredGames[gameName] {
+			b.WriteString(" (restored, not confirmed by a presence yet)")
+		}
+		b.WriteString("\n")
+	}
+	for gameName, sessionID := range userData.FinalizedIDs {
+		fmt.Fprintf(&b, "- Finalized at shutdown: %s id `%s`\n", debugQuote(gameName), sessionID)
+	}
+	if !userData.emptySince.IsZero() {
+		fmt.Fprintf(&b, "Empty presence pending since `%s`\n", userData.emptySince.UTC().Format(time.RFC3339))
+	}
+
+	fmt.Fprintf(&b, "**Sessions** (%d), most recent last:\n", len(userData.Sessions))
+	shown := userData.Sessions
+	if len(shown) > debugSessionsShown {
+		shown = shown[len(shown)-debugSessionsShown:]
+	}
+	for _, session := range shown {
+		fmt.Fprintf(&b, "- %s `%s` to `%s` (%.0fs)", debugQuote(session.GameName), session.StartTime.UTC().Format(time.RFC3339), session.EndTime.UTC().Format(time.RFC3339), session.Duration)
+		if session.ActivityType != "" {
+			fmt.Fprintf(&b, " type `%s`", session.ActivityType)
+		}
+		if session.ID != "" {
+			fmt.Fprintf(&b, " id `%s`", session.ID)
+		}
+		b.WriteString("\n")
+	}
+	return b.String(), true
+}
+
+// debugQuote shows a name in a code span, so whitespace and markdown in it stay visible. Backticks
+// can't be escaped inside a code span and are replaced.
+func debugQuote(name string) string {
+	return "`" + strings.ReplaceAll(fmt.Sprintf("%q", name), "`", "'") + "`"
+}
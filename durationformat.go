@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// durationFormats are the ways durations can be shown, keyed by the name used with !format
+var durationFormats = map[string]func(time.Duration) string{
+	"compact": formatDuration,
+	"hours":   formatHours,
+	"verbose": formatVerbose,
+}
+
+// handleFormat implements the !format command: show or set how durations are shown to the user
+func handleFormat(s messageSender, m *discordgo.MessageCreate, args string) {
+	username := m.Author.Username
+	example := 26*time.Hour + 3*time.Minute
+
+	name := strings.ToLower(strings.TrimSpace(args))
+	if name == "" {
+		current := "compact"
+		if userData, ok := data.snapshotUser(m.GuildID, m.Author.ID); ok && userData.DurationFormat != "" {
+			current = userData.DurationFormat
+		}
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, your durations are shown as `%s`, like %s. Change it with `%sformat hours|compact|verbose`.", username, current, durationFormats[current](example), commandPrefix))
+		return
+	}
+	format, ok := durationFormats[name]
+	if !ok {
+		sendUsage(s, m.ChannelID, "format")
+		return
+	}
+
+	data.mu.Lock()
+	userData := data.getOrCreateUser(m.GuildID, m.Author.ID)
+	userData.DurationFormat = name
+	if name == "compact" {
+		userData.DurationFormat = ""
+	}
+	if err := data.saveLocked(); err != nil {
+		slog.Error("Error saving duration format", "username", username, "error", err)
+	}
+	data.mu.Unlock()
+
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I'll show your durations like %s from now on.", username, format(example)))
+}
+
+// userDurationFormat returns the formatter for the format the user chose with !format,
+// formatDuration if they didn't
+func userDurationFormat(userData *UserGameData) func(time.Duration) string {
+	if format, ok := durationFormats[userData.DurationFormat]; ok {
+		return format
+	}
+	return formatDuration
+}
+
+// formatHours formats a duration in hours rounded to one decimal, like "42.5h" or "3h"
+func formatHours(d time.Duration) string {
+	if d < 0 {
+		d = 0
+	}
+	return strconv.FormatFloat(math.Round(d.Hours()*10)/10, 'f', -1, 64) + "h"
+}
+
+// formatVerbose formats a duration in words, like "1 day, 2 hours, 3 minutes"
+func formatVerbose(d time.Duration) string {
+	if d < time.Second {
+		return "0 seconds"
+	}
+
+	units := []struct {
+		name  string
+		count int
+	}{
+		{"day", int(d.Hours() / 24)},
+		{"hour", int(d.Hours()) % 24},
+		{"minute", int(d.Minutes()) % 60},
+		{"second", int(d.Seconds()) % 60},
+	}
+	parts := []string{}
+	for _, unit := range units {
+		if unit.count == 0 {
+			continue
+		}
+		part := fmt.Sprintf("%d %s", unit.count, unit.name)
+		if unit.count != 1 {
+			part += "s"
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ", ")
+}
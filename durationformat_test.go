@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestDurationFormats(t *testing.T) {
+	tests := []struct {
+		duration time.Duration
+		compact  string
+		hours    string
+		verbose  string
+	}{
+		{0, "0s", "0h", "0 seconds"},
+		{-time.Minute, "0s", "0h", "0 seconds"},
+		{45 * time.Second, "45s", "0h", "45 seconds"},
+		{time.Hour, "1h", "1h", "1 hour"},
+		{90 * time.Minute, "1h 30m", "1.5h", "1 hour, 30 minutes"},
+		{26*time.Hour + 3*time.Minute, "1d 2h 3m", "26.1h", "1 day, 2 hours, 3 minutes"},
+		{42*time.Hour + 30*time.Minute, "1d 18h 30m", "42.5h", "1 day, 18 hours, 30 minutes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.duration.String(), func(t *testing.T) {
+			for name, want := range map[string]string{"compact": tt.compact, "hours": tt.hours, "verbose": tt.verbose} {
+				if got := durationFormats[name](tt.duration); got != want {
+					t.Errorf("%s format of %v = %q, want %q", name, tt.duration, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUserDurationFormat(t *testing.T) {
+	tests := []struct {
+		preference string
+		want       string
+	}{
+		{"", "1h 30m"},
+		{"compact", "1h 30m"},
+		{"hours", "1.5h"},
+		{"verbose", "1 hour, 30 minutes"},
+		{"removed", "1h 30m"}, // A format a later version dropped falls back to the default
+	}
+	for _, tt := range tests {
+		t.Run(tt.preference, func(t *testing.T) {
+			userData := newUserGameData()
+			userData.DurationFormat = tt.preference
+			if got := userDurationFormat(userData)(90 * time.Minute); got != tt.want {
+				t.Errorf("duration with format %q = %q, want %q", tt.preference, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestFormatCommand sets each format with !format and checks that !mygames follows it
+func TestFormatCommand(t *testing.T) {
+	tests := []struct {
+		format     string
+		wantStored string
+		wantTotal  string
+	}{
+		{"hours", "hours", "**Minecraft**: 1.5h"},
+		{"VERBOSE", "verbose", "**Minecraft**: 1 hour, 30 minutes"},
+		{"compact", "", "**Minecraft**: 1h 30m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			addSession(store, "1", "Minecraft", time.Now().Add(-3*time.Hour), 90*time.Minute)
+			s := newFakeSession()
+
+			dispatchCommand(s, testMessage("1", "!format "+tt.format))
+			userData, _ := store.snapshotUser("guild", "1")
+			if userData.DurationFormat != tt.wantStored {
+				t.Errorf("stored format = %q, want %q", userData.DurationFormat, tt.wantStored)
+			}
+
+			dispatchCommand(s, testMessage("1", "!mygames"))
+			if reply := s.lastMessage(t, "channel"); !strings.Contains(reply, tt.wantTotal) {
+				t.Errorf("!mygames reply %q doesn't contain %q", reply, tt.wantTotal)
+			}
+		})
+	}
+}
+
+// TestLookupsFollowFormat checks that !playtime and !compare show durations the way the member
+// asking prefers them
+func TestLookupsFollowFormat(t *testing.T) {
+	tests := []struct {
+		content string
+		want    []string
+	}{
+		{"!playtime", []string{"has played 1.5h in total", "**Minecraft**: 1.5h"}},
+		{"!compare", []string{"1.5h", "user2 has played more overall: 1.5h vs 1h"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			addSession(store, "1", "Minecraft", time.Now().Add(-3*time.Hour), time.Hour)
+			addSession(store, "2", "Minecraft", time.Now().Add(-3*time.Hour), 90*time.Minute)
+			store.mu.Lock()
+			store.getOrCreateUser("guild", "1").DurationFormat = "hours"
+			store.mu.Unlock()
+			s := newFakeSession()
+			s.perms = discordgo.PermissionManageGuild
+
+			dispatchCommand(s, testMessage("1", tt.content+" <@2>", &discordgo.User{ID: "2", Username: "user2"}))
+			reply := s.lastMessage(t, "channel")
+			for _, want := range tt.want {
+				if !strings.Contains(reply, want) {
+					t.Errorf("%s reply %q doesn't contain %q", tt.content, reply, want)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// handleExport implements the !export command: DM the user a CSV (default) or JSON file of their sessions
+func handleExport(s messageSender, m *discordgo.MessageCreate, args string) {
+	userID := m.Author.ID
+	username := m.Author.Username
+
+	format := strings.ToLower(strings.TrimSpace(args))
+	if format == "" {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		sendUsage(s, m.ChannelID, "export")
+		return
+	}
+
+	// Work on a snapshot so the file can be built without holding the lock
+	var sessions []GameSession
+	if userData, ok := data.snapshotUser(m.GuildID, userID); ok {
+		sessions = withArchivedSessions(userData, m.GuildID, userID).Sessions
+	}
+
+	if len(sessions) == 0 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you don't have any sessions to export yet!", username))
+		return
+	}
+
+	var fileBytes []byte
+	var err error
+	if format == "json" {
+		fileBytes, err = json.MarshalIndent(sessions, "", "  ")
+	} else {
+		fileBytes, err = sessionsCSV(sessions)
+	}
+	if err != nil {
+		slog.Error("Error exporting sessions", "username", username, "error", err)
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Sorry %s, something went wrong while exporting your data.", username))
+		return
+	}
+
+	channel, err := s.UserChannelCreate(userID)
+	if err == nil {
+		_, err = s.ChannelFileSend(channel.ID, "game_sessions."+format, bytes.NewReader(fileBytes))
+	}
+	if err != nil {
+		slog.Warn("Could not DM export", "username", username, "error", err)
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Sorry %s, I couldn't DM you your data. Please check that you allow direct messages from server members.", username))
+		return
+	}
+
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I've sent you your %d session(s) in a DM!", username, len(sessions)))
+}
+
+// sessionsCSV encodes sessions as CSV with a header row
+func sessionsCSV(sessions []GameSession) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	w.Write([]string{"game_name", "start_time", "end_time", "duration_seconds"})
+	for _, session := range sessions {
+		w.Write([]string{
+			session.GameName,
+			session.StartTime.Format(time.RFC3339),
+			session.EndTime.Format(time.RFC3339),
+			strconv.FormatFloat(session.Duration, 'f', 0, 64),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return nil, fmt.Errorf("error writing CSV: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const maxGameCandidates = 10 // Candidates listed when a game name query is ambiguous
+
+// findGame returns the names of the user's games matching query, sorted. An exact match, ignoring
+// case, wins. Otherwise every game whose name contains the query is returned, ignoring case,
+// spaces and punctuation, so "counterstrike" finds "Counter-Strike 2".
+func findGame(userData *UserGameData, query string) []string {
+	names := make(map[string]bool)
+	for _, session := range userData.Sessions {
+		names[session.GameName] = true
+	}
+	for gameName := range userData.ActiveGames {
+		names[gameName] = true
+	}
+	for gameName := range userData.TrimmedTotals {
+		names[gameName] = true // All of its sessions may have been trimmed
+	}
+
+	var exact, partial []string
+	normalizedQuery := normalizeGameQuery(query)
+	for name := range names {
+		if strings.EqualFold(name, query) {
+			exact = append(exact, name)
+		} else if normalizedQuery != "" && strings.Contains(normalizeGameQuery(name), normalizedQuery) {
+			partial = append(partial, name)
+		}
+	}
+
+	matches := partial
+	if len(exact) > 0 {
+		matches = exact
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// normalizeGameQuery lowercases a game name and drops everything but letters and digits
+func normalizeGameQuery(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// resolveGame finds the one game of the user that query refers to. If there is none, or several,
+// it tells the user and returns false. Names that only differ in case count as one game.
+func resolveGame(s messageSender, m *discordgo.MessageCreate, userData *UserGameData, query string) (string, bool) {
+	matches := findGame(userData, query)
+	if len(matches) == 0 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, no sessions found for **%s**.", m.Author.Username, sanitizeName(query)))
+		return "", false
+	}
+
+	for _, name := range matches[1:] {
+		if !strings.EqualFold(name, matches[0]) {
+			response := fmt.Sprintf("Hey %s, **%s** matches more than one game. Which one did you mean?\n", m.Author.Username, sanitizeName(query))
+			for i, candidate := range matches {
+				if i == maxGameCandidates {
+					response += fmt.Sprintf("...and %d more\n", len(matches)-maxGameCandidates)
+					break
+				}
+				response += fmt.Sprintf("- %s\n", sanitizeName(candidate))
+			}
+			sendChunked(s, m.ChannelID, response)
+			return "", false
+		}
+	}
+	return matches[0], true
+}
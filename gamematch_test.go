@@ -0,0 +1,98 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFindGame(t *testing.T) {
+	userData := newUserGameData()
+	start := time.Now().Add(-10 * time.Hour)
+	for _, name := range []string{"Counter-Strike 2", "Minecraft", "Minecraft Dungeons", "Tetris", "tetris"} {
+		userData.Sessions = append(userData.Sessions, newGameSession(name, start, start.Add(time.Hour)))
+	}
+	userData.ActiveGames["Elden Ring"] = start
+
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{"counterstrike", []string{"Counter-Strike 2"}},
+		{"COUNTER strike", []string{"Counter-Strike 2"}},
+		{"minecraft", []string{"Minecraft"}}, // Exact beats partial
+		{"mine", []string{"Minecraft", "Minecraft Dungeons"}},
+		{"dungeons", []string{"Minecraft Dungeons"}},
+		{"elden", []string{"Elden Ring"}},
+		{"TETRIS", []string{"Tetris", "tetris"}},
+		{"zelda", nil},
+		{"!!!", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			got := findGame(userData, tt.query)
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("findGame(%q) = %q, want %q", tt.query, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolveGame(t *testing.T) {
+	tests := []struct {
+		query     string
+		wantGame  string // Empty if the query doesn't resolve
+		wantReply string
+	}{
+		{"counterstrike", "Counter-Strike 2", ""},
+		{"tetris", "Tetris", ""}, // Names that only differ in case are one game
+		{"mine", "", "matches more than one game"},
+		{"zelda", "", "no sessions found for **zelda**"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			userData := newUserGameData()
+			start := time.Now().Add(-10 * time.Hour)
+			for _, name := range []string{"Counter-Strike 2", "Minecraft", "Minecraft Dungeons", "Tetris", "tetris"} {
+				userData.Sessions = append(userData.Sessions, newGameSession(name, start, start.Add(time.Hour)))
+			}
+			s := newFakeSession()
+
+			game, ok := resolveGame(s, testMessage("1", "!gamestats "+tt.query), userData, tt.query)
+			if ok != (tt.wantGame != "") || game != tt.wantGame {
+				t.Errorf("resolveGame(%q) = %q, %v, want %q", tt.query, game, ok, tt.wantGame)
+			}
+			sent := s.messages("channel")
+			if tt.wantReply == "" {
+				if len(sent) > 0 {
+					t.Errorf("replies = %q, want none", sent)
+				}
+				return
+			}
+			if len(sent) != 1 || !strings.Contains(sent[0], tt.wantReply) {
+				t.Errorf("replies = %q, want one containing %q", sent, tt.wantReply)
+			}
+		})
+	}
+}
+
+func TestResolveGameListsCandidates(t *testing.T) {
+	userData := newUserGameData()
+	start := time.Now().Add(-100 * time.Hour)
+	for i := 0; i < maxGameCandidates+3; i++ {
+		name := "Game " + strings.Repeat("I", i+1)
+		userData.Sessions = append(userData.Sessions, newGameSession(name, start, start.Add(time.Hour)))
+	}
+	s := newFakeSession()
+
+	if _, ok := resolveGame(s, testMessage("1", "!gamestats game"), userData, "game"); ok {
+		t.Fatal("an ambiguous query resolved")
+	}
+	reply := s.lastMessage(t, "channel")
+	if got := strings.Count(reply, "\n- "); got != maxGameCandidates {
+		t.Errorf("%d candidates listed, want %d", got, maxGameCandidates)
+	}
+	if !strings.Contains(reply, "...and 3 more") {
+		t.Errorf("reply %q doesn't say how many more games match", reply)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+const gameNameRulesFilePath = "game_names.json"
+
+// gameNameRule renames activities reported under another name of a game to its canonical name.
+// Either Match, a name compared ignoring case, or Pattern, a regular expression matched ignoring
+// case, must be set.
+type gameNameRule struct {
+	Match   string `json:"match,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+	Name    string `json:"name"`
+
+	re *regexp.Regexp
+}
+
+// gameNameRules are applied in order to activity names before they are tracked, the first
+// matching rule wins
+var gameNameRules []gameNameRule
+
+// loadGameNameRules reads the optional game_names.json file, a JSON array of rules such as
+//
+//	[{"match": "cs2", "name": "Counter-Strike 2"},
+//	 {"pattern": "^counter-strike", "name": "Counter-Strike 2"}]
+func loadGameNameRules() error {
+	dataBytes, err := os.ReadFile(gameNameRulesFilePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil // The file is optional
+		}
+		return fmt.Errorf("error reading %s: %w", gameNameRulesFilePath, err)
+	}
+
+	var rules []gameNameRule
+	if err := json.Unmarshal(dataBytes, &rules); err != nil {
+		return fmt.Errorf("error unmarshaling %s: %w", gameNameRulesFilePath, err)
+	}
+	for i := range rules {
+		rule := &rules[i]
+		rule.Name = strings.TrimSpace(rule.Name)
+		if rule.Name == "" {
+			return fmt.Errorf("rule %d in %s has no name", i+1, gameNameRulesFilePath)
+		}
+		if (rule.Match == "") == (rule.Pattern == "") {
+			return fmt.Errorf("rule %d in %s needs either match or pattern", i+1, gameNameRulesFilePath)
+		}
+		if rule.Pattern != "" {
+			if rule.re, err = regexp.Compile("(?i)" + rule.Pattern); err != nil {
+				return fmt.Errorf("invalid pattern in rule %d in %s: %w", i+1, gameNameRulesFilePath, err)
+			}
+		}
+	}
+	gameNameRules = rules
+	return nil
+}
+
+// normalizeGameName returns the canonical name of a game as reported by Discord, following
+// the first matching rule of game_names.json. Names no rule matches are returned trimmed.
+func normalizeGameName(raw string) string {
+	name := strings.TrimSpace(raw)
+	for _, rule := range gameNameRules {
+		if rule.re != nil && rule.re.MatchString(name) || rule.re == nil && strings.EqualFold(strings.TrimSpace(rule.Match), name) {
+			return rule.Name
+		}
+	}
+	return name
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNormalizeGameName(t *testing.T) {
+	t.Chdir(t.TempDir())
+	rules := `[
+		{"match": "cs2", "name": "Counter-Strike 2"},
+		{"pattern": "^counter-strike", "name": "Counter-Strike 2"},
+		{"match": " League ", "name": "League of Legends"}
+	]`
+	if err := os.WriteFile(gameNameRulesFilePath, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	setForTest(t, &gameNameRules, nil)
+	if err := loadGameNameRules(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		raw  string
+		want string
+	}{
+		{"Counter-Strike 2", "Counter-Strike 2"},
+		{"Counter-Strike: Global Offensive", "Counter-Strike 2"},
+		{"cs2", "Counter-Strike 2"},
+		{"CS2 ", "Counter-Strike 2"},
+		{"league", "League of Legends"},
+		{"League of Legends", "League of Legends"},
+		{"  Minecraft ", "Minecraft"},
+		{"Play cs2", "Play cs2"}, // Matches compare the whole name
+	}
+	for _, tt := range tests {
+		if got := normalizeGameName(tt.raw); got != tt.want {
+			t.Errorf("normalizeGameName(%q) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+}
+
+func TestLoadGameNameRulesInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+	}{
+		{"no name", `[{"match": "cs2"}]`},
+		{"match and pattern", `[{"match": "cs2", "pattern": "cs", "name": "Counter-Strike 2"}]`},
+		{"neither", `[{"name": "Counter-Strike 2"}]`},
+		{"bad pattern", `[{"pattern": "(", "name": "Counter-Strike 2"}]`},
+		{"not JSON", `{`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Chdir(t.TempDir())
+			if err := os.WriteFile(gameNameRulesFilePath, []byte(tt.rules), 0644); err != nil {
+				t.Fatal(err)
+			}
+			setForTest(t, &gameNameRules, nil)
+			if err := loadGameNameRules(); err == nil {
+				t.Error("loaded invalid rules without an error")
+			}
+			if gameNameRules != nil {
+				t.Errorf("rules = %+v, want none applied", gameNameRules)
+			}
+		})
+	}
+}
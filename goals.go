@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// handleGoal implements the !goal command: show progress towards the weekly goal, or set or clear it
+func handleGoal(s messageSender, m *discordgo.MessageCreate, args string) {
+	username := m.Author.Username
+
+	fields := strings.Fields(strings.ToLower(args))
+	if len(fields) == 0 {
+		showGoalProgress(s, m)
+		return
+	}
+
+	var goal time.Duration
+	switch {
+	case fields[0] == "off" && len(fields) == 1:
+	case fields[0] == "set" && len(fields) > 1:
+		var err error
+		goal, err = parsePlayDuration(strings.Join(fields[1:], ""))
+		if err != nil || goal <= 0 {
+			sendUsage(s, m.ChannelID, "goal")
+			return
+		}
+	case len(fields) > 1:
+		handleGameGoal(s, m, strings.Fields(args))
+		return
+	default:
+		sendUsage(s, m.ChannelID, "goal")
+		return
+	}
+
+	data.mu.Lock()
+	userData := data.getOrCreateUser(m.GuildID, m.Author.ID)
+	userData.WeeklyGoal = goal.Seconds()
+	if err := data.saveLocked(); err != nil {
+		slog.Error("Error saving goal", "username", username, "error", err)
+	}
+	data.mu.Unlock()
+
+	if goal == 0 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, your weekly goal has been removed.", username))
+		return
+	}
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, your weekly goal is now %s. Check your progress with `%sgoal`.", username, formatDuration(goal), commandPrefix))
+}
+
+// showGoalProgress replies with the user's play time this week against their weekly goal
+func showGoalProgress(s messageSender, m *discordgo.MessageCreate) {
+	username := m.Author.Username
+
+	userData, ok := data.snapshotUser(m.GuildID, m.Author.ID)
+	if !ok || (userData.WeeklyGoal <= 0 && len(userData.GameGoals) == 0) {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you don't have a weekly goal set. Use `%sgoal set 10h` to set one, or `%[2]sgoal <game> 40h` for a single game.", username, commandPrefix))
+		return
+	}
+
+	now := time.Now()
+	format := userDurationFormat(userData)
+	var response string
+	if userData.WeeklyGoal > 0 {
+		goal := time.Duration(userData.WeeklyGoal) * time.Second
+		played := playTimeBetween(userData, startOfWeek(now), now)
+		percent := int(100 * float64(played) / float64(goal))
+
+		response = fmt.Sprintf("Hey %s, this week you've played %s / %s (%d%%).", username, format(played), format(goal), percent)
+		if played >= goal {
+			response += " Goal reached!"
+		}
+	} else {
+		response = fmt.Sprintf("Hey %s, you don't have a weekly goal set.", username)
+	}
+
+	if len(userData.GameGoals) > 0 {
+		playTimes := gamePlayTimes(userData, now)
+		response += "\nYour game goals:\n"
+		for _, gameName := range sortedKeys(userData.GameGoals) {
+			goal := time.Duration(userData.GameGoals[gameName].Target) * time.Second
+			played := gamePlayTime(playTimes, gameName)
+			line := fmt.Sprintf("- **%s**: %s / %s (%d%%)", sanitizeName(gameName), format(played), format(goal), int(100*float64(played)/float64(goal)))
+			if played >= goal {
+				line += " Goal reached!"
+			}
+			response += line + "\n"
+		}
+	}
+	sendChunked(s, m.ChannelID, response)
+}
+
+// handleGameGoal handles `!goal <game> <duration>` and `!goal <game> off`, setting or removing the
+// play-time goal for one game. fields are the command's arguments with their original case.
+func handleGameGoal(s messageSender, m *discordgo.MessageCreate, fields []string) {
+	username := m.Author.Username
+
+	// The duration may contain spaces too, so take the longest suffix that parses as one
+	var goal time.Duration
+	nameFields := 0
+	if strings.EqualFold(fields[len(fields)-1], "off") {
+		nameFields = len(fields) - 1
+	} else {
+		for i := 1; i < len(fields); i++ {
+			if d, err := parsePlayDuration(strings.Join(fields[i:], "")); err == nil && d > 0 {
+				goal, nameFields = d, i
+				break
+			}
+		}
+		if nameFields == 0 {
+			sendUsage(s, m.ChannelID, "goal")
+			return
+		}
+	}
+	gameName := strings.Join(fields[:nameFields], " ")
+
+	// Use the name of a game the user already played if it matches, so the goal follows its sessions
+	userData, ok := data.snapshotUser(m.GuildID, m.Author.ID)
+	if ok && len(findGame(userData, gameName)) > 0 {
+		if gameName, ok = resolveGame(s, m, userData, gameName); !ok {
+			return
+		}
+	}
+
+	data.mu.Lock()
+	liveData := data.getOrCreateUser(m.GuildID, m.Author.ID)
+	for existing := range liveData.GameGoals {
+		if strings.EqualFold(existing, gameName) {
+			delete(liveData.GameGoals, existing)
+		}
+	}
+	var played time.Duration
+	if goal > 0 {
+		if liveData.GameGoals == nil {
+			liveData.GameGoals = make(map[string]*GameGoal)
+		}
+		played = gamePlayTime(gamePlayTimes(liveData, time.Now()), gameName)
+		// A goal that is already met doesn't get a notification
+		liveData.GameGoals[gameName] = &GameGoal{Target: goal.Seconds(), Reached: played >= goal}
+	}
+	if err := data.saveLocked(); err != nil {
+		slog.Error("Error saving game goal", "username", username, "error", err)
+	}
+	data.mu.Unlock()
+
+	switch {
+	case goal == 0:
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, your goal for **%s** has been removed.", username, sanitizeName(gameName)))
+	case played >= goal:
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you've already played **%s** for %s, so that goal of %s is reached!", username, sanitizeName(gameName), formatDuration(played), formatDuration(goal)))
+	default:
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, your goal for **%s** is now %s. I'll DM you when you reach it.", username, sanitizeName(gameName), formatDuration(goal)))
+	}
+}
+
+// gamePlayTime returns the play time of a game from gamePlayTimes, matching its name ignoring case
+func gamePlayTime(playTimes map[string]time.Duration, gameName string) time.Duration {
+	var total time.Duration
+	for name, duration := range playTimes {
+		if strings.EqualFold(name, gameName) {
+			total += duration
+		}
+	}
+	return total
+}
+
+// checkGameGoalLocked reports whether the session that just ended reached the user's goal for its
+// game, and marks the goal as reached so it's only reported once. The caller must hold data.mu.
+func checkGameGoalLocked(userData *UserGameData, session GameSession) (time.Duration, time.Duration, bool) {
+	for gameName, goal := range userData.GameGoals {
+		if goal.Reached || !strings.EqualFold(gameName, session.GameName) {
+			continue
+		}
+		target := time.Duration(goal.Target) * time.Second
+		total := gamePlayTime(gamePlayTimes(userData, session.EndTime), gameName)
+		if total < target {
+			return 0, 0, false
+		}
+		goal.Reached = true
+		return target, total, true
+	}
+	return 0, 0, false
+}
+
+// startOfWeek returns midnight of the Monday of the week containing t, in t's location
+func startOfWeek(t time.Time) time.Time {
+	daysSinceMonday := (int(t.Weekday()) + 6) % 7
+	return startOfDay(t).AddDate(0, 0, -daysSinceMonday)
+}
+
+// parsePlayDuration parses durations as users type them, such as "10h", "90m", "1h30m" or
+// "1h 30m". On top of what time.ParseDuration accepts it allows spaces and a "d" unit for days.
+func parsePlayDuration(value string) (time.Duration, error) {
+	value = strings.ReplaceAll(strings.ToLower(strings.TrimSpace(value)), " ", "")
+	if value == "" {
+		return 0, fmt.Errorf("empty duration")
+	}
+
+	var days time.Duration
+	if i := strings.Index(value, "d"); i >= 0 {
+		count, err := strconv.Atoi(value[:i])
+		if err != nil || count < 0 {
+			return 0, fmt.Errorf("invalid number of days in %q", value)
+		}
+		days = time.Duration(count) * 24 * time.Hour
+		value = value[i+1:]
+		if value == "" {
+			return days, nil
+		}
+	}
+
+	rest, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	return days + rest, nil
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// sentMessage is a message the fake session was asked to send
+type sentMessage struct {
+	channelID string
+	content   string
+	embeds    []*discordgo.MessageEmbed
+	file      string // Name of an attached file, if any
+	// Mentions that notify, nil if the message doesn't restrict them
+	allowedMentions *discordgo.MessageAllowedMentions
+}
+
+// fakeSession stands in for *discordgo.Session, recording what the bot sends instead of calling Discord
+type fakeSession struct {
+	mu        sync.Mutex
+	sent      []sentMessage
+	edits     map[string]string // Key: message ID, Value: new content
+	reactions []string          // Emojis added, in order
+	perms     int64             // Permissions every user has in every channel
+	sendErr   error             // Returned by every send if set
+	failures  []error           // Returned by the next sends, one each, before sendErr applies
+	attempts  int               // Sends tried, including failed ones
+	nextID    int
+}
+
+var _ messageSender = (*fakeSession)(nil)
+
+func newFakeSession() *fakeSession {
+	return &fakeSession{edits: make(map[string]string)}
+}
+
+func (f *fakeSession) record(message sentMessage) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.attempts++
+	if len(f.failures) > 0 {
+		err := f.failures[0]
+		f.failures = f.failures[1:]
+		return nil, err
+	}
+	if f.sendErr != nil {
+		return nil, f.sendErr
+	}
+	f.sent = append(f.sent, message)
+	f.nextID++
+	return &discordgo.Message{ID: strings.Repeat("1", f.nextID), ChannelID: message.channelID, Content: message.content}, nil
+}
+
+func (f *fakeSession) ChannelMessageSend(channelID, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return f.record(sentMessage{channelID: channelID, content: content})
+}
+
+func (f *fakeSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	return f.record(sentMessage{channelID: channelID, content: data.Content, embeds: data.Embeds, allowedMentions: data.AllowedMentions})
+}
+
+func (f *fakeSession) ChannelMessageEdit(channelID, messageID, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.edits[messageID] = content
+	return &discordgo.Message{ID: messageID, ChannelID: channelID, Content: content}, nil
+}
+
+func (f *fakeSession) ChannelFileSend(channelID, name string, r io.Reader, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return f.record(sentMessage{channelID: channelID, content: string(content), file: name})
+}
+
+func (f *fakeSession) MessageReactionAdd(channelID, messageID, emojiID string, options ...discordgo.RequestOption) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reactions = append(f.reactions, emojiID)
+	return nil
+}
+
+func (f *fakeSession) MessageReactionRemove(channelID, messageID, emojiID, userID string, options ...discordgo.RequestOption) error {
+	return nil
+}
+
+func (f *fakeSession) MessageReactionsRemoveAll(channelID, messageID string, options ...discordgo.RequestOption) error {
+	return nil
+}
+
+func (f *fakeSession) UserChannelCreate(recipientID string, options ...discordgo.RequestOption) (*discordgo.Channel, error) {
+	return &discordgo.Channel{ID: "dm-" + recipientID, Type: discordgo.ChannelTypeDM}, nil
+}
+
+func (f *fakeSession) UserChannelPermissions(userID, channelID string, fetchOptions ...discordgo.RequestOption) (int64, error) {
+	return f.perms, nil
+}
+
+// messages returns the content of everything sent to a channel so far
+func (f *fakeSession) messages(channelID string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var contents []string
+	for _, message := range f.sent {
+		if message.channelID == channelID {
+			contents = append(contents, message.content)
+		}
+	}
+	return contents
+}
+
+// lastMessage returns the content of the last message sent to a channel, failing the test if there is none
+func (f *fakeSession) lastMessage(t *testing.T, channelID string) string {
+	t.Helper()
+	contents := f.messages(channelID)
+	if len(contents) == 0 {
+		t.Fatalf("no message was sent to channel %s", channelID)
+	}
+	return contents[len(contents)-1]
+}
+
+// waitForMessages waits up to a second for n messages in a channel, for DMs sent in the
+// background, and returns what was sent by then
+func (f *fakeSession) waitForMessages(channelID string, n int) []string {
+	deadline := time.Now().Add(time.Second)
+	for {
+		contents := f.messages(channelID)
+		if len(contents) >= n || time.Now().After(deadline) {
+			return contents
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// newTestStore replaces the global data store with an empty in-memory one for the test
+func newTestStore(t *testing.T) *DataStore {
+	t.Helper()
+	store := newDataStore(newMemoryBackend())
+	setForTest(t, &data, store)
+	return store
+}
+
+// setForTest sets a package variable, typically a setting, for the duration of the test
+func setForTest[T any](t *testing.T, target *T, value T) {
+	t.Helper()
+	previous := *target
+	*target = value
+	t.Cleanup(func() { *target = previous })
+}
+
+// testMessage builds a command message from a user in the test guild and channel
+func testMessage(userID, content string, mentions ...*discordgo.User) *discordgo.MessageCreate {
+	return &discordgo.MessageCreate{Message: &discordgo.Message{
+		ID:        "message-" + userID,
+		ChannelID: "channel",
+		GuildID:   "guild",
+		Content:   content,
+		Author:    &discordgo.User{ID: userID, Username: "user" + userID},
+		Mentions:  mentions,
+	}}
+}
+
+// testPresence builds a presence update of a user in the test guild playing the given games,
+// each started at startedAt
+func testPresence(userID string, startedAt time.Time, games ...string) *discordgo.PresenceUpdate {
+	p := &discordgo.PresenceUpdate{GuildID: "guild", Presence: discordgo.Presence{
+		User:   &discordgo.User{ID: userID, Username: "user" + userID},
+		Status: discordgo.StatusOnline,
+	}}
+	for _, game := range games {
+		p.Activities = append(p.Activities, &discordgo.Activity{
+			Name:       game,
+			Type:       discordgo.ActivityTypeGame,
+			Timestamps: discordgo.TimeStamps{StartTimestamp: startedAt.UnixMilli()},
+		})
+	}
+	return p
+}
+
+// addSession records a completed session of a user in the test guild
+func addSession(store *DataStore, userID, game string, start time.Time, duration time.Duration) GameSession {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	session := newGameSession(game, start, start.Add(duration))
+	session.ID = newSessionID()
+	session.GuildID = "guild"
+	userData := store.getOrCreateUser("guild", userID)
+	userData.Sessions = append(userData.Sessions, session)
+	store.invalidateTotalsLocked("guild")
+	return session
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const ignoredGamesFilePath = "ignored_games.json"
+
+// ignoredGames holds the lowercased names of activities that are never tracked,
+// e.g. launchers or apps that Discord reports as games
+var ignoredGames = make(map[string]bool)
+
+// loadIgnoredGames builds the ignore list from the comma-separated IGNORED_GAMES
+// environment variable and the optional ignored_games.json file (a JSON array of names)
+func loadIgnoredGames() error {
+	for _, name := range strings.Split(os.Getenv("IGNORED_GAMES"), ",") {
+		addIgnoredGame(name)
+	}
+
+	dataBytes, err := os.ReadFile(ignoredGamesFilePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil // The file is optional
+		}
+		return fmt.Errorf("error reading %s: %w", ignoredGamesFilePath, err)
+	}
+
+	var names []string
+	if err := json.Unmarshal(dataBytes, &names); err != nil {
+		return fmt.Errorf("error unmarshaling %s: %w", ignoredGamesFilePath, err)
+	}
+	for _, name := range names {
+		addIgnoredGame(name)
+	}
+	return nil
+}
+
+func addIgnoredGame(name string) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name != "" {
+		ignoredGames[name] = true
+	}
+}
+
+// isIgnored reports whether a game is on the ignore list, ignoring case
+func isIgnored(gameName string) bool {
+	return ignoredGames[strings.ToLower(strings.TrimSpace(gameName))]
+}
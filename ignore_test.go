@@ -0,0 +1,28 @@
+package main
+
+import "testing"
+
+func TestIsIgnored(t *testing.T) {
+	setForTest(t, &ignoredGames, make(map[string]bool))
+	for _, name := range []string{"Visual Studio Code", "  SPOTIFY ", ""} {
+		addIgnoredGame(name)
+	}
+
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"Visual Studio Code", true},
+		{"visual studio code", true},
+		{"Spotify", true},
+		{" spotify\t", true},
+		{"Visual Studio", false},
+		{"Minecraft", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isIgnored(tt.name); got != tt.want {
+			t.Errorf("isIgnored(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
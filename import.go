@@ -0,0 +1,176 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	maxImportSize        = 5 << 20          // Largest attachment !import accepts, in bytes
+	importFetchTimeout   = 30 * time.Second // How long downloading the attachment may take
+	maxImportErrorsShown = 5                // Rejected sessions explained in the reply
+)
+
+// importClient downloads !import attachments
+var importClient = &http.Client{Timeout: importFetchTimeout}
+
+// handleImport implements the !import command: add sessions from an attached JSON file, in the format
+// written by !export json, to the user's history
+func handleImport(s messageSender, m *discordgo.MessageCreate, args string) {
+	username := m.Author.Username
+
+	if len(m.Attachments) != 1 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Usage: `%simport` with a JSON file of sessions attached, like the one `%[1]sexport json` sends you", commandPrefix))
+		return
+	}
+	attachment := m.Attachments[0]
+	if attachment.Size > maxImportSize {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Sorry %s, that file is too big. I can import files up to %d MB.", username, maxImportSize>>20))
+		return
+	}
+
+	sessions, err := fetchImportSessions(attachment.URL)
+	if err != nil {
+		slog.Warn("Could not import sessions", "username", username, "error", err)
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Sorry %s, I couldn't read that file. It needs to be a JSON array of sessions, like the one `%sexport json` sends you.", username, commandPrefix))
+		return
+	}
+
+	now := time.Now()
+	var valid []GameSession
+	var rejections []string
+	for i, session := range sessions {
+		session, err := validateImportedSession(session, now)
+		if err != nil {
+			rejections = append(rejections, fmt.Sprintf("session %d: %v", i+1, err))
+			continue
+		}
+		// A fresh ID, an exported one may already exist in another guild
+		session.ID = newSessionID()
+		session.GuildID = m.GuildID
+		valid = append(valid, session)
+	}
+
+	data.mu.Lock()
+	userData := data.getOrCreateUser(m.GuildID, m.Author.ID)
+	imported, duplicates := mergeSessions(userData, valid)
+	if imported > 0 {
+		data.invalidateTotalsLocked(m.GuildID)
+		data.trimSessionsLocked(m.GuildID, userData)
+		if err := data.saveLocked(); err != nil {
+			slog.Error("Error saving imported sessions", "username", username, "error", err)
+		}
+	}
+	data.mu.Unlock()
+
+	response := fmt.Sprintf("Hey %s, I imported %d session(s).", username, imported)
+	if duplicates > 0 {
+		response += fmt.Sprintf(" %d were already in your history and skipped.", duplicates)
+	}
+	if len(rejections) > 0 {
+		response += fmt.Sprintf(" %d were rejected:\n", len(rejections))
+		for i, rejection := range rejections {
+			if i == maxImportErrorsShown {
+				response += fmt.Sprintf("...and %d more\n", len(rejections)-maxImportErrorsShown)
+				break
+			}
+			response += fmt.Sprintf("- %s\n", sanitizeName(rejection))
+		}
+	}
+	sendChunked(s, m.ChannelID, response)
+}
+
+// fetchImportSessions downloads an attachment and decodes it as a JSON array of sessions
+func fetchImportSessions(url string) ([]GameSession, error) {
+	resp, err := importClient.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("error downloading attachment: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("error downloading attachment: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxImportSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error downloading attachment: %w", err)
+	}
+	if len(body) > maxImportSize {
+		return nil, fmt.Errorf("attachment is larger than %d bytes", maxImportSize)
+	}
+
+	var sessions []GameSession
+	if err := json.Unmarshal(body, &sessions); err != nil {
+		return nil, fmt.Errorf("error unmarshaling sessions: %w", err)
+	}
+	return sessions, nil
+}
+
+// validateImportedSession checks a session from an import and returns it cleaned up. The duration
+// is always recomputed from the start and end times.
+func validateImportedSession(session GameSession, now time.Time) (GameSession, error) {
+	session.GameName = strings.TrimSpace(session.GameName)
+	switch {
+	case session.GameName == "":
+		return GameSession{}, fmt.Errorf("the game name is empty")
+	case session.StartTime.IsZero() || session.EndTime.IsZero():
+		return GameSession{}, fmt.Errorf("the start or end time is missing")
+	case !session.EndTime.After(session.StartTime):
+		return GameSession{}, fmt.Errorf("it ends before it starts")
+	case session.EndTime.After(now):
+		return GameSession{}, fmt.Errorf("it ends in the future")
+	}
+	if session.ActivityType != "" && session.ActivityType != voiceActivityType {
+		activityType, ok := activityTypeByName(session.ActivityType)
+		if !ok {
+			return GameSession{}, fmt.Errorf("unknown activity type %q", session.ActivityType)
+		}
+		session.ActivityType = sessionActivityType(activityType)
+	}
+
+	imported := newGameSession(session.GameName, session.StartTime, session.EndTime)
+	imported.ActivityType = session.ActivityType
+	return imported, nil
+}
+
+// mergeSessions adds sessions to the user's history, skipping any with the same game, start and end
+// as one already there. It returns how many were added and how many were skipped. The caller must
+// hold data.mu.
+func mergeSessions(userData *UserGameData, sessions []GameSession) (imported, duplicates int) {
+	type sessionKey struct {
+		game       string
+		start, end int64
+	}
+	keyOf := func(session GameSession) sessionKey {
+		return sessionKey{activityKey(session.GameName), session.StartTime.UnixNano(), session.EndTime.UnixNano()}
+	}
+
+	existing := make(map[sessionKey]bool, len(userData.Sessions))
+	for _, session := range userData.Sessions {
+		existing[keyOf(session)] = true
+	}
+	for _, session := range sessions {
+		key := keyOf(session)
+		if existing[key] {
+			duplicates++
+			continue
+		}
+		existing[key] = true
+		userData.Sessions = append(userData.Sessions, session)
+		imported++
+	}
+	if imported > 0 {
+		sort.SliceStable(userData.Sessions, func(i, j int) bool {
+			return userData.Sessions[i].StartTime.Before(userData.Sessions[j].StartTime)
+		})
+	}
+	return imported, duplicates
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// TestImportIdentifiesSessions checks that imported sessions get their own ID and the guild they
+// were imported in, even when the file carries another guild's
+func TestImportIdentifiesSessions(t *testing.T) {
+	store := newTestStore(t)
+	setForTest(t, &commandCooldown, 0)
+	start := time.Now().Add(-5 * time.Hour).UTC()
+	export := fmt.Sprintf(`[{"id":"exported","guild_id":"other","game_name":"Minecraft","start_time":%q,"end_time":%q},{"game_name":"Tetris","start_time":%q,"end_time":%q}]`,
+		start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339),
+		start.Add(2*time.Hour).Format(time.RFC3339), start.Add(3*time.Hour).Format(time.RFC3339))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, export)
+	}))
+	defer server.Close()
+
+	m := testMessage("1", "!import")
+	m.Attachments = []*discordgo.MessageAttachment{{URL: server.URL, Size: len(export)}}
+	dispatchCommand(newFakeSession(), m)
+
+	userData, _ := store.snapshotUser("guild", "1")
+	if len(userData.Sessions) != 2 {
+		t.Fatalf("imported sessions = %+v, want 2", userData.Sessions)
+	}
+	ids := make(map[string]bool)
+	for _, session := range userData.Sessions {
+		if session.ID == "" || session.ID == "exported" || ids[session.ID] {
+			t.Errorf("session of %s has ID %q, want a new unique one", session.GameName, session.ID)
+		}
+		ids[session.ID] = true
+		if session.GuildID != "guild" {
+			t.Errorf("session of %s has guild %q, want guild", session.GameName, session.GuildID)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	topGamesLimit = 10 // Number of games shown by !topgames
+	recordsShown  = 5  // Number of sessions shown by !records
+)
+
+// gameTotal is the play time of one game aggregated over several players
+type gameTotal struct {
+	name     string
+	duration time.Duration
+	players  int // Number of distinct players who played the game
+}
+
+// handleTopGames implements the !topgames command: the most played games in the guild
+func handleTopGames(s messageSender, m *discordgo.MessageCreate, args string) {
+	now := time.Now()
+
+	data.mu.Lock()
+	ranked := rankGuildGames(data.Guilds[m.GuildID], func(userData *UserGameData) map[string]time.Duration {
+		return gamePlayTimes(userData, now)
+	})
+	data.mu.Unlock()
+
+	if len(ranked) == 0 {
+		sendChunked(s, m.ChannelID, "I haven't tracked any games in this server yet!")
+		return
+	}
+
+	if len(ranked) > topGamesLimit {
+		ranked = ranked[:topGamesLimit]
+	}
+
+	response := "Top games in this server:\n"
+	for i, total := range ranked {
+		players := "players"
+		if total.players == 1 {
+			players = "player"
+		}
+		response += fmt.Sprintf("%d. **%s**: %s (%d %s)\n", i+1, sanitizeName(total.name), formatDuration(total.duration), total.players, players)
+	}
+
+	sendChunked(s, m.ChannelID, response)
+}
+
+// rankGuildGames adds up the play times of every user in a guild per game, ordered by sortGameTotals.
+// The caller must hold data.mu.
+func rankGuildGames(users map[string]*UserGameData, playTimes func(*UserGameData) map[string]time.Duration) []*gameTotal {
+	totals := make(map[string]*gameTotal)
+	for _, userData := range users {
+		for gameName, duration := range playTimes(userData) {
+			total, ok := totals[gameName]
+			if !ok {
+				total = &gameTotal{name: gameName}
+				totals[gameName] = total
+			}
+			total.duration += duration
+			total.players++
+		}
+	}
+
+	ranked := make([]*gameTotal, 0, len(totals))
+	for _, total := range totals {
+		ranked = append(ranked, total)
+	}
+	sortGameTotals(ranked)
+	return ranked
+}
+
+// rankGames turns per-game play times into a slice ordered by sortGameTotals
+func rankGames(playTimes map[string]time.Duration) []*gameTotal {
+	ranked := make([]*gameTotal, 0, len(playTimes))
+	for gameName, duration := range playTimes {
+		ranked = append(ranked, &gameTotal{name: gameName, duration: duration})
+	}
+	sortGameTotals(ranked)
+	return ranked
+}
+
+// sortGameTotals orders games by total duration, longest first, breaking ties by name
+func sortGameTotals(totals []*gameTotal) {
+	sort.Slice(totals, func(i, j int) bool {
+		if totals[i].duration != totals[j].duration {
+			return totals[i].duration > totals[j].duration
+		}
+		return totals[i].name < totals[j].name
+	})
+}
+
+// handleStats implements the !stats command: aggregate tracking numbers for the guild, for admins
+func handleStats(s messageSender, m *discordgo.MessageCreate, args string) {
+	if !canAdminister(s, m) {
+		sendChunked(s, m.ChannelID, "Sorry, only admins can use this command.")
+		return
+	}
+
+	var playingNow int
+
+	data.mu.Lock()
+	users := len(data.Guilds[m.GuildID])
+	for _, userData := range data.Guilds[m.GuildID] {
+		playingNow += len(userData.ActiveGames)
+	}
+	totals := data.guildTotalsLocked(m.GuildID)
+	data.mu.Unlock()
+
+	response := "Tracking stats for this server:\n"
+	response += fmt.Sprintf("- Users tracked: %d\n", users)
+	response += fmt.Sprintf("- Sessions recorded: %d\n", totals.sessions)
+	response += fmt.Sprintf("- Combined play time: %s\n", formatDuration(time.Duration(totals.seconds*float64(time.Second))))
+	if totals.longestUserID != "" {
+		response += fmt.Sprintf("- Longest session: %s of **%s** by <@%s>\n", formatDuration(time.Duration(totals.longest.Duration)*time.Second), sanitizeName(totals.longest.GameName), totals.longestUserID)
+	}
+	response += fmt.Sprintf("- Games being played right now: %d\n", playingNow)
+
+	sendChunkedWithoutPings(s, m.ChannelID, response)
+}
+
+// handleRank implements the !rank command: the user's position among the guild's players by total play time
+func handleRank(s messageSender, m *discordgo.MessageCreate, args string) {
+	now := time.Now()
+
+	data.mu.Lock()
+	var own time.Duration
+	var totals []time.Duration
+	for userID, userData := range data.Guilds[m.GuildID] {
+		var total time.Duration
+		for _, duration := range gamePlayTimes(userData, now) {
+			total += duration
+		}
+		if total <= 0 {
+			continue
+		}
+		totals = append(totals, total)
+		if userID == m.Author.ID {
+			own = total
+		}
+	}
+	data.mu.Unlock()
+
+	if own <= 0 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I haven't tracked any games for you yet, so you're not ranked!", m.Author.Username))
+		return
+	}
+
+	// Players with the same total share a rank
+	rank := 1
+	for _, total := range totals {
+		if total > own {
+			rank++
+		}
+	}
+
+	players := "players"
+	if len(totals) == 1 {
+		players = "player"
+	}
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you're #%d of %d tracked %s with %s.", m.Author.Username, rank, len(totals), players, formatDuration(own)))
+}
+
+// handleBotInfo implements the !botinfo command: uptime and how much data the bot holds
+func handleBotInfo(s messageSender, m *discordgo.MessageCreate, args string) {
+	var guilds, users, sessions, active int
+
+	data.mu.Lock()
+	for guildID, guildUsers := range data.Guilds {
+		guilds++
+		sessions += data.guildTotalsLocked(guildID).sessions
+		for _, userData := range guildUsers {
+			users++
+			active += len(userData.ActiveGames)
+		}
+	}
+	data.mu.Unlock()
+
+	response := "Bot info:\n"
+	response += fmt.Sprintf("- Uptime: %s\n", formatDuration(time.Since(startedAt)))
+	response += fmt.Sprintf("- Servers with data: %d\n", guilds)
+	response += fmt.Sprintf("- Users tracked: %d\n", users)
+	response += fmt.Sprintf("- Sessions recorded: %d\n", sessions)
+	response += fmt.Sprintf("- Active sessions: %d\n", active)
+	sendChunked(s, m.ChannelID, response)
+}
+
+// handleMVP implements the !mvp command: the member who played the most this week, every one of them on a tie
+func handleMVP(s messageSender, m *discordgo.MessageCreate, args string) {
+	now := time.Now()
+	weekStart := startOfWeek(now)
+
+	// Compare whole seconds, so players a few nanoseconds apart still tie
+	var best time.Duration
+	var mvps []string
+	topGames := make(map[string]string)
+
+	data.mu.Lock()
+	for userID, userData := range data.Guilds[m.GuildID] {
+		playTimes := gamePlayTimesBetween(userData, weekStart, now)
+		var total time.Duration
+		for _, duration := range playTimes {
+			total += duration
+		}
+		total = total.Truncate(time.Second)
+		if total <= 0 || total < best {
+			continue
+		}
+		if total > best {
+			best = total
+			mvps = mvps[:0]
+		}
+		mvps = append(mvps, userID)
+		topGames[userID] = rankGames(playTimes)[0].name
+	}
+	data.mu.Unlock()
+
+	if len(mvps) == 0 {
+		sendChunked(s, m.ChannelID, "Nobody has played anything this week yet!")
+		return
+	}
+	sort.Strings(mvps)
+
+	if len(mvps) == 1 {
+		sendChunkedWithoutPings(s, m.ChannelID, fmt.Sprintf("This week's MVP is <@%s> with %s played, mostly **%s**!", mvps[0], formatDuration(best), sanitizeName(topGames[mvps[0]])))
+		return
+	}
+	response := fmt.Sprintf("It's a tie! This week's MVPs each played %s:\n", formatDuration(best))
+	for _, userID := range mvps {
+		response += fmt.Sprintf("- <@%s>, mostly **%s**\n", userID, sanitizeName(topGames[userID]))
+	}
+	sendChunkedWithoutPings(s, m.ChannelID, response)
+}
+
+// handleRecords implements the !records command: the longest single sessions anyone in this server played
+func handleRecords(s messageSender, m *discordgo.MessageCreate, args string) {
+	type record struct {
+		userID  string
+		session GameSession
+	}
+
+	var records []record
+	location := time.UTC
+	data.mu.Lock()
+	for userID, userData := range data.Guilds[m.GuildID] {
+		for _, session := range userData.Sessions {
+			records = append(records, record{userID, session})
+		}
+		if userID == m.Author.ID {
+			location = userLocation(userData)
+		}
+	}
+	data.mu.Unlock()
+
+	if len(records) == 0 {
+		sendChunked(s, m.ChannelID, "No sessions have been recorded in this server yet!")
+		return
+	}
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].session.Duration != records[j].session.Duration {
+			return records[i].session.Duration > records[j].session.Duration
+		}
+		return records[i].session.StartTime.Before(records[j].session.StartTime)
+	})
+	if len(records) > recordsShown {
+		records = records[:recordsShown]
+	}
+
+	response := "Longest sessions in this server:\n"
+	for i, r := range records {
+		response += fmt.Sprintf("%d. <@%s>: %s of **%s** on %s\n", i+1, r.userID, formatDuration(time.Duration(r.session.Duration)*time.Second), sanitizeName(r.session.GameName), r.session.StartTime.In(location).Format(dateFormat))
+	}
+	sendChunkedWithoutPings(s, m.ChannelID, response)
+}
+
+// handleNowPlaying implements the !nowplaying command: everyone in this server playing something
+// right now, longest running first
+func handleNowPlaying(s messageSender, m *discordgo.MessageCreate, args string) {
+	type nowPlaying struct {
+		userID   string
+		gameName string
+		duration time.Duration
+	}
+	now := time.Now()
+
+	var playing []nowPlaying
+	data.mu.Lock()
+	for userID, userData := range data.Guilds[m.GuildID] {
+		for gameName, startTime := range userData.ActiveGames {
+			// Games restored from disk may have stopped while the bot was down
+			if userData.restoredGames[gameName] {
+				continue
+			}
+			playing = append(playing, nowPlaying{userID, gameName, now.Sub(activeStart(userData, gameName, startTime))})
+		}
+	}
+	data.mu.Unlock()
+
+	if len(playing) == 0 {
+		sendChunked(s, m.ChannelID, "Nobody is playing anything right now.")
+		return
+	}
+	sort.Slice(playing, func(i, j int) bool {
+		if playing[i].duration != playing[j].duration {
+			return playing[i].duration > playing[j].duration
+		}
+		return playing[i].userID < playing[j].userID
+	})
+
+	response := "Playing right now:\n"
+	for _, p := range playing {
+		response += fmt.Sprintf("- <@%s>: **%s** for %s\n", p.userID, sanitizeName(p.gameName), formatDuration(p.duration))
+	}
+	sendChunkedWithoutPings(s, m.ChannelID, response)
+}
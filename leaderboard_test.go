@@ -0,0 +1,33 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestListingsDontPing checks that the commands listing members mention them without notifying them
+func TestListingsDontPing(t *testing.T) {
+	tests := []string{"!nowplaying", "!mvp", "!records", "!stats"}
+	for _, content := range tests {
+		t.Run(content, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			setForTest(t, &botAdmins, map[string]bool{"1": true})
+			addSession(store, "2", "Minecraft", time.Now().Add(-2*time.Hour), time.Hour)
+			handlePresence(nil, testPresence("2", time.Now().Add(-time.Minute), "Tetris"), time.Time{})
+			s := newFakeSession()
+
+			dispatchCommand(s, testMessage("1", content))
+
+			if len(s.sent) == 0 || !strings.Contains(s.sent[0].content, "<@2>") {
+				t.Fatalf("sent %+v, want a listing mentioning user 2", s.sent)
+			}
+			for _, message := range s.sent {
+				if mentions := message.allowedMentions; mentions == nil || len(mentions.Parse) > 0 || len(mentions.Users) > 0 {
+					t.Errorf("message %q allows mentions %+v, want none", message.content, mentions)
+				}
+			}
+		})
+	}
+}
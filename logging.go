@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// setupLogging configures the default logger from LOG_FORMAT ("text", the default, or "json")
+// and LOG_LEVEL ("debug", "info", "warn" or "error"). The standard log package writes through
+// the same logger, so discordgo's messages follow the configured format too.
+// Invalid values are logged and replaced by the default, a typo shouldn't keep the bot from starting.
+func setupLogging(format, level string) {
+	var logLevel slog.Level
+	invalidLevel := false
+	if level = strings.TrimSpace(level); level != "" {
+		if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+			logLevel = slog.LevelInfo
+			invalidLevel = true
+		}
+	}
+
+	invalidFormat := false
+	switch name := strings.ToLower(strings.TrimSpace(format)); name {
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel})))
+	default:
+		invalidFormat = name != "" && name != "text"
+		// Keep the default logger's human-readable output, only adjust its level
+		slog.SetLogLoggerLevel(logLevel)
+	}
+
+	// Reported once the logger is set up, so the warnings come out in the format that is used
+	if invalidLevel {
+		slog.Warn("Invalid LOG_LEVEL, using info", "value", level)
+	}
+	if invalidFormat {
+		slog.Warn("Invalid LOG_FORMAT, using text", "value", format)
+	}
+}
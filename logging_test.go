@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"testing"
+)
+
+func TestSetupLogging(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		level     string
+		wantJSON  bool
+		wantLevel slog.Level
+	}{
+		{"defaults", "", "", false, slog.LevelInfo},
+		{"json debug", "json", "debug", true, slog.LevelDebug},
+		{"text warn", "TEXT", " warn ", false, slog.LevelWarn},
+		{"invalid level", "json", "loud", true, slog.LevelInfo},
+		{"invalid format", "xml", "error", false, slog.LevelError},
+		{"both invalid", "xml", "loud", false, slog.LevelInfo},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			previous := slog.Default()
+			previousLevel := slog.SetLogLoggerLevel(slog.LevelInfo)
+			t.Cleanup(func() {
+				slog.SetDefault(previous)
+				slog.SetLogLoggerLevel(previousLevel)
+			})
+
+			setupLogging(tt.format, tt.level)
+
+			handler := slog.Default().Handler()
+			if _, isJSON := handler.(*slog.JSONHandler); isJSON != tt.wantJSON {
+				t.Errorf("JSON handler = %v, want %v", isJSON, tt.wantJSON)
+			}
+			ctx := context.Background()
+			if !handler.Enabled(ctx, tt.wantLevel) || (tt.wantLevel > slog.LevelDebug && handler.Enabled(ctx, tt.wantLevel-1)) {
+				t.Errorf("logger isn't set to level %v", tt.wantLevel)
+			}
+		})
+	}
+}
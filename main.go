@@ -1,12 +1,16 @@
 package main
 
 import (
-	"encoding/json"
+	"crypto/rand"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -20,6 +24,13 @@ type GameSession struct {
 	StartTime time.Time `json:"start_time"`
 	EndTime   time.Time `json:"end_time"`
 	Duration  float64   `json:"duration_seconds"` // Duration in seconds
+	// Kind of activity, e.g. "listening". Empty for games, the default.
+	ActivityType string `json:"activity_type,omitempty"`
+	// Guild the session was observed in. Empty for sessions recorded before this was stored.
+	GuildID string `json:"guild_id,omitempty"`
+	// Random UUID given when the session starts, so a session interrupted by a restart can be
+	// matched when it continues. Empty for sessions recorded before this was stored.
+	ID string `json:"id,omitempty"`
 }
 
 // UserGameData stores all game sessions for a user
@@ -27,82 +38,721 @@ type UserGameData struct {
 	Sessions []GameSession `json:"sessions"`
 	// Map to track currently active game sessions for a user
 	// Key: Game Name, Value: Start Time
-	ActiveGames map[string]time.Time `json:"-"` // This field is not persisted
+	// Persisted so that sessions in progress survive a restart
+	// Invariant: an active game never overlaps a recorded session of the same game. A session is
+	// recorded in the same locked update that removes the game from here, and a resumed session is
+	// taken out of Sessions again. Aggregations still go through activeStart so a slip can't count
+	// the same time twice.
+	ActiveGames map[string]time.Time `json:"active_games,omitempty"`
+	// Activity type of active sessions that aren't games, stored like GameSession.ActivityType
+	ActiveTypes map[string]string `json:"active_types,omitempty"`
+	// Discord application ID of active sessions that reported one, used to match them with MATCH_BY_APPLICATION_ID
+	ActiveAppIDs map[string]string `json:"active_app_ids,omitempty"`
+	// Session ID of each active game, carried into the GameSession when it ends
+	ActiveIDs map[string]string `json:"active_ids,omitempty"`
+	// Session IDs of games that were still running when the bot shut down, keyed by game name.
+	// If the next presence update shows the game still running, that session continues.
+	FinalizedIDs map[string]string `json:"finalized_ids,omitempty"`
+	// Active games restored from disk that no presence update has confirmed yet
+	restoredGames map[string]bool
+	// When each game last stopped, kept for the merge window so a flapping presence can resume the session
+	recentlyStopped map[string]time.Time
+	// When a presence update without activities arrived that hasn't been confirmed yet, see emptyActivityGrace
+	emptySince time.Time
+	// Voice channel the user is in while a voice session is active, see TRACK_VOICE
+	voiceChannel string
+	// Daily play-time budget in seconds, 0 means no budget is set
+	DailyBudget float64 `json:"daily_budget_seconds,omitempty"`
+	// Day (YYYY-MM-DD) and level of the last budget warning, so each warning is sent at most once per day
+	BudgetWarnDay   string `json:"budget_warn_day,omitempty"`
+	BudgetWarnLevel int    `json:"budget_warn_level,omitempty"`
+	// Weekly play-time goal in seconds, 0 means no goal is set
+	WeeklyGoal float64 `json:"weekly_goal_seconds,omitempty"`
+	// Highest milestone threshold in seconds the user was congratulated for, per game
+	NotifiedMilestones map[string]float64 `json:"notified_milestones,omitempty"`
+	// IANA timezone dates are shown in, empty means UTC
+	Timezone string `json:"timezone,omitempty"`
+	// Monday (YYYY-MM-DD) of the last week the user was sent a wrap-up for
+	WrapupWeek string `json:"wrapup_week,omitempty"`
+	// When the bot first saw the user in this guild
+	FirstSeen time.Time `json:"first_seen,omitempty"`
+	// Play-time goals for single games, keyed by game name
+	GameGoals map[string]*GameGoal `json:"game_goals,omitempty"`
+	// Length in seconds of a single session after which the user is reminded to take a break, 0 means off
+	BreakReminder float64 `json:"break_reminder_seconds,omitempty"`
+	// Start time of the active session of each game the user was already reminded about
+	RemindedSessions map[string]time.Time `json:"reminded_sessions,omitempty"`
+	// Whether the user gets a DM summing up each session when it ends
+	NotifySessions bool `json:"notify_sessions,omitempty"`
+	// Whether the user turned budget warnings off, see !notify
+	MuteBudgetWarnings bool `json:"mute_budget_warnings,omitempty"`
+	// Whether the user turned milestone congratulations off, see !notify
+	MuteMilestones bool `json:"mute_milestones,omitempty"`
+	// Hours of the day in the user's timezone during which budget warnings wait, e.g. "23-7".
+	// Empty means none.
+	QuietHours string `json:"quiet_hours,omitempty"`
+	// How durations are shown to the user, a key of durationFormats. Empty means compact.
+	DurationFormat string `json:"duration_format,omitempty"`
+	// Play time in seconds of sessions dropped to stay within MAX_SESSIONS_PER_USER, per game, so
+	// all-time totals still count them
+	TrimmedTotals map[string]float64 `json:"trimmed_totals_seconds,omitempty"`
+	// Number of sessions folded into TrimmedTotals, per game
+	TrimmedCounts map[string]int `json:"trimmed_counts,omitempty"`
 }
 
-// DataStore holds all user game data
+// GameGoal is a target total play time for one game
+type GameGoal struct {
+	Target  float64 `json:"target_seconds"`
+	Reached bool    `json:"reached,omitempty"` // The user was congratulated, so it's only done once
+}
+
+// DataStore holds all user game data, scoped per guild so servers don't see each other's data
 type DataStore struct {
-	Users map[string]*UserGameData `json:"users"` // Key: User ID
-	mu    sync.Mutex               // Mutex to protect concurrent access to Users map
+	Guilds map[string]map[string]*UserGameData `json:"guilds"` // Key: Guild ID, then User ID
+	mu     sync.Mutex                          // Mutex to protect concurrent access to Guilds map
+	// When the data was last saved before it was loaded, i.e. roughly when the bot went down
+	lastSavedAt time.Time
+	backend     storageBackend  // Where the data is persisted
+	dirty       bool            // Whether there are changes that haven't been saved yet
+	optedOut    map[string]bool // IDs of users who asked not to be tracked, in any guild
+	// Cached aggregates of each guild's recorded sessions, see guildTotalsLocked
+	totals map[string]*guildTotals
+	// Saves that failed in a row, the background saver backs off while this is above zero
+	saveFailures int
+	alert        func(message string) // Reports persistent save failures, may be nil
+}
+
+// newDataStore returns an empty store persisted by backend. The tracking and command handlers only
+// use the store, so they run the same on every backend, including the in-memory one in tests.
+func newDataStore(backend storageBackend) *DataStore {
+	return &DataStore{
+		Guilds:   make(map[string]map[string]*UserGameData),
+		backend:  backend,
+		optedOut: make(map[string]bool),
+	}
 }
 
 const (
-	dataFilePath = "game_data.json"
+	// Data file used when DATA_FILE_PATH isn't set, relative to the working directory
+	defaultDataFilePath = "game_data.json"
+	// Suffix of the previous good copy of the data file, used if the data file can't be read
+	backupFileSuffix = ".bak"
+	// Database file used by the SQLite storage backend
+	sqliteFilePath = "game_data.db"
+	// Guild that data from before guild scoping is migrated to
+	legacyGuildID = "legacy"
+	// How often unsaved changes are flushed to storage by default
+	defaultSaveInterval = 30 * time.Second
+	// How often the whole store is saved by default, changed or not
+	defaultAutosaveInterval = 5 * time.Minute
+	// Longest the background saver waits between retries of a failing save
+	maxSaveBackoff = 10 * time.Minute
+	// Failed saves in a row after which an alert is sent
+	saveFailureAlertThreshold = 3
+	// Attempts at the final save on shutdown
+	finalSaveAttempts = 3
+	// How soon a game has to restart to continue its previous session by default
+	defaultMergeWindow = 60 * time.Second
+	// Default for emptyActivityGrace
+	defaultEmptyActivityGrace = 10 * time.Second
 )
 
 var (
-	botToken string
-	data     *DataStore
+	botToken     string
+	data         *DataStore
+	dataFilePath = defaultDataFilePath // Configurable via DATA_FILE_PATH
+	startedAt    time.Time             // When the bot process started, set in main
+	saveInterval = defaultSaveInterval // Configurable via SAVE_INTERVAL, e.g. "30s"
+	mergeWindow  = defaultMergeWindow  // Configurable via SESSION_MERGE_WINDOW, 0 disables merging
+	// Active games are only written along with other changes, so the whole store is also saved this
+	// often. The last save is when sessions restored after a crash end, so a crash loses at most one
+	// interval. Configurable via AUTOSAVE_INTERVAL, 0 disables it.
+	autosaveInterval = defaultAutosaveInterval
+	// Discord sometimes sends a presence without activities as a transient partial update. Such an
+	// update only ends the user's sessions if no update with activities follows within this grace
+	// period, and they then end at the time of the empty update. Users going offline end them right
+	// away. Configurable via EMPTY_ACTIVITY_GRACE, 0 ends sessions immediately.
+	emptyActivityGrace = defaultEmptyActivityGrace
+	// Sessions shorter than this many seconds are discarded, configurable via MIN_SESSION_SECONDS
+	minSessionSeconds float64
+	// Longest a single session counts for, so a game left open for weeks or a missed stop doesn't
+	// skew every stat. Configurable via MAX_SESSION_HOURS, 0 means no limit.
+	maxSessionDuration time.Duration
+	// Channel alerts such as repeated save failures are posted to, configurable via ALERT_CHANNEL_ID
+	alertChannelID string
 )
 
-func init() {
+// setup reads the configuration from the environment and loads the data store. It runs
+// from main rather than init so the package can be imported without a bot token.
+func setup() error {
+	// Configure logging first so everything below is logged in the requested format
+	setupLogging(os.Getenv("LOG_FORMAT"), os.Getenv("LOG_LEVEL"))
+
 	// Load Discord bot token from environment variable
 	botToken = os.Getenv("DISCORD_BOT_TOKEN")
 	if botToken == "" {
-		log.Fatal("DISCORD_BOT_TOKEN environment variable not set.")
+		return fmt.Errorf("DISCORD_BOT_TOKEN environment variable not set")
+	}
+
+	// Use a custom command prefix if configured, so the bot doesn't clash with other bots
+	if prefix := strings.TrimSpace(os.Getenv("COMMAND_PREFIX")); prefix != "" {
+		commandPrefix = prefix
+	}
+
+	// Read how long users have to wait between commands
+	if value := os.Getenv("COMMAND_COOLDOWN"); value != "" {
+		cooldown, err := time.ParseDuration(value)
+		if err != nil || cooldown < 0 {
+			slog.Warn("Invalid COMMAND_COOLDOWN, using the default", "value", value, "default", defaultCommandCooldown)
+		} else {
+			commandCooldown = cooldown
+		}
+	}
+
+	// Read how often changes are flushed to storage
+	if value := os.Getenv("SAVE_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			slog.Warn("Invalid SAVE_INTERVAL, using the default", "value", value, "default", defaultSaveInterval)
+		} else {
+			saveInterval = interval
+		}
+	}
+	if value := os.Getenv("AUTOSAVE_INTERVAL"); value != "" {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			slog.Warn("Invalid AUTOSAVE_INTERVAL, using the default", "value", value, "default", defaultAutosaveInterval)
+		} else {
+			autosaveInterval = interval
+		}
+	}
+
+	// Read the shortest session worth keeping
+	if value := os.Getenv("MIN_SESSION_SECONDS"); value != "" {
+		seconds, err := strconv.ParseFloat(value, 64)
+		if err != nil || seconds < 0 {
+			slog.Warn("Invalid MIN_SESSION_SECONDS, keeping all sessions", "value", value)
+		} else {
+			minSessionSeconds = seconds
+		}
+	}
+
+	// Read the longest a session may count for
+	if value := os.Getenv("MAX_SESSION_HOURS"); value != "" {
+		hours, err := strconv.ParseFloat(value, 64)
+		if err != nil || hours < 0 {
+			slog.Warn("Invalid MAX_SESSION_HOURS, not limiting sessions", "value", value)
+		} else {
+			maxSessionDuration = time.Duration(hours * float64(time.Hour))
+		}
+	}
+
+	// Read how soon a restarted game continues its previous session
+	if value := os.Getenv("SESSION_MERGE_WINDOW"); value != "" {
+		window, err := time.ParseDuration(value)
+		if err != nil || window < 0 {
+			slog.Warn("Invalid SESSION_MERGE_WINDOW, using the default", "value", value, "default", defaultMergeWindow)
+		} else {
+			mergeWindow = window
+		}
+	}
+
+	if value := os.Getenv("EMPTY_ACTIVITY_GRACE"); value != "" {
+		grace, err := time.ParseDuration(value)
+		if err != nil || grace < 0 {
+			slog.Warn("Invalid EMPTY_ACTIVITY_GRACE, using the default", "value", value, "default", defaultEmptyActivityGrace)
+		} else {
+			emptyActivityGrace = grace
+		}
 	}
 
-	// Initialize data store
-	data = &DataStore{
-		Users: make(map[string]*UserGameData),
+	// Record time in voice channels as a game if enabled
+	if value := os.Getenv("TRACK_VOICE"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Warn("Invalid TRACK_VOICE, not tracking voice channels", "value", value)
+		} else {
+			trackVoice = enabled
+		}
 	}
 
+	// Match sessions by Discord application ID instead of name if enabled
+	if value := os.Getenv("MATCH_BY_APPLICATION_ID"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Warn("Invalid MATCH_BY_APPLICATION_ID, matching games by name", "value", value)
+		} else {
+			matchByApplicationID = enabled
+		}
+	}
+
+	// Read which activity types to track, only games unless configured otherwise
+	if value := os.Getenv("TRACK_ACTIVITY_TYPES"); value != "" {
+		tracked, err := parseTrackedActivityTypes(value)
+		if err != nil {
+			slog.Warn("Invalid TRACK_ACTIVITY_TYPES, tracking games only", "value", value, "error", err)
+		} else {
+			trackedActivityTypes = tracked
+		}
+	}
+
+	// Load the list of games that should never be tracked
+	if err := loadIgnoredGames(); err != nil {
+		slog.Warn("Could not load ignored games, tracking all games", "error", err)
+	}
+	if len(ignoredGames) > 0 {
+		slog.Info("Loaded ignored games", "games", len(ignoredGames))
+	}
+
+	// Load the rules that collapse other names of a game into one
+	if err := loadGameNameRules(); err != nil {
+		slog.Warn("Could not load game name rules, tracking games under the names Discord reports", "error", err)
+	}
+	if len(gameNameRules) > 0 {
+		slog.Info("Loaded game name rules", "rules", len(gameNameRules))
+	}
+
+	// Use a custom data file location if configured, e.g. an absolute path for a service
+	if path := strings.TrimSpace(os.Getenv("DATA_FILE_PATH")); path != "" {
+		dataFilePath = path
+	}
+	if value := os.Getenv("COMPRESS_DATA"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Warn("Invalid COMPRESS_DATA, writing an uncompressed data file", "value", value)
+		} else {
+			compressData = enabled
+		}
+	}
+
+	// Initialize data store with the configured storage backend
+	backend, err := newStorageBackend(os.Getenv("STORAGE_BACKEND"))
+	if err != nil {
+		return fmt.Errorf("error initializing storage: %w", err)
+	}
+	data = newDataStore(backend)
+
 	// Load existing data from file
 	if err := data.load(); err != nil {
-		log.Printf("Could not load game data: %v. Starting with empty data.", err)
+		slog.Warn("Could not load game data, starting with empty data", "error", err)
+	}
+
+	// Move sessions from before this month into monthly archive files, if enabled
+	if value := os.Getenv("ARCHIVE_SESSIONS"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Warn("Invalid ARCHIVE_SESSIONS, keeping all sessions in the data file", "value", value)
+		} else {
+			archiveSessions = enabled
+		}
+	}
+	if _, ok := backend.(*jsonBackend); archiveSessions && !ok {
+		slog.Warn("ARCHIVE_SESSIONS only applies to the JSON storage backend, not archiving")
+		archiveSessions = false
+	}
+	if archiveSessions {
+		if _, err := data.archiveOldSessions(time.Now()); err != nil {
+			slog.Error("Error archiving sessions", "error", err)
+		}
+	}
+
+	// Limit how many sessions are kept per user, if configured
+	if value := os.Getenv("MAX_SESSIONS_PER_USER"); value != "" {
+		count, err := strconv.Atoi(value)
+		if err != nil || count < 0 {
+			slog.Warn("Invalid MAX_SESSIONS_PER_USER, keeping all sessions", "value", value)
+		} else {
+			maxSessionsPerUser = count
+		}
+	}
+	if maxSessionsPerUser > 0 {
+		data.mu.Lock()
+		for guildID, users := range data.Guilds {
+			for _, userData := range users {
+				data.trimSessionsLocked(guildID, userData)
+			}
+		}
+		data.mu.Unlock()
+	}
+
+	// Drop sessions older than the retention period, if one is configured
+	if value := os.Getenv("DATA_RETENTION_DAYS"); value != "" {
+		days, err := strconv.Atoi(value)
+		if err != nil || days < 0 {
+			slog.Warn("Invalid DATA_RETENTION_DAYS, keeping all sessions", "value", value)
+		} else {
+			retentionDays = days
+		}
+	}
+	if retentionDays > 0 {
+		data.pruneOldSessions(retentionCutoff(time.Now()))
+	}
+
+	// Post a daily summary if a channel is configured
+	summaryChannelID = strings.TrimSpace(os.Getenv("SUMMARY_CHANNEL_ID"))
+	if value := os.Getenv("SUMMARY_HOUR"); value != "" {
+		hour, err := strconv.Atoi(value)
+		if err != nil || hour < 0 || hour > 23 {
+			slog.Warn("Invalid SUMMARY_HOUR, posting at midnight", "value", value)
+		} else {
+			summaryHour = hour
+		}
+	}
+
+	// Read the status messages to rotate through
+	if value := os.Getenv("STATUS_MESSAGES"); value != "" {
+		messages, err := parseStatusMessages(value)
+		if err != nil {
+			slog.Warn("Invalid STATUS_MESSAGES, using the default statuses", "value", value, "error", err)
+		} else {
+			statusMessages = messages
+		}
 	}
+
+	// Users who may run admin commands on top of members with the Manage Server permission
+	if value := os.Getenv("BOT_ADMINS"); value != "" {
+		admins, err := parseBotAdmins(value)
+		if err != nil {
+			slog.Warn("Invalid BOT_ADMINS, only members with the Manage Server permission are admins", "value", value, "error", err)
+		} else {
+			botAdmins = admins
+		}
+	}
+
+	// Channel that is alerted when saving keeps failing
+	alertChannelID = strings.TrimSpace(os.Getenv("ALERT_CHANNEL_ID"))
+
+	// Webhook server records and big milestones are announced through
+	announceWebhookURL = strings.TrimSpace(os.Getenv("ANNOUNCE_WEBHOOK_URL"))
+
+	// Send weekly wrap-up DMs unless disabled
+	if value := os.Getenv("WEEKLY_WRAPUP"); value != "" {
+		enabled, err := strconv.ParseBool(value)
+		if err != nil {
+			slog.Warn("Invalid WEEKLY_WRAPUP, sending weekly wrap-ups", "value", value)
+		} else {
+			weeklyWrapup = enabled
+		}
+	}
+	return nil
 }
 
 func main() {
+	startedAt = time.Now()
+	if err := setup(); err != nil {
+		slog.Error("Could not start the bot", "error", err)
+		os.Exit(1)
+	}
+	if err := run(); err != nil {
+		slog.Error("Bot stopped with an error", "error", err)
+		os.Exit(1)
+	}
+}
+
+// run connects to Discord and tracks presences until the process is signaled to stop
+func run() error {
 	// Create a new Discord session
 	dg, err := discordgo.New("Bot " + botToken)
 	if err != nil {
-		log.Fatalf("Error creating Discord session: %v", err)
+		return fmt.Errorf("error creating Discord session: %w", err)
 	}
 
 	// Register event handlers
 	dg.AddHandler(ready)
+	dg.AddHandler(guildCreate)
+	dg.AddHandler(resumed)
 	dg.AddHandler(presenceUpdate)
 	dg.AddHandler(messageCreate)
+	dg.AddHandler(interactionCreate)
+	dg.AddHandler(messageReactionAdd)
+	dg.AddHandler(voiceStateUpdate)
 
-	// We need to specify intents to receive presence updates and message content
-	dg.Identify.Intents = discordgo.IntentsGuildPresences | discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent
+	// We need to specify intents to receive guilds with their presences, presence updates, message content
+	// and reactions, which page through long replies
+	dg.Identify.Intents = discordgo.IntentsGuilds | discordgo.IntentsGuildPresences | discordgo.IntentsGuildMessages | discordgo.IntentsMessageContent | discordgo.IntentsGuildMessageReactions
+	if trackVoice {
+		dg.Identify.Intents |= discordgo.IntentsGuildVoiceStates
+	}
 
 	// Open a websocket connection to Discord and begin listening
 	err = dg.Open()
 	if err != nil {
-		log.Fatalf("Error opening connection: %v", err)
+		return fmt.Errorf("error opening connection: %w", err)
+	}
+
+	// Start the background sweeper that checks active sessions against budgets
+	stopSweeper := make(chan struct{})
+	go runSweeper(dg, stopSweeper)
+
+	// Report persistent save failures in the alert channel, they are always logged
+	if alertChannelID != "" {
+		data.mu.Lock()
+		data.alert = func(message string) {
+			if err := sendChunked(dg, alertChannelID, message); err != nil {
+				slog.Error("Could not post alert", "channel_id", alertChannelID, "error", err)
+			}
+		}
+		data.mu.Unlock()
+	}
+
+	// Start the background saver that flushes changes at most once per interval
+	stopFlusher := make(chan struct{})
+	flusherDone := make(chan struct{})
+	go func() {
+		data.runFlusher(saveInterval, stopFlusher)
+		close(flusherDone)
+	}()
+
+	// Save everything, active games included, as a safety net against crashes
+	stopAutosave := make(chan struct{})
+	if autosaveInterval > 0 {
+		go data.runAutosave(autosaveInterval, stopAutosave)
+	}
+
+	// Periodically prune old sessions if a retention period is configured
+	stopRetention := make(chan struct{})
+	if retentionDays > 0 {
+		go runRetention(stopRetention)
+	}
+
+	// Archive old sessions when a new month starts, if enabled
+	stopArchive := make(chan struct{})
+	if archiveSessions {
+		go runArchive(stopArchive)
+	}
+
+	// Post the daily summary if a channel is configured
+	stopSummary := make(chan struct{})
+	if summaryChannelID != "" {
+		go runDailySummary(dg, stopSummary)
+	}
+
+	// Rotate through the status messages
+	stopStatus := make(chan struct{})
+	go runStatusRotation(dg, stopStatus)
+
+	// DM users their weekly wrap-up every Monday
+	stopWrapup := make(chan struct{})
+	if weeklyWrapup {
+		go runWeeklyWrapup(dg, stopWrapup)
+	}
+
+	// Serve the JSON API if an address is configured
+	var apiServer *http.Server
+	if addr := strings.TrimSpace(os.Getenv("HTTP_ADDR")); addr != "" {
+		apiServer = startAPIServer(addr)
 	}
 
-	log.Println("Bot is now running. Press CTRL-C to exit.")
+	// Expose Prometheus metrics if an address is configured
+	var metricsServer *http.Server
+	if addr := strings.TrimSpace(os.Getenv("METRICS_ADDR")); addr != "" {
+		metricsServer = startMetricsServer(addr)
+	}
+
+	slog.Info("Bot is now running. Press CTRL-C to exit.")
 	sc := make(chan os.Signal, 1)
 	signal.Notify(sc, syscall.SIGINT, syscall.SIGTERM, os.Interrupt, os.Kill)
 	<-sc // Block until a signal is received
 
 	// Cleanly close down the Discord session
-	log.Println("Shutting down bot...")
-	data.save() // Save data before closing
+	slog.Info("Shutting down bot")
+	if apiServer != nil {
+		stopHTTPServer(apiServer)
+	}
+	if metricsServer != nil {
+		stopHTTPServer(metricsServer)
+	}
+	close(stopSweeper)
+	close(stopRetention)
+	close(stopArchive)
+	close(stopSummary)
+	close(stopWrapup)
+	close(stopStatus)
+	close(stopAutosave)
+	close(stopFlusher)
+	<-flusherDone                           // Make sure no flush is still running
+	data.finalizeActiveSessions(time.Now()) // Record games still being played as completed sessions
+	// Final save of everything before closing, retried since there's no later flush to catch it
+	for attempt := 1; ; attempt++ {
+		err := data.save()
+		if err == nil {
+			break
+		}
+		if attempt == finalSaveAttempts {
+			slog.Error("Giving up saving game data, changes since the last save are lost", "error", err)
+			break
+		}
+		time.Sleep(time.Duration(attempt) * time.Second)
+	}
+	if err := data.backend.close(); err != nil {
+		slog.Error("Error closing storage", "error", err)
+	}
 	dg.Close()
+	return nil
 }
 
 // ready function is called when the bot successfully connects to Discord
 func ready(s *discordgo.Session, event *discordgo.Ready) {
-	log.Printf("Logged in as: %v#%v", event.User.Username, event.User.Discriminator)
-	s.UpdateGameStatus(0, "Tracking your games!")
+	slog.Info("Logged in", "username", event.User.Username, "discriminator", event.User.Discriminator, "guilds", len(event.Guilds))
+	s.UpdateGameStatus(0, renderStatus(statusMessages[0]))
+	registerSlashCommands(s)
+}
+
+// guildCreate is called for every guild once the bot connects, and when it joins a new one.
+// The Ready event only lists unavailable guilds, so this is the first time we see who is
+// already playing.
+func guildCreate(s *discordgo.Session, g *discordgo.GuildCreate) {
+	// Voice first, a presence update settles restored sessions before voice states could
+	seedVoiceStates(g.Guild)
+	seedPresences(s, g.Guild)
+}
+
+// resumed is called when the connection to Discord was re-established without a new session.
+// Discord replays the events we missed, but the state cache is reconciled against as well in
+// case some were dropped. Games already active keep their start time, so nothing is counted twice.
+func resumed(s *discordgo.Session, r *discordgo.Resumed) {
+	// Copy the guilds so the state lock isn't held while processing presences
+	s.State.RLock()
+	guilds := make([]*discordgo.Guild, 0, len(s.State.Guilds))
+	for _, guild := range s.State.Guilds {
+		guilds = append(guilds, &discordgo.Guild{
+			ID:          guild.ID,
+			Name:        guild.Name,
+			Members:     append([]*discordgo.Member(nil), guild.Members...),
+			Presences:   append([]*discordgo.Presence(nil), guild.Presences...),
+			VoiceStates: append([]*discordgo.VoiceState(nil), guild.VoiceStates...),
+		})
+	}
+	s.State.RUnlock()
+
+	slog.Info("Connection resumed, reconciling presences", "guilds", len(guilds))
+	for _, guild := range guilds {
+		seedVoiceStates(guild)
+		seedPresences(s, guild)
+	}
+}
+
+// seedPresences applies each presence of a guild like an update, which starts sessions for
+// games in progress and reconciles active sessions with what members are really playing
+func seedPresences(s *discordgo.Session, g *discordgo.Guild) {
+	// Presences only carry the user ID, the bot flag is on the member
+	bots := make(map[string]bool)
+	for _, member := range g.Members {
+		if member.User != nil && member.User.Bot {
+			bots[member.User.ID] = true
+		}
+	}
+
+	seeded := 0
+	for _, presence := range g.Presences {
+		if presence.User == nil || bots[presence.User.ID] {
+			continue
+		}
+		presenceUpdate(s, &discordgo.PresenceUpdate{Presence: *presence, GuildID: g.ID})
+		seeded++
+	}
+	slog.Info("Seeded presences", "guild_id", g.ID, "guild", g.Name, "members", seeded)
+}
+
+// newGameSession builds a completed session for a game played between startTime and endTime.
+// When both times come from time.Now, Sub uses their monotonic clock readings, so a system clock
+// change while the game ran doesn't change the duration. The end time is derived from the
+// duration to keep the two consistent, as the monotonic readings are lost once saved.
+func newGameSession(gameName string, startTime, endTime time.Time) GameSession {
+	duration := endTime.Sub(startTime)
+	return GameSession{
+		GameName:  gameName,
+		StartTime: startTime,
+		EndTime:   startTime.Add(duration),
+		Duration:  duration.Seconds(),
+	}
+}
+
+// newSessionID returns a random version 4 UUID identifying a session
+func newSessionID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err) // crypto/rand doesn't fail on supported platforms
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// reopenFinalizedSessionLocked removes the session of a game that was ended by a shutdown if the
+// game is still running, returning that session so it can continue instead of being recorded twice.
+// launchTime is when Discord says the game was started. The caller must hold data.mu.
+func reopenFinalizedSessionLocked(userData *UserGameData, gameName string, launchTime time.Time) (GameSession, bool) {
+	sessionID, ok := userData.FinalizedIDs[gameName]
+	if !ok {
+		return GameSession{}, false
+	}
+	delete(userData.FinalizedIDs, gameName)
+
+	for i := len(userData.Sessions) - 1; i >= 0; i-- {
+		session := userData.Sessions[i]
+		if session.ID != sessionID {
+			continue
+		}
+		// A launch after the shutdown means the user restarted the game, which is a new session
+		if !launchTime.Before(session.EndTime) {
+			return GameSession{}, false
+		}
+		userData.Sessions = append(userData.Sessions[:i], userData.Sessions[i+1:]...)
+		return session, true
+	}
+	return GameSession{}, false
+}
+
+// resumeRecentSessionLocked removes the user's last session of a game if it ended less than
+// the merge window before startTime, returning that session so it can continue.
+// The caller must hold data.mu.
+func resumeRecentSessionLocked(userData *UserGameData, gameName string, startTime time.Time) (GameSession, bool) {
+	stoppedAt, ok := userData.recentlyStopped[gameName]
+	if !ok {
+		return GameSession{}, false
+	}
+	delete(userData.recentlyStopped, gameName)
+	if startTime.Sub(stoppedAt) > mergeWindow {
+		return GameSession{}, false
+	}
+
+	for i := len(userData.Sessions) - 1; i >= 0; i-- {
+		session := userData.Sessions[i]
+		if session.GameName == gameName && session.EndTime.Equal(stoppedAt) {
+			userData.Sessions = append(userData.Sessions[:i], userData.Sessions[i+1:]...)
+			return session, true
+		}
+	}
+	return GameSession{}, false
+}
+
+// activityTime converts a Discord activity timestamp in Unix milliseconds to a time.
+// It returns fallback when Discord didn't provide one or the timestamp lies after fallback.
+func activityTime(timestamp int64, fallback time.Time) time.Time {
+	if timestamp == 0 {
+		return fallback
+	}
+	t := time.UnixMilli(timestamp)
+	if t.After(fallback) {
+		return fallback
+	}
+	return t
 }
 
 // presenceUpdate is called when a user's presence (status, game activity) changes
 func presenceUpdate(s *discordgo.Session, p *discordgo.PresenceUpdate) {
+	handlePresence(s, p, time.Time{})
+}
+
+// handlePresence processes a presence update. confirmedEmptySince is set when an update without
+// activities is processed again after emptyActivityGrace, to the time it first arrived.
+func handlePresence(s messageSender, p *discordgo.PresenceUpdate, confirmedEmptySince time.Time) {
+	// Partial presence payloads may not say whose presence it is
+	if p.User == nil || p.User.ID == "" {
+		return
+	}
+
 	// We only care about user presence updates, not bot presence updates
 	if p.User.Bot {
 		return
@@ -114,116 +764,689 @@ func presenceUpdate(s *discordgo.Session, p *discordgo.PresenceUpdate) {
 	data.mu.Lock()
 	defer data.mu.Unlock()
 
-	// Get or create user data
-	userData, ok := data.Users[userID]
-	if !ok {
-		userData = &UserGameData{
-			Sessions:    []GameSession{},
-			ActiveGames: make(map[string]time.Time),
+	// Users who opted out are never tracked
+	if data.optedOut[userID] {
+		return
+	}
+
+	// Get or create user data for the guild the presence was observed in
+	userData := data.getOrCreateUser(p.GuildID, userID)
+
+	now := time.Now()
+	activities := trackedActivities(p.Activities)
+
+	// Hold back updates that would end every session until the grace period shows they're real
+	if len(activities) == 0 && len(userData.ActiveGames) > 0 && emptyActivityGrace > 0 && p.Status != discordgo.StatusOffline {
+		if confirmedEmptySince.IsZero() {
+			if userData.emptySince.IsZero() {
+				since := now
+				userData.emptySince = since
+				time.AfterFunc(emptyActivityGrace, func() { handlePresence(s, p, since) })
+			}
+			return
 		}
-		data.Users[userID] = userData
+		if !userData.emptySince.Equal(confirmedEmptySince) {
+			return // Another update came in since, that one decides
+		}
+		now = confirmedEmptySince
+	} else if !confirmedEmptySince.IsZero() && !userData.emptySince.Equal(confirmedEmptySince) {
+		return
 	}
+	userData.emptySince = time.Time{}
 
-	// Check current activities
-	currentActivities := make(map[string]bool) // Map to quickly check active games from presence update
-	for _, activity := range p.Activities {
-		if activity.Type == discordgo.ActivityTypeGame {
-			currentActivities[activity.Name] = true
+	// Check current activities, both maps are keyed by activityMatchKey
+	currentActivities := make(map[string]bool)    // Map to quickly check active games from presence update
+	endedActivities := make(map[string]time.Time) // Games still listed but whose reported end time has passed
+	for _, activity := range activities {
+		key := activityMatchKey(activity)
+		if end := activity.Timestamps.EndTimestamp; end != 0 && !time.UnixMilli(end).After(now) {
+			endedActivities[key] = time.UnixMilli(end)
+			continue
 		}
+		currentActivities[key] = true
 	}
 
 	// Identify games that have stopped
 	for gameName, startTime := range userData.ActiveGames {
-		if !currentActivities[gameName] {
-			// Game has stopped
-			endTime := time.Now()
-			duration := endTime.Sub(startTime).Seconds()
-			session := GameSession{
-				GameName:  gameName,
-				StartTime: startTime,
-				EndTime:   endTime,
-				Duration:  duration,
+		if isVoiceSession(userData, gameName) {
+			continue // Ended by voice state updates, not presences
+		}
+		if isIgnored(gameName) {
+			// Ignored after this session started (e.g. restored from disk), drop it without recording
+			delete(userData.ActiveGames, gameName)
+			delete(userData.ActiveAppIDs, gameName)
+			delete(userData.ActiveIDs, gameName)
+			continue
+		}
+		key := activeGameKey(userData, gameName)
+		if !currentActivities[key] {
+			// Game has stopped, at the time Discord reported if it gave us one
+			endTime := now
+			if reportedEnd, ok := endedActivities[key]; ok && reportedEnd.After(startTime) {
+				endTime = reportedEnd
+			} else if userData.restoredGames[gameName] && data.lastSavedAt.After(startTime) {
+				// The session was restored from disk but the game is no longer running, so the
+				// user stopped while the bot was down. The last save is our best guess for when.
+				endTime = data.lastSavedAt
+			}
+			if capped := capSessionEnd(startTime, endTime); capped.Before(endTime) {
+				slog.Warn("Session exceeded MAX_SESSION_HOURS, recorded it at the limit", "user_id", userID, "username", username, "game", gameName, "start_time", startTime, "duration_seconds", endTime.Sub(startTime).Seconds())
+				endTime = capped
+			}
+			session := newGameSession(gameName, startTime, endTime)
+			session.ActivityType = userData.ActiveTypes[gameName]
+			session.GuildID = p.GuildID
+			session.ID = userData.ActiveIDs[gameName]
+			if session.ID == "" {
+				session.ID = newSessionID() // Started before sessions had IDs
 			}
-			userData.Sessions = append(userData.Sessions, session)
 			delete(userData.ActiveGames, gameName) // Remove from active games
-			log.Printf("User %s stopped playing %s. Duration: %.2f seconds", username, gameName, duration)
-			data.save() // Save data after each session ends
+			delete(userData.ActiveTypes, gameName)
+			delete(userData.ActiveAppIDs, gameName)
+			delete(userData.ActiveIDs, gameName)
+			if session.Duration <= 0 {
+				// Only possible if the system clock jumped back, or Discord's clock disagrees with ours
+				slog.Warn("Discarded session that ended before it started, check the system clock", "user_id", userID, "username", username, "game", gameName, "start_time", startTime, "end_time", endTime)
+				data.markDirtyLocked()
+				continue
+			}
+			if session.Duration < minSessionSeconds {
+				// Too short to be real play, most likely presence noise
+				slog.Debug("Discarded short session", "user_id", userID, "username", username, "game", gameName, "duration_seconds", session.Duration)
+				data.markDirtyLocked() // The active game is gone either way
+				continue
+			}
+			var previousRecord float64
+			if announceWebhookURL != "" {
+				previousRecord = serverLongestSessionLocked(p.GuildID)
+			}
+			userData.Sessions = append(userData.Sessions, session)
+			data.countSessionLocked(p.GuildID, userID, session)
+			recordSessionEnded(session.Duration)
+			if previousRecord > 0 && session.Duration > previousRecord {
+				announce(fmt.Sprintf("New server record! <@%s> played **%s** for %s in one session, beating the previous record of %s.", userID, sanitizeName(gameName), formatDuration(time.Duration(session.Duration)*time.Second), formatDuration(time.Duration(previousRecord)*time.Second)))
+			}
+			if mergeWindow > 0 {
+				if userData.recentlyStopped == nil {
+					userData.recentlyStopped = make(map[string]time.Time)
+				}
+				userData.recentlyStopped[gameName] = session.EndTime
+			}
+			slog.Info("Stopped playing", "user_id", userID, "username", username, "guild_id", p.GuildID, "game", gameName, "duration_seconds", session.Duration)
+			// Save the session, we already hold the lock
+			if err := data.insertSessionLocked(p.GuildID, userID, session); err != nil {
+				// The background saver writes it with everything else instead
+				slog.Error("Error saving session", "user_id", userID, "guild_id", p.GuildID, "game", gameName, "error", err)
+				data.markDirtyLocked()
+			}
+			data.trimSessionsLocked(p.GuildID, userData)
+
+			if reached, total, ok := checkMilestoneLocked(userData, session); ok {
+				data.markDirtyLocked()
+				if data.claimMilestoneLocked(p.GuildID, userID, gameName, reached) && !userData.MuteMilestones {
+					message := fmt.Sprintf("Congratulations! You've unlocked **%s** on **%s** with %s played.", reached.badge, sanitizeName(gameName), formatDuration(total))
+					// Send outside the lock, the DM is a network call
+					go func() {
+						if err := sendDM(s, userID, message); err != nil && !dmsClosed(err) {
+							slog.Warn("Could not send milestone DM", "user_id", userID, "username", username, "error", err)
+						}
+					}()
+				}
+				if reached.threshold >= announceMilestoneThreshold {
+					announce(fmt.Sprintf("<@%s> unlocked **%s** on **%s** with %s played!", userID, reached.badge, sanitizeName(gameName), formatDuration(total)))
+				}
+			}
+			if userData.NotifySessions {
+				message := sessionNotification(userData, session, gamePlayTime(gamePlayTimes(userData, session.EndTime), gameName))
+				go func() {
+					if err := sendDM(s, userID, message); err != nil && !dmsClosed(err) {
+						slog.Warn("Could not send session DM", "user_id", userID, "username", username, "error", err)
+					}
+				}()
+			}
+			if goal, total, ok := checkGameGoalLocked(userData, session); ok {
+				data.markDirtyLocked()
+				message := fmt.Sprintf("Goal reached! You've played **%s** for %s, reaching your goal of %s.", sanitizeName(gameName), formatDuration(total), formatDuration(goal))
+				go func() {
+					if err := sendDM(s, userID, message); err != nil {
+						slog.Warn("Could not send game goal DM", "user_id", userID, "username", username, "error", err)
+					}
+				}()
+			}
 		}
 	}
 
 	// Identify games that have started
-	for _, activity := range p.Activities {
-		if activity.Type == discordgo.ActivityTypeGame {
-			gameName := activity.Name
-			if _, isActive := userData.ActiveGames[gameName]; !isActive {
-				// Game has started
-				userData.ActiveGames[gameName] = time.Now()
-				log.Printf("User %s started playing %s", username, gameName)
+	activeKeys := make(map[string]bool)
+	for gameName := range userData.ActiveGames {
+		activeKeys[activeGameKey(userData, gameName)] = true
+	}
+	for _, activity := range activities {
+		key := activityMatchKey(activity)
+		if !currentActivities[key] || activeKeys[key] {
+			continue
+		}
+		activeKeys[key] = true
+
+		// Game has started, use the launch time Discord reports so time played before
+		// we saw the presence still counts
+		gameName := activity.Name
+		startTime := activityTime(activity.Timestamps.StartTimestamp, now)
+		sessionID := newSessionID()
+		resumedSession, resumed := resumeRecentSessionLocked(userData, gameName, startTime)
+		reopened := false
+		if !resumed {
+			resumedSession, reopened = reopenFinalizedSessionLocked(userData, gameName, startTime)
+		}
+		if resumed || reopened {
+			// The presence flickered or the bot restarted while the game kept running, continue the
+			// session that ended instead of starting a new one
+			startTime = resumedSession.StartTime
+			if resumedSession.ID != "" {
+				sessionID = resumedSession.ID
+			}
+			data.invalidateTotalsLocked(p.GuildID)
+			data.markDirtyLocked()
+		} else {
+			// A launch time from before the last session of the game ended would count that time twice
+			startTime = activeStart(userData, gameName, startTime)
+		}
+		userData.ActiveGames[gameName] = startTime
+		if userData.ActiveIDs == nil {
+			userData.ActiveIDs = make(map[string]string)
+		}
+		userData.ActiveIDs[gameName] = sessionID
+		if activityType := sessionActivityType(activity.Type); activityType != "" {
+			if userData.ActiveTypes == nil {
+				userData.ActiveTypes = make(map[string]string)
 			}
+			userData.ActiveTypes[gameName] = activityType
+		} else {
+			delete(userData.ActiveTypes, gameName)
+		}
+		if activity.ApplicationID != "" {
+			if userData.ActiveAppIDs == nil {
+				userData.ActiveAppIDs = make(map[string]string)
+			}
+			userData.ActiveAppIDs[gameName] = activity.ApplicationID
+		} else {
+			delete(userData.ActiveAppIDs, gameName)
+		}
+		switch {
+		case reopened:
+			slog.Info("Still playing after a restart, continued the finalized session", "user_id", userID, "username", username, "guild_id", p.GuildID, "game", gameName, "session_id", sessionID)
+		case resumed:
+			slog.Info("Resumed playing, merged with the previous session", "user_id", userID, "username", username, "guild_id", p.GuildID, "game", gameName)
+		default:
+			recordSessionStarted()
+			slog.Info("Started playing", "user_id", userID, "username", username, "guild_id", p.GuildID, "game", gameName)
+		}
+	}
+
+	// This update reflects the user's real activities, so restored sessions are reconciled now
+	userData.restoredGames = nil
+	if userData.FinalizedIDs != nil {
+		// Games finalized at shutdown that aren't running anymore stay as they were recorded
+		userData.FinalizedIDs = nil
+		data.markDirtyLocked()
+	}
+	// Games that stopped longer ago than the merge window can no longer be resumed
+	for gameName, stoppedAt := range userData.recentlyStopped {
+		if now.Sub(stoppedAt) > mergeWindow {
+			delete(userData.recentlyStopped, gameName)
 		}
 	}
 }
 
 // messageCreate is called when a new message is created in any channel the bot has access to
 func messageCreate(s *discordgo.Session, m *discordgo.MessageCreate) {
-	// Ignore messages from the bot itself
-	if m.Author.ID == s.State.User.ID {
+	// Ignore messages without an author, such as some system messages, and from the bot itself
+	if m.Author == nil || m.Author.ID == s.State.User.ID {
+		return
+	}
+	dispatchCommand(s, m)
+}
+
+// dispatchCommand runs the command in a message from a user, if it holds one
+func dispatchCommand(s messageSender, m *discordgo.MessageCreate) {
+	// A pending confirmation takes the user's next message in the channel
+	if handleClearAllConfirmation(s, m) {
 		return
 	}
 
 	// Check if the message is a command
-	if m.Content == "!mygames" {
-		userID := m.Author.ID
-		username := m.Author.Username
+	if !strings.HasPrefix(m.Content, commandPrefix) {
+		return
+	}
+	name, args := parseCommand(m.Content)
+	if name == "" {
+		return
+	}
 
-		data.mu.Lock()
-		defer data.mu.Unlock()
+	cmd, ok := findCommand(name)
+	if !ok {
+		handleUnknownCommand(s, m, name)
+		return
+	}
+	if onCommandCooldown(m.Author.ID, time.Now()) {
+		return // Ignore users spamming commands
+	}
+	if cmd.argsRequired && args == "" {
+		sendUsage(s, m.ChannelID, cmd.name)
+		return
+	}
+	if cmd.storesData && data.isOptedOut(m.Author.ID) {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you're opted out, so I don't store anything about you. Use `%soptin` to be tracked again first.", m.Author.Username, commandPrefix))
+		return
+	}
+	cmd.handler(s, m, args)
+}
 
-		userData, ok := data.Users[userID]
-		if !ok || len(userData.Sessions) == 0 {
-			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Hey %s, I haven't tracked any games for you yet!", username))
-			return
+// handleMyGames implements the !mygames command: total play time per game for the user,
+// optionally limited to one activity type
+func handleMyGames(s messageSender, m *discordgo.MessageCreate, args string) {
+	userID := m.Author.ID
+	username := m.Author.Username
+
+	typeName := strings.ToLower(strings.TrimSpace(args))
+	if _, ok := activityTypeByName(typeName); typeName != "" && !ok {
+		sendUsage(s, m.ChannelID, "mygames")
+		return
+	}
+
+	if err := sendPaginated(s, m.ChannelID, userID, myGamesPages(m.GuildID, userID, username, typeName, myGamesPageSize)); err != nil {
+		slog.Error("Error sending games", "username", username, "error", err)
+	}
+}
+
+// myGamesSummary builds the per-game play time summary shown by !mygames and /mygames
+func myGamesSummary(guildID, userID, username, typeName string) string {
+	return myGamesPages(guildID, userID, username, typeName, 0)[0]
+}
+
+// myGamesPages builds the per-game play time summary split into pages of pageSize games, each
+// with the totals at the bottom. A pageSize of 0 puts every game on one page.
+func myGamesPages(guildID, userID, username, typeName string, pageSize int) []string {
+	userData, ok := data.snapshotUser(guildID, userID)
+	format := formatDuration
+	if ok {
+		format = userDurationFormat(userData)
+	}
+	if ok && typeName != "" {
+		userData = filterByActivityType(userData, typeName)
+	}
+	if !ok || len(userData.Sessions) == 0 && len(userData.TrimmedTotals) == 0 {
+		return []string{fmt.Sprintf("Hey %s, I haven't tracked any games for you yet!", username)}
+	}
+
+	now := time.Now()
+	counts := gameSessionCounts(userData)
+	var total time.Duration
+	sessionCount := 0
+	var lines []string
+	for _, game := range rankGames(gamePlayTimes(userData, now)) {
+		sessions := "sessions"
+		if counts[game.name] == 1 {
+			sessions = "session"
 		}
+		lines = append(lines, fmt.Sprintf("- **%s**: %s (%d %s)\n", sanitizeName(game.name), format(game.duration), counts[game.name], sessions))
+		total += game.duration
+		sessionCount += counts[game.name]
+	}
 
-		// Calculate total play time per game
-		gamePlayTimes := make(map[string]time.Duration)
-		for _, session := range userData.Sessions {
-			gamePlayTimes[session.GameName] += time.Duration(session.Duration) * time.Second
+	// Average over the days since the first session, a history shorter than a day counts as one day
+	days := now.Sub(earliestActivity(userData, now)).Hours() / 24
+	if days < 1 {
+		days = 1
+	}
+	footer := fmt.Sprintf("**Total play time**: %s\n", format(total))
+	if unique := uniquePlayTime(userData, now); total-unique >= time.Second {
+		footer += fmt.Sprintf("**Total unique play time**: %s (games played at the same time counted once)\n", format(unique))
+	}
+	footer += fmt.Sprintf("**Total sessions**: %d\n", sessionCount)
+	footer += fmt.Sprintf("**Average per day**: %s\n", format(time.Duration(float64(total)/days)))
+
+	if pageSize <= 0 {
+		pageSize = len(lines)
+	}
+	pageCount := (len(lines) + pageSize - 1) / pageSize
+	pages := make([]string, 0, pageCount)
+	for start := 0; start < len(lines); start += pageSize {
+		end := min(start+pageSize, len(lines))
+		page := fmt.Sprintf("Here are your tracked game play times, %s:\n", username)
+		page += strings.Join(lines[start:end], "") + footer
+		if pageCount > 1 {
+			page += fmt.Sprintf("Page %d/%d", len(pages)+1, pageCount)
 		}
+		pages = append(pages, page)
+	}
+	return pages
+}
 
-		// Add currently active games to the total
-		for gameName, startTime := range userData.ActiveGames {
-			gamePlayTimes[gameName] += time.Since(startTime)
+// earliestActivity returns when the user's oldest session or active game started, or fallback if
+// they have neither
+func earliestActivity(userData *UserGameData, fallback time.Time) time.Time {
+	first := fallback
+	for _, session := range userData.Sessions {
+		if first.IsZero() || session.StartTime.Before(first) {
+			first = session.StartTime
+		}
+	}
+	for _, startTime := range userData.ActiveGames {
+		if first.IsZero() || startTime.Before(first) {
+			first = startTime
 		}
+	}
+	return first
+}
 
-		response := fmt.Sprintf("Here are your tracked game play times, %s:\n", username)
-		for gameName, totalDuration := range gamePlayTimes {
-			response += fmt.Sprintf("- **%s**: %s\n", gameName, formatDuration(totalDuration))
+// gameSessionCounts counts a user's sessions per game, an active game counting as one session in progress
+func gameSessionCounts(userData *UserGameData) map[string]int {
+	counts := make(map[string]int)
+	for _, session := range userData.Sessions {
+		counts[session.GameName]++
+	}
+	for gameName, count := range userData.TrimmedCounts {
+		counts[gameName] += count
+	}
+	for gameName := range userData.ActiveGames {
+		counts[gameName]++
+	}
+	return counts
+}
+
+// gamePlayTimes calculates a user's total play time per game, including active games up to now
+func gamePlayTimes(userData *UserGameData, now time.Time) map[string]time.Duration {
+	playTimes := make(map[string]time.Duration)
+	for _, session := range userData.Sessions {
+		playTimes[session.GameName] += time.Duration(session.Duration) * time.Second
+	}
+	for gameName, seconds := range userData.TrimmedTotals {
+		playTimes[gameName] += time.Duration(seconds) * time.Second
+	}
+
+	// Add currently active games to the total
+	for gameName, startTime := range userData.ActiveGames {
+		end := capSessionEnd(startTime, now)
+		if start := activeStart(userData, gameName, startTime); end.After(start) {
+			playTimes[gameName] += end.Sub(start)
 		}
+	}
+	return playTimes
+}
 
-		s.ChannelMessageSend(m.ChannelID, response)
-	} else if m.Content == "!cleargames" {
-		userID := m.Author.ID
-		username := m.Author.Username
+// capSessionEnd returns when a session that started at startTime stops counting: endTime, or earlier
+// if that would make it longer than maxSessionDuration
+func capSessionEnd(startTime, endTime time.Time) time.Time {
+	if maxSessionDuration > 0 && endTime.Sub(startTime) > maxSessionDuration {
+		return startTime.Add(maxSessionDuration)
+	}
+	return endTime
+}
 
-		data.mu.Lock()
-		defer data.mu.Unlock()
+// activeStart returns when the time of an active game starts counting: when it started, or the end
+// of a recorded session of the same game that overlaps it, so that time isn't counted twice
+func activeStart(userData *UserGameData, gameName string, startTime time.Time) time.Time {
+	start := startTime
+	for _, session := range userData.Sessions {
+		if session.GameName == gameName && session.EndTime.After(start) {
+			start = session.EndTime
+		}
+	}
+	return start
+}
 
-		if _, ok := data.Users[userID]; ok {
-			data.Users[userID] = &UserGameData{
-				Sessions:    []GameSession{},
-				ActiveGames: make(map[string]time.Time),
+// uniquePlayTime calculates how much wall-clock time a user spent playing, including active games
+// up to now. Unlike summing gamePlayTimes, time spent playing several games at once counts once.
+func uniquePlayTime(userData *UserGameData, now time.Time) time.Duration {
+	type interval struct{ start, end time.Time }
+	intervals := make([]interval, 0, len(userData.Sessions)+len(userData.ActiveGames))
+	for _, session := range userData.Sessions {
+		intervals = append(intervals, interval{session.StartTime, session.EndTime})
+	}
+	for _, startTime := range userData.ActiveGames {
+		intervals = append(intervals, interval{startTime, capSessionEnd(startTime, now)})
+	}
+	sort.Slice(intervals, func(i, j int) bool { return intervals[i].start.Before(intervals[j].start) })
+
+	// Walk the intervals by start time, merging each one into the current run while they overlap
+	var total time.Duration
+	var runStart, runEnd time.Time
+	for _, iv := range intervals {
+		if !iv.end.After(iv.start) {
+			continue
+		}
+		if runEnd.IsZero() || iv.start.After(runEnd) {
+			if runEnd.After(runStart) {
+				total += runEnd.Sub(runStart)
 			}
-			data.save()
-			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Hey %s, your game tracking data has been cleared!", username))
-		} else {
-			s.ChannelMessageSend(m.ChannelID, fmt.Sprintf("Hey %s, you don't have any game data to clear!", username))
+			runStart, runEnd = iv.start, iv.end
+		} else if iv.end.After(runEnd) {
+			runEnd = iv.end
 		}
 	}
+	if runEnd.After(runStart) {
+		total += runEnd.Sub(runStart)
+	}
+	return total
+}
+
+// handleClearGames implements the !cleargames command: wipe all of the user's tracked data in this guild.
+// Games still being played keep being tracked, but only from the moment of the clear, so no time
+// from before it survives and no time after it is lost.
+func handleClearGames(s messageSender, m *discordgo.MessageCreate, args string) {
+	userID := m.Author.ID
+	username := m.Author.Username
+
+	now := time.Now()
+	data.mu.Lock()
+	oldData, ok := data.Guilds[m.GuildID][userID]
+	playing := 0
+	if ok {
+		playing = len(oldData.ActiveGames)
+		data.Guilds[m.GuildID][userID] = clearedUserData(oldData, now)
+		data.invalidateTotalsLocked(m.GuildID)
+		if err := data.saveLocked(); err != nil {
+			slog.Error("Error saving after clearing games", "username", username, "error", err)
+		}
+	}
+	data.mu.Unlock()
+	if ok {
+		if _, err := deleteArchivedSessions(func(guildID, archivedUserID string, session GameSession) bool {
+			return guildID != m.GuildID || archivedUserID != userID
+		}); err != nil {
+			slog.Error("Error clearing archived games", "username", username, "error", err)
+		}
+	}
+
+	if ok {
+		response := fmt.Sprintf("Hey %s, your game tracking data has been cleared!", username)
+		if playing > 0 {
+			response += " What you're playing right now is tracked from this moment on."
+		}
+		sendChunked(s, m.ChannelID, response)
+	} else {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you don't have any game data to clear!", username))
+	}
+}
+
+// clearedUserData returns a copy of a user's data with their play history cleared. Their settings
+// are kept, and so are the games they are playing right now, which restart at now so no time from
+// before the clear survives.
+func clearedUserData(oldData *UserGameData, now time.Time) *UserGameData {
+	cleared := newUserGameData()
+	cleared.FirstSeen = oldData.FirstSeen
+	cleared.Timezone = oldData.Timezone
+	cleared.DurationFormat = oldData.DurationFormat
+	cleared.DailyBudget = oldData.DailyBudget
+	cleared.BudgetWarnDay = oldData.BudgetWarnDay
+	cleared.BudgetWarnLevel = oldData.BudgetWarnLevel
+	cleared.WeeklyGoal = oldData.WeeklyGoal
+	cleared.BreakReminder = oldData.BreakReminder
+	cleared.NotifySessions = oldData.NotifySessions
+	cleared.MuteBudgetWarnings = oldData.MuteBudgetWarnings
+	cleared.MuteMilestones = oldData.MuteMilestones
+	cleared.QuietHours = oldData.QuietHours
+	cleared.WrapupWeek = oldData.WrapupWeek
+	if len(oldData.GameGoals) > 0 {
+		cleared.GameGoals = make(map[string]*GameGoal, len(oldData.GameGoals))
+		for gameName, goal := range oldData.GameGoals {
+			// The play time counts from zero again, so the goal can be reached again
+			cleared.GameGoals[gameName] = &GameGoal{Target: goal.Target}
+		}
+	}
+	// A voice session restarts like a game, but the user is still in the channel
+	cleared.voiceChannel = oldData.voiceChannel
+	for gameName := range oldData.ActiveGames {
+		cleared.ActiveGames[gameName] = now
+	}
+	if len(oldData.ActiveTypes) > 0 {
+		cleared.ActiveTypes = make(map[string]string, len(oldData.ActiveTypes))
+		for gameName, activityType := range oldData.ActiveTypes {
+			cleared.ActiveTypes[gameName] = activityType
+		}
+	}
+	if len(oldData.ActiveAppIDs) > 0 {
+		cleared.ActiveAppIDs = make(map[string]string, len(oldData.ActiveAppIDs))
+		for gameName, appID := range oldData.ActiveAppIDs {
+			cleared.ActiveAppIDs[gameName] = appID
+		}
+	}
+	// Active games restart, so they get new session IDs
+	if len(oldData.ActiveGames) > 0 {
+		cleared.ActiveIDs = make(map[string]string, len(oldData.ActiveGames))
+		for gameName := range oldData.ActiveGames {
+			cleared.ActiveIDs[gameName] = newSessionID()
+		}
+	}
+	return cleared
+}
+
+// handleResetGame implements the !resetgame command: delete the user's history of one game in this guild
+func handleResetGame(s messageSender, m *discordgo.MessageCreate, args string) {
+	userID := m.Author.ID
+	username := m.Author.Username
+
+	query := strings.TrimSpace(args)
+	if query == "" {
+		sendUsage(s, m.ChannelID, "resetgame")
+		return
+	}
+	if snapshot, ok := data.snapshotUser(m.GuildID, userID); ok {
+		if query, ok = resolveGame(s, m, snapshot, query); !ok {
+			return
+		}
+	}
+
+	data.mu.Lock()
+	removed := 0
+	wasActive := false
+	hadTrimmed := false // Only trimmed sessions may be left of the game
+	userData, known := data.Guilds[m.GuildID][userID]
+	if known {
+		kept := make([]GameSession, 0, len(userData.Sessions))
+		for _, session := range userData.Sessions {
+			if strings.EqualFold(session.GameName, query) {
+				removed++
+				continue
+			}
+			kept = append(kept, session)
+		}
+		userData.Sessions = kept
+		data.invalidateTotalsLocked(m.GuildID)
+
+		for gameName := range userData.ActiveGames {
+			if strings.EqualFold(gameName, query) {
+				delete(userData.ActiveGames, gameName)
+				delete(userData.ActiveTypes, gameName)
+				delete(userData.ActiveAppIDs, gameName)
+				delete(userData.ActiveIDs, gameName)
+				wasActive = true
+			}
+		}
+		for gameName := range userData.NotifiedMilestones {
+			if strings.EqualFold(gameName, query) {
+				delete(userData.NotifiedMilestones, gameName)
+			}
+		}
+		for gameName := range userData.TrimmedTotals {
+			if strings.EqualFold(gameName, query) {
+				delete(userData.TrimmedTotals, gameName)
+				hadTrimmed = true
+			}
+		}
+		for gameName := range userData.TrimmedCounts {
+			if strings.EqualFold(gameName, query) {
+				delete(userData.TrimmedCounts, gameName)
+			}
+		}
+		for gameName, goal := range userData.GameGoals {
+			if strings.EqualFold(gameName, query) {
+				goal.Reached = false // The play time counts from zero again
+			}
+		}
+
+		if removed > 0 || wasActive || hadTrimmed {
+			if err := data.saveLocked(); err != nil {
+				slog.Error("Error saving after resetting a game", "game", query, "username", username, "error", err)
+			}
+		}
+	}
+	data.mu.Unlock()
+	if known {
+		// Their play time was part of TrimmedTotals, which is already gone
+		archived, err := deleteArchivedSessions(func(guildID, archivedUserID string, session GameSession) bool {
+			return guildID != m.GuildID || archivedUserID != userID || !strings.EqualFold(session.GameName, query)
+		})
+		if err != nil {
+			slog.Error("Error resetting archived game", "game", query, "username", username, "error", err)
+		}
+		removed += archived.count()
+	}
+
+	if removed == 0 && !wasActive && !hadTrimmed {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, no sessions found for **%s**.", username, sanitizeName(query)))
+		return
+	}
+	sessions := "sessions"
+	if removed == 1 {
+		sessions = "session"
+	}
+	response := fmt.Sprintf("Hey %s, I removed %d %s of **%s**.", username, removed, sessions, sanitizeName(query))
+	if removed == 0 && hadTrimmed {
+		response = fmt.Sprintf("Hey %s, I reset your play time of **%s**.", username, sanitizeName(query))
+	}
+	if wasActive {
+		response += " Your session in progress was dropped too."
+	}
+	sendChunked(s, m.ChannelID, response)
+}
+
+// sendDM sends a direct message to a user, opening the DM channel if needed
+func sendDM(s messageSender, userID, content string) error {
+	channel, err := s.UserChannelCreate(userID)
+	if err != nil {
+		return fmt.Errorf("error creating DM channel: %w", err)
+	}
+	if _, err := s.ChannelMessageSend(channel.ID, content); err != nil {
+		return fmt.Errorf("error sending DM: %w", err)
+	}
+	return nil
+}
+
+// dmsClosed reports whether sending a DM failed because the user doesn't accept DMs from the bot
+func dmsClosed(err error) bool {
+	var restErr *discordgo.RESTError
+	return errors.As(err, &restErr) && restErr.Response != nil && restErr.Response.StatusCode == http.StatusForbidden
 }
 
 // formatDuration converts a time.Duration into a human-readable string
 func formatDuration(d time.Duration) string {
+	// Clock adjustments can produce negative durations, show those like zero and sub-second ones
+	if d < time.Second {
+		return "0s"
+	}
+
 	days := int(d.Hours() / 24)
 	hours := int(d.Hours()) % 24
 	minutes := int(d.Minutes()) % 60
@@ -259,60 +1482,404 @@ func joinStrings(s []string, sep string) string {
 	return result
 }
 
-// save persists the DataStore to a JSON file
-func (ds *DataStore) save() error {
+// newUserGameData creates empty game data for a user
+func newUserGameData() *UserGameData {
+	return &UserGameData{
+		Sessions:    []GameSession{},
+		ActiveGames: make(map[string]time.Time),
+	}
+}
+
+// isOptedOut reports whether a user asked not to be tracked
+func (ds *DataStore) isOptedOut(userID string) bool {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
+	return ds.optedOut[userID]
+}
 
-	// Create a copy of the data to avoid issues with `ActiveGames` field during marshaling
-	// as `ActiveGames` is marked with `json:"-"`
-	tempUsers := make(map[string]*UserGameData)
-	for userID, userData := range ds.Users {
-		tempUsers[userID] = &UserGameData{
-			Sessions: userData.Sessions,
-			// ActiveGames is not saved, it's reconstructed on load or filled during runtime
+// getOrCreateUser returns a user's data in a guild, creating it if needed. The caller must hold ds.mu.
+func (ds *DataStore) getOrCreateUser(guildID, userID string) *UserGameData {
+	users, ok := ds.Guilds[guildID]
+	if !ok {
+		users = make(map[string]*UserGameData)
+		ds.Guilds[guildID] = users
+	}
+	userData, ok := users[userID]
+	if !ok {
+		userData = newUserGameData()
+		userData.FirstSeen = time.Now()
+		users[userID] = userData
+	}
+	return userData
+}
+
+// snapshotUser returns a deep copy of a user's data in a guild, taken under the lock. Commands
+// work on the copy so formatting replies and sending them to Discord never blocks presence
+// processing. The copy only holds the persisted fields and must not be stored back.
+func (ds *DataStore) snapshotUser(guildID, userID string) (*UserGameData, bool) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	userData, ok := ds.Guilds[guildID][userID]
+	if !ok {
+		return nil, false
+	}
+
+	snapshot := &UserGameData{
+		Sessions:        append([]GameSession(nil), userData.Sessions...),
+		ActiveGames:     make(map[string]time.Time, len(userData.ActiveGames)),
+		DailyBudget:     userData.DailyBudget,
+		BudgetWarnDay:   userData.BudgetWarnDay,
+		BudgetWarnLevel: userData.BudgetWarnLevel,
+		WeeklyGoal:      userData.WeeklyGoal,
+		Timezone:        userData.Timezone,
+		WrapupWeek:      userData.WrapupWeek,
+		FirstSeen:       userData.FirstSeen,
+		BreakReminder:   userData.BreakReminder,
+		NotifySessions:  userData.NotifySessions,
+		DurationFormat:  userData.DurationFormat,
+
+		MuteBudgetWarnings: userData.MuteBudgetWarnings,
+		MuteMilestones:     userData.MuteMilestones,
+		QuietHours:         userData.QuietHours,
+	}
+	for gameName, startTime := range userData.ActiveGames {
+		snapshot.ActiveGames[gameName] = startTime
+	}
+	if userData.ActiveTypes != nil {
+		snapshot.ActiveTypes = make(map[string]string, len(userData.ActiveTypes))
+		for gameName, activityType := range userData.ActiveTypes {
+			snapshot.ActiveTypes[gameName] = activityType
+		}
+	}
+	if userData.ActiveAppIDs != nil {
+		snapshot.ActiveAppIDs = make(map[string]string, len(userData.ActiveAppIDs))
+		for gameName, appID := range userData.ActiveAppIDs {
+			snapshot.ActiveAppIDs[gameName] = appID
+		}
+	}
+	if userData.ActiveIDs != nil {
+		snapshot.ActiveIDs = make(map[string]string, len(userData.ActiveIDs))
+		for gameName, sessionID := range userData.ActiveIDs {
+			snapshot.ActiveIDs[gameName] = sessionID
+		}
+	}
+	if userData.FinalizedIDs != nil {
+		snapshot.FinalizedIDs = make(map[string]string, len(userData.FinalizedIDs))
+		for gameName, sessionID := range userData.FinalizedIDs {
+			snapshot.FinalizedIDs[gameName] = sessionID
 		}
 	}
+	if userData.RemindedSessions != nil {
+		snapshot.RemindedSessions = make(map[string]time.Time, len(userData.RemindedSessions))
+		for gameName, startTime := range userData.RemindedSessions {
+			snapshot.RemindedSessions[gameName] = startTime
+		}
+	}
+	if userData.GameGoals != nil {
+		snapshot.GameGoals = make(map[string]*GameGoal, len(userData.GameGoals))
+		for gameName, goal := range userData.GameGoals {
+			goalCopy := *goal
+			snapshot.GameGoals[gameName] = &goalCopy
+		}
+	}
+	if userData.TrimmedTotals != nil {
+		snapshot.TrimmedTotals = make(map[string]float64, len(userData.TrimmedTotals))
+		for gameName, seconds := range userData.TrimmedTotals {
+			snapshot.TrimmedTotals[gameName] = seconds
+		}
+	}
+	if userData.TrimmedCounts != nil {
+		snapshot.TrimmedCounts = make(map[string]int, len(userData.TrimmedCounts))
+		for gameName, count := range userData.TrimmedCounts {
+			snapshot.TrimmedCounts[gameName] = count
+		}
+	}
+	if userData.NotifiedMilestones != nil {
+		snapshot.NotifiedMilestones = make(map[string]float64, len(userData.NotifiedMilestones))
+		for gameName, threshold := range userData.NotifiedMilestones {
+			snapshot.NotifiedMilestones[gameName] = threshold
+		}
+	}
+	return snapshot, true
+}
 
-	dataBytes, err := json.MarshalIndent(tempUsers, "", "  ")
-	if err != nil {
-		return fmt.Errorf("error marshaling data: %w", err)
+// finalizeActiveSessions ends every active session at endTime, appending it to the user's
+// completed sessions and clearing the active games. It is used when the bot shuts down. The
+// session IDs are remembered in FinalizedIDs so games still running after a restart continue them.
+func (ds *DataStore) finalizeActiveSessions(endTime time.Time) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	for guildID, users := range ds.Guilds {
+		for userID, userData := range users {
+			for gameName, startTime := range userData.ActiveGames {
+				sessionEnd := endTime
+				if capped := capSessionEnd(startTime, endTime); capped.Before(endTime) {
+					slog.Warn("Active session exceeded MAX_SESSION_HOURS, recorded it at the limit", "user_id", userID, "guild_id", guildID, "game", gameName, "start_time", startTime)
+					sessionEnd = capped
+				}
+				session := newGameSession(gameName, startTime, sessionEnd)
+				if session.Duration <= 0 {
+					slog.Warn("Discarded active session that ended before it started, check the system clock", "user_id", userID, "guild_id", guildID, "game", gameName, "start_time", startTime)
+					continue
+				}
+				session.ActivityType = userData.ActiveTypes[gameName]
+				if guildID != legacyGuildID {
+					session.GuildID = guildID
+				}
+				session.ID = userData.ActiveIDs[gameName]
+				if session.ID == "" {
+					session.ID = newSessionID()
+				}
+				if userData.FinalizedIDs == nil {
+					userData.FinalizedIDs = make(map[string]string)
+				}
+				userData.FinalizedIDs[gameName] = session.ID
+				userData.Sessions = append(userData.Sessions, session)
+				ds.countSessionLocked(guildID, userID, session)
+				slog.Info("Finalized active session", "user_id", userID, "guild_id", guildID, "game", gameName, "duration_seconds", session.Duration)
+			}
+			userData.ActiveGames = make(map[string]time.Time)
+			userData.ActiveTypes = nil
+			userData.ActiveAppIDs = nil
+			userData.ActiveIDs = nil
+			userData.restoredGames = nil
+		}
 	}
+}
 
-	err = ioutil.WriteFile(dataFilePath, dataBytes, 0644)
-	if err != nil {
-		return fmt.Errorf("error writing data to file: %w", err)
+// save persists the DataStore using the configured storage backend
+func (ds *DataStore) save() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	return ds.saveLocked()
+}
+
+// saveLocked persists the DataStore using the configured storage backend. The caller must hold ds.mu,
+// which lets code that is already modifying the store save without deadlocking.
+func (ds *DataStore) saveLocked() error {
+	// Create a copy of the data containing only the persisted fields
+	tempData := persistedData{Version: currentSchemaVersion, Guilds: make(map[string]map[string]*UserGameData)}
+	for guildID, users := range ds.Guilds {
+		tempUsers := make(map[string]*UserGameData)
+		for userID, userData := range users {
+			tempUsers[userID] = &UserGameData{
+				Sessions:           userData.Sessions,
+				ActiveGames:        userData.ActiveGames,
+				ActiveTypes:        userData.ActiveTypes,
+				ActiveAppIDs:       userData.ActiveAppIDs,
+				ActiveIDs:          userData.ActiveIDs,
+				FinalizedIDs:       userData.FinalizedIDs,
+				DailyBudget:        userData.DailyBudget,
+				BudgetWarnDay:      userData.BudgetWarnDay,
+				BudgetWarnLevel:    userData.BudgetWarnLevel,
+				WeeklyGoal:         userData.WeeklyGoal,
+				NotifiedMilestones: userData.NotifiedMilestones,
+				Timezone:           userData.Timezone,
+				WrapupWeek:         userData.WrapupWeek,
+				FirstSeen:          userData.FirstSeen,
+				GameGoals:          userData.GameGoals,
+				BreakReminder:      userData.BreakReminder,
+				RemindedSessions:   userData.RemindedSessions,
+				NotifySessions:     userData.NotifySessions,
+				MuteBudgetWarnings: userData.MuteBudgetWarnings,
+				MuteMilestones:     userData.MuteMilestones,
+				QuietHours:         userData.QuietHours,
+				DurationFormat:     userData.DurationFormat,
+				TrimmedTotals:      userData.TrimmedTotals,
+				TrimmedCounts:      userData.TrimmedCounts,
+			}
+		}
+		tempData.Guilds[guildID] = tempUsers
+	}
+	for userID := range ds.optedOut {
+		tempData.OptedOut = append(tempData.OptedOut, userID)
 	}
-	log.Println("Game data saved successfully.")
+	sort.Strings(tempData.OptedOut) // Keep the file stable between saves
+
+	if err := ds.backend.save(tempData); err != nil {
+		// Keep the changes pending so the background saver tries again
+		ds.dirty = true
+		ds.saveFailures++
+		if ds.saveFailures == saveFailureAlertThreshold && ds.alert != nil {
+			go ds.alert(fmt.Sprintf("Saving game data has failed %d times in a row, changes are only kept in memory: %v", ds.saveFailures, err))
+		}
+		return err
+	}
+	if ds.saveFailures >= saveFailureAlertThreshold && ds.alert != nil {
+		go ds.alert("Saving game data works again.")
+	}
+	ds.saveFailures = 0
+	ds.dirty = false
+	slog.Info("Game data saved")
 	return nil
 }
 
-// load loads the DataStore from a JSON file
-func (ds *DataStore) load() error {
+// markDirtyLocked records that the store has changes the background saver should flush.
+// The caller must hold ds.mu.
+func (ds *DataStore) markDirtyLocked() {
+	ds.dirty = true
+}
+
+// flush saves the store if it has unsaved changes
+func (ds *DataStore) flush() error {
 	ds.mu.Lock()
 	defer ds.mu.Unlock()
 
-	dataBytes, err := ioutil.ReadFile(dataFilePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			log.Printf("Data file %s does not exist. Starting with empty data.", dataFilePath)
-			return nil // Not an error if file doesn't exist yet
+	if !ds.dirty {
+		return nil
+	}
+	return ds.saveLocked()
+}
+
+// runFlusher flushes unsaved changes every interval until stop is closed, so busy servers
+// don't rewrite the data file on every single session end. While saves keep failing, for example
+// because the disk is full, it backs off exponentially up to maxSaveBackoff.
+func (ds *DataStore) runFlusher(interval time.Duration, stop <-chan struct{}) {
+	delay := interval
+	for {
+		timer := time.NewTimer(delay)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+			delay = interval
+			if err := ds.flush(); err != nil {
+				ds.mu.Lock()
+				failures := ds.saveFailures
+				ds.mu.Unlock()
+				delay = saveBackoff(interval, failures)
+				slog.Error("Error saving game data", "error", err, "failures", failures, "retry_in", delay.String())
+			}
+		}
+	}
+}
+
+// runAutosave keeps the stored data current every interval until stop is closed. Changes are
+// saved, and while games are in progress without other changes only the save time is recorded
+// where the backend supports it, so the progress of active games survives a crash without
+// rewriting every table. While saves are failing the flusher's backoff decides when to try
+// again, so the two don't hammer a broken backend together.
+func (ds *DataStore) runAutosave(interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			ds.mu.Lock()
+			if err := ds.autosaveLocked(); err != nil {
+				slog.Error("Error auto-saving game data", "error", err)
+			}
+			ds.mu.Unlock()
 		}
-		return fmt.Errorf("error reading data file: %w", err)
 	}
+}
 
-	tempUsers := make(map[string]*UserGameData)
-	err = json.Unmarshal(dataBytes, &tempUsers)
+// autosaveLocked does one autosave, see runAutosave. The caller must hold ds.mu.
+func (ds *DataStore) autosaveLocked() error {
+	if ds.saveFailures > 0 {
+		return nil
+	}
+	if ds.dirty {
+		return ds.saveLocked()
+	}
+	if !ds.hasActiveGamesLocked() {
+		return nil
+	}
+	if recorder, ok := ds.backend.(saveTimeRecorder); ok {
+		return recorder.recordSaveTime()
+	}
+	return ds.saveLocked()
+}
+
+// hasActiveGamesLocked reports whether anyone is playing a tracked game. The caller must hold ds.mu.
+func (ds *DataStore) hasActiveGamesLocked() bool {
+	for _, users := range ds.Guilds {
+		for _, userData := range users {
+			if len(userData.ActiveGames) > 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// saveBackoff returns how long to wait before retrying after failures failed saves in a row
+func saveBackoff(interval time.Duration, failures int) time.Duration {
+	delay := interval
+	for i := 1; i < failures && delay < maxSaveBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxSaveBackoff)
+}
+
+// insertSessionLocked persists a session that just finished for a user. Backends that can store a
+// single session do so right away, for others the store is marked dirty and flushed by the
+// background saver. The caller must hold ds.mu.
+func (ds *DataStore) insertSessionLocked(guildID, userID string, session GameSession) error {
+	inserter, ok := ds.backend.(sessionInserter)
+	if !ok {
+		ds.markDirtyLocked()
+		return nil
+	}
+	return inserter.insertSession(guildID, userID, session)
+}
+
+// load loads the DataStore using the configured storage backend
+func (ds *DataStore) load() error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	tempData, savedAt, err := ds.backend.load()
 	if err != nil {
-		return fmt.Errorf("error unmarshaling data: %w", err)
+		return err
+	}
+	ds.lastSavedAt = savedAt
+
+	// Restored active games are treated as still running until the next presence update says otherwise
+	for guildID, users := range tempData.Guilds {
+		// A hand-edited or corrupt file can hold null for a guild or user, skip those instead of crashing
+		if users == nil {
+			slog.Warn("Skipped guild without data", "guild_id", guildID)
+			continue
+		}
+		for userID, userData := range users {
+			if userData == nil {
+				slog.Warn("Skipped user without data", "user_id", userID, "guild_id", guildID)
+				delete(users, userID)
+				continue
+			}
+			if userData.Sessions == nil {
+				userData.Sessions = []GameSession{}
+			}
+			if userData.ActiveGames == nil {
+				userData.ActiveGames = make(map[string]time.Time)
+			}
+			// Data from before first-seen times were recorded starts at the oldest activity
+			if userData.FirstSeen.IsZero() {
+				userData.FirstSeen = earliestActivity(userData, time.Time{})
+			}
+			if len(userData.ActiveGames) > 0 {
+				userData.restoredGames = make(map[string]bool)
+				for gameName := range userData.ActiveGames {
+					userData.restoredGames[gameName] = true
+				}
+				slog.Info("Restored active sessions", "user_id", userID, "guild_id", guildID, "sessions", len(userData.ActiveGames))
+			}
+		}
+		ds.Guilds[guildID] = users
 	}
 
-	// Re-initialize ActiveGames map for each user after loading
-	for userID, userData := range tempUsers {
-		userData.ActiveGames = make(map[string]time.Time)
-		ds.Users[userID] = userData
+	ds.optedOut = make(map[string]bool)
+	for _, userID := range tempData.OptedOut {
+		ds.optedOut[userID] = true
 	}
+	ds.rebuildTotalsLocked()
 
-	log.Println("Game data loaded successfully.")
+	slog.Info("Game data loaded", "guilds", len(ds.Guilds), "opted_out", len(ds.optedOut))
 	return nil
 }
@@ -0,0 +1,855 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// TestPlaySessionScenario plays a game from start to stop through presence updates and checks that
+// !mygames reports it, end to end through the command dispatcher
+func TestPlaySessionScenario(t *testing.T) {
+	store := newTestStore(t)
+	setForTest(t, &commandCooldown, 0)
+	setForTest(t, &emptyActivityGrace, 0)
+	setForTest(t, &mergeWindow, 0)
+	s := newFakeSession()
+
+	startedAt := time.Now().Add(-90 * time.Minute)
+	handlePresence(s, testPresence("1", startedAt, "Minecraft"), time.Time{})
+
+	store.mu.Lock()
+	active, ok := store.Guilds["guild"]["1"].ActiveGames["Minecraft"]
+	store.mu.Unlock()
+	if !ok {
+		t.Fatal("Minecraft isn't active after the presence update")
+	}
+	if !active.Equal(time.UnixMilli(startedAt.UnixMilli())) {
+		t.Errorf("active since %v, want the launch time %v", active, startedAt)
+	}
+
+	handlePresence(s, testPresence("1", time.Time{}), time.Time{})
+
+	userData, _ := store.snapshotUser("guild", "1")
+	if len(userData.ActiveGames) != 0 {
+		t.Errorf("active games after stopping = %v, want none", userData.ActiveGames)
+	}
+	if len(userData.Sessions) != 1 || userData.Sessions[0].GameName != "Minecraft" {
+		t.Fatalf("sessions after stopping = %+v, want one of Minecraft", userData.Sessions)
+	}
+
+	dispatchCommand(s, testMessage("1", "!mygames"))
+	reply := s.lastMessage(t, "channel")
+	for _, want := range []string{"**Minecraft**: 1h 30m", "(1 session)", "**Total sessions**: 1"} {
+		if !strings.Contains(reply, want) {
+			t.Errorf("!mygames reply %q doesn't contain %q", reply, want)
+		}
+	}
+}
+
+// TestPlaySessionScenarioNoData checks the !mygames reply of someone who never played
+func TestPlaySessionScenarioNoData(t *testing.T) {
+	newTestStore(t)
+	setForTest(t, &commandCooldown, 0)
+	s := newFakeSession()
+
+	dispatchCommand(s, testMessage("2", "!mygames"))
+	if reply := s.lastMessage(t, "channel"); !strings.Contains(reply, "haven't tracked any games") {
+		t.Errorf("!mygames reply = %q, want the no games message", reply)
+	}
+}
+
+// countingBackend is a memory backend that counts full saves and save time records
+type countingBackend struct {
+	*memoryBackend
+	saves, records int
+}
+
+func (b *countingBackend) save(tempData persistedData) error {
+	b.saves++
+	return b.memoryBackend.save(tempData)
+}
+
+func (b *countingBackend) recordSaveTime() error {
+	b.records++
+	return b.memoryBackend.recordSaveTime()
+}
+
+func TestAutosave(t *testing.T) {
+	tests := []struct {
+		name        string
+		dirty       bool
+		playing     bool
+		failures    int
+		wantSaves   int
+		wantRecords int
+	}{
+		{"nothing changed", false, false, 0, 0, 0},
+		{"changes", true, false, 0, 1, 0},
+		{"changes while playing", true, true, 0, 1, 0},
+		{"only playing", false, true, 0, 0, 1},
+		{"saves failing", true, true, 2, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			backend := &countingBackend{memoryBackend: newMemoryBackend()}
+			store.backend = backend
+
+			store.mu.Lock()
+			userData := store.getOrCreateUser("guild", "1")
+			if tt.playing {
+				userData.ActiveGames["Minecraft"] = time.Now().Add(-time.Hour)
+			}
+			store.dirty = tt.dirty
+			store.saveFailures = tt.failures
+			err := store.autosaveLocked()
+			store.mu.Unlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if backend.saves != tt.wantSaves || backend.records != tt.wantRecords {
+				t.Errorf("%d saves and %d save time records, want %d and %d", backend.saves, backend.records, tt.wantSaves, tt.wantRecords)
+			}
+		})
+	}
+}
+
+func TestClearGamesKeepsSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		playing bool
+		voice   string
+	}{
+		{"idle", false, ""},
+		{"playing", true, ""},
+		{"in voice", false, "voice-channel"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			start := time.Now().Add(-5 * time.Hour)
+			addSession(store, "1", "Minecraft", start, time.Hour)
+
+			store.mu.Lock()
+			userData := store.Guilds["guild"]["1"]
+			userData.Timezone = "Europe/Berlin"
+			userData.DurationFormat = "verbose"
+			userData.DailyBudget = 3600
+			userData.WeeklyGoal = 36000
+			userData.BreakReminder = 7200
+			userData.NotifySessions = true
+			userData.MuteBudgetWarnings = true
+			userData.QuietHours = "23-7"
+			userData.GameGoals = map[string]*GameGoal{"Minecraft": {Target: 3600, Reached: true}}
+			userData.NotifiedMilestones = map[string]float64{"Minecraft": 3600}
+			userData.TrimmedTotals = map[string]float64{"Minecraft": 600}
+			userData.TrimmedCounts = map[string]int{"Minecraft": 1}
+			if tt.playing {
+				userData.ActiveGames["Tetris"] = start
+			}
+			if tt.voice != "" {
+				applyVoiceStateLocked("guild", "1", tt.voice, start)
+			}
+			store.mu.Unlock()
+			s := newFakeSession()
+
+			dispatchCommand(s, testMessage("1", "!cleargames"))
+
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			cleared := store.Guilds["guild"]["1"]
+			if len(cleared.Sessions) != 0 || len(cleared.TrimmedTotals) != 0 || len(cleared.TrimmedCounts) != 0 || len(cleared.NotifiedMilestones) != 0 {
+				t.Errorf("history left after clearing: %+v", cleared)
+			}
+			if cleared.Timezone != "Europe/Berlin" || cleared.DurationFormat != "verbose" || cleared.DailyBudget != 3600 ||
+				cleared.WeeklyGoal != 36000 || cleared.BreakReminder != 7200 || !cleared.NotifySessions ||
+				!cleared.MuteBudgetWarnings || cleared.QuietHours != "23-7" {
+				t.Errorf("settings lost after clearing: %+v", cleared)
+			}
+			if goal := cleared.GameGoals["Minecraft"]; goal == nil || goal.Target != 3600 || goal.Reached {
+				t.Errorf("game goal after clearing = %+v, want the target kept and not reached", goal)
+			}
+			if _, ok := cleared.ActiveGames["Tetris"]; ok != tt.playing {
+				t.Errorf("Tetris active after clearing = %v, want %v", ok, tt.playing)
+			}
+			if cleared.voiceChannel != tt.voice {
+				t.Errorf("voice channel after clearing = %q, want %q", cleared.voiceChannel, tt.voice)
+			}
+			for gameName, since := range cleared.ActiveGames {
+				if since.Before(start.Add(time.Hour)) {
+					t.Errorf("%s active since %v after clearing, want it restarted", gameName, since)
+				}
+			}
+		})
+	}
+}
+
+// TestNoDeadlockWhenSaving runs the paths that save while holding the data lock and fails if any of
+// them doesn't return
+func TestNoDeadlockWhenSaving(t *testing.T) {
+	tests := []struct {
+		name string
+		run  func(s *fakeSession)
+	}{
+		{"session ends", func(s *fakeSession) {
+			handlePresence(s, testPresence("1", time.Now().Add(-time.Hour), "Minecraft"), time.Time{})
+			handlePresence(s, testPresence("1", time.Time{}), time.Time{})
+		}},
+		{"cleargames", func(s *fakeSession) {
+			handlePresence(s, testPresence("1", time.Now().Add(-time.Hour), "Minecraft"), time.Time{})
+			dispatchCommand(s, testMessage("1", "!cleargames"))
+		}},
+		{"resetgame", func(s *fakeSession) {
+			addSession(data, "1", "Minecraft", time.Now().Add(-2*time.Hour), time.Hour)
+			dispatchCommand(s, testMessage("1", "!resetgame Minecraft"))
+		}},
+		{"settings", func(s *fakeSession) {
+			dispatchCommand(s, testMessage("1", "!budget 3h"))
+			dispatchCommand(s, testMessage("1", "!settz UTC"))
+		}},
+		{"shutdown", func(s *fakeSession) {
+			handlePresence(s, testPresence("1", time.Now().Add(-time.Hour), "Minecraft"), time.Time{})
+			data.finalizeActiveSessions(time.Now())
+			data.save()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			path := filepath.Join(t.TempDir(), "game_data.json")
+			store.backend = &jsonBackend{path: path, backupPath: path + backupFileSuffix}
+			setForTest(t, &commandCooldown, 0)
+			setForTest(t, &emptyActivityGrace, 0)
+			setForTest(t, &mergeWindow, 0)
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				tt.run(newFakeSession())
+			}()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out, the data lock is probably taken twice")
+			}
+			if !store.mu.TryLock() {
+				t.Fatal("the data lock is still held")
+			}
+			store.mu.Unlock()
+		})
+	}
+}
+
+func TestFinalizeActiveSessions(t *testing.T) {
+	end := time.Date(2024, 6, 10, 20, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		started      time.Duration // How long before end the game started, negative for after it
+		maxDuration  time.Duration
+		wantDuration time.Duration // 0 for no session
+	}{
+		{"an hour", time.Hour, 0, time.Hour},
+		{"over the cap", 10 * time.Hour, 4 * time.Hour, 4 * time.Hour},
+		{"under the cap", 2 * time.Hour, 4 * time.Hour, 2 * time.Hour},
+		{"clock went back", -time.Minute, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &maxSessionDuration, tt.maxDuration)
+			store.mu.Lock()
+			userData := store.getOrCreateUser("guild", "1")
+			userData.ActiveGames["Minecraft"] = end.Add(-tt.started)
+			userData.ActiveIDs = map[string]string{"Minecraft": "session-1"}
+			store.mu.Unlock()
+
+			store.finalizeActiveSessions(end)
+
+			userData, _ = store.snapshotUser("guild", "1")
+			if len(userData.ActiveGames) != 0 {
+				t.Errorf("active games after finalizing = %v, want none", userData.ActiveGames)
+			}
+			if tt.wantDuration == 0 {
+				if len(userData.Sessions) != 0 {
+					t.Errorf("sessions = %+v, want none", userData.Sessions)
+				}
+				return
+			}
+			if len(userData.Sessions) != 1 {
+				t.Fatalf("sessions = %+v, want one", userData.Sessions)
+			}
+			session := userData.Sessions[0]
+			if got := time.Duration(session.Duration) * time.Second; got != tt.wantDuration {
+				t.Errorf("duration = %v, want %v", got, tt.wantDuration)
+			}
+			if !session.StartTime.Equal(end.Add(-tt.started)) {
+				t.Errorf("start = %v, want %v", session.StartTime, end.Add(-tt.started))
+			}
+			if session.ID != "session-1" || userData.FinalizedIDs["Minecraft"] != "session-1" {
+				t.Errorf("session ID = %q, finalized IDs = %v, want the active session's ID kept", session.ID, userData.FinalizedIDs)
+			}
+		})
+	}
+}
+
+func TestSnapshotUserIsDeepCopy(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(snapshot *UserGameData)
+		check  func(stored *UserGameData) bool // Reports whether the stored data is unchanged
+	}{
+		{"sessions", func(u *UserGameData) { u.Sessions[0].Duration = 1 }, func(u *UserGameData) bool { return u.Sessions[0].Duration == 3600 }},
+		{"active games", func(u *UserGameData) { delete(u.ActiveGames, "Tetris") }, func(u *UserGameData) bool { _, ok := u.ActiveGames["Tetris"]; return ok }},
+		{"game goals", func(u *UserGameData) { u.GameGoals["Minecraft"].Reached = true }, func(u *UserGameData) bool { return !u.GameGoals["Minecraft"].Reached }},
+		{"trimmed totals", func(u *UserGameData) { u.TrimmedTotals["Minecraft"] = 0 }, func(u *UserGameData) bool { return u.TrimmedTotals["Minecraft"] == 600 }},
+		{"trimmed counts", func(u *UserGameData) { u.TrimmedCounts["Minecraft"] = 0 }, func(u *UserGameData) bool { return u.TrimmedCounts["Minecraft"] == 1 }},
+		{"milestones", func(u *UserGameData) { u.NotifiedMilestones["Minecraft"] = 0 }, func(u *UserGameData) bool { return u.NotifiedMilestones["Minecraft"] == 3600 }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			addSession(store, "1", "Minecraft", time.Now().Add(-2*time.Hour), time.Hour)
+			store.mu.Lock()
+			stored := store.Guilds["guild"]["1"]
+			stored.ActiveGames["Tetris"] = time.Now()
+			stored.GameGoals = map[string]*GameGoal{"Minecraft": {Target: 7200}}
+			stored.TrimmedTotals = map[string]float64{"Minecraft": 600}
+			stored.TrimmedCounts = map[string]int{"Minecraft": 1}
+			stored.NotifiedMilestones = map[string]float64{"Minecraft": 3600}
+			store.mu.Unlock()
+
+			snapshot, ok := store.snapshotUser("guild", "1")
+			if !ok {
+				t.Fatal("no snapshot of a known user")
+			}
+			tt.mutate(snapshot)
+
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			if !tt.check(stored) {
+				t.Error("changing the snapshot changed the stored data")
+			}
+		})
+	}
+}
+
+// blockingSession is a fake session whose sends wait until release is closed
+type blockingSession struct {
+	*fakeSession
+	sending chan struct{} // Closed when the first send starts
+	release chan struct{}
+	once    sync.Once
+}
+
+func (b *blockingSession) ChannelMessageSend(channelID, content string, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	b.once.Do(func() { close(b.sending) })
+	<-b.release
+	return b.fakeSession.ChannelMessageSend(channelID, content, options...)
+}
+
+func (b *blockingSession) ChannelMessageSendComplex(channelID string, data *discordgo.MessageSend, options ...discordgo.RequestOption) (*discordgo.Message, error) {
+	b.once.Do(func() { close(b.sending) })
+	<-b.release
+	return b.fakeSession.ChannelMessageSendComplex(channelID, data, options...)
+}
+
+// TestCommandsDontBlockPresence checks that a presence update goes through while a command is
+// still sending its reply
+func TestCommandsDontBlockPresence(t *testing.T) {
+	store := newTestStore(t)
+	setForTest(t, &commandCooldown, 0)
+	addSession(store, "1", "Minecraft", time.Now().Add(-2*time.Hour), time.Hour)
+
+	for _, command := range []string{"!mygames", "!profile", "!sessions", "!gamestats minecraft"} {
+		t.Run(command, func(t *testing.T) {
+			s := &blockingSession{fakeSession: newFakeSession(), sending: make(chan struct{}), release: make(chan struct{})}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				dispatchCommand(s, testMessage("1", command))
+			}()
+			<-s.sending
+
+			updated := make(chan struct{})
+			go func() {
+				defer close(updated)
+				handlePresence(newFakeSession(), testPresence("2", time.Now(), "Tetris"), time.Time{})
+			}()
+			select {
+			case <-updated:
+			case <-time.After(5 * time.Second):
+				t.Error("the presence update waited for the command's reply")
+			}
+			close(s.release)
+			<-done
+		})
+	}
+}
+
+func TestMalformedPresenceUpdates(t *testing.T) {
+	tests := []struct {
+		name     string
+		presence func() *discordgo.PresenceUpdate
+		wantUser bool // Whether the update is tracked
+	}{
+		{"no user", func() *discordgo.PresenceUpdate {
+			p := testPresence("1", time.Now(), "Minecraft")
+			p.User = nil
+			return p
+		}, false},
+		{"no user ID", func() *discordgo.PresenceUpdate {
+			p := testPresence("1", time.Now(), "Minecraft")
+			p.User.ID = ""
+			return p
+		}, false},
+		{"bot", func() *discordgo.PresenceUpdate {
+			p := testPresence("1", time.Now(), "Minecraft")
+			p.User.Bot = true
+			return p
+		}, false},
+		{"nil activity", func() *discordgo.PresenceUpdate {
+			p := testPresence("1", time.Now(), "Minecraft")
+			p.Activities = append(p.Activities, nil)
+			return p
+		}, true},
+		{"nameless activity", func() *discordgo.PresenceUpdate {
+			p := testPresence("1", time.Now(), "Minecraft")
+			p.Activities = append(p.Activities, &discordgo.Activity{Type: discordgo.ActivityTypeGame})
+			return p
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+
+			handlePresence(newFakeSession(), tt.presence(), time.Time{})
+
+			userData, ok := store.snapshotUser("guild", "1")
+			if ok != tt.wantUser {
+				t.Fatalf("user tracked = %v, want %v", ok, tt.wantUser)
+			}
+			if ok && (len(userData.ActiveGames) != 1 || userData.ActiveGames["Minecraft"].IsZero()) {
+				t.Errorf("active games = %v, want only Minecraft", userData.ActiveGames)
+			}
+		})
+	}
+}
+
+func TestMessageWithoutAuthor(t *testing.T) {
+	newTestStore(t)
+	setForTest(t, &commandCooldown, 0)
+	m := testMessage("1", "!mygames")
+	m.Author = nil
+
+	// The author check comes before anything uses the session
+	messageCreate(nil, m)
+}
+
+func TestLoadSkipsNullEntries(t *testing.T) {
+	tests := []struct {
+		name      string
+		file      string
+		wantUsers map[string]int // Sessions per user of the guild that loaded
+	}{
+		{"null user", `{"version": 1, "guilds": {"guild": {"1": null, "2": {"sessions": [{"game_name": "Tetris", "start_time": "2024-06-01T10:00:00Z", "end_time": "2024-06-01T11:00:00Z", "duration_seconds": 3600}]}}}}`,
+			map[string]int{"2": 1}},
+		{"null guild", `{"version": 1, "guilds": {"other": null, "guild": {"2": {"sessions": []}}}}`,
+			map[string]int{"2": 0}},
+		{"null sessions", `{"version": 1, "guilds": {"guild": {"2": {"sessions": null, "active_games": null}}}}`,
+			map[string]int{"2": 0}},
+		{"only settings", `{"version": 1, "guilds": {"guild": {"2": {"timezone": "UTC"}}}}`,
+			map[string]int{"2": 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "game_data.json")
+			if err := os.WriteFile(path, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+			store := newTestStore(t)
+			store.backend = &jsonBackend{path: path, backupPath: path + backupFileSuffix}
+			setForTest(t, &commandCooldown, 0)
+
+			if err := store.load(); err != nil {
+				t.Fatal(err)
+			}
+			users := store.Guilds["guild"]
+			if len(users) != len(tt.wantUsers) {
+				t.Errorf("loaded users %v, want %v", sortedKeys(users), tt.wantUsers)
+			}
+			for userID, want := range tt.wantUsers {
+				userData := users[userID]
+				if userData == nil {
+					t.Fatalf("user %s wasn't loaded", userID)
+				}
+				if userData.Sessions == nil || userData.ActiveGames == nil || len(userData.Sessions) != want {
+					t.Errorf("user %s loaded with sessions %v and active games %v, want %d sessions and non-nil maps", userID, userData.Sessions, userData.ActiveGames, want)
+				}
+			}
+
+			// The bot keeps working with what was loaded
+			s := newFakeSession()
+			handlePresence(s, testPresence("2", time.Now(), "Minecraft"), time.Time{})
+			handlePresence(s, testPresence("1", time.Now(), "Minecraft"), time.Time{})
+			dispatchCommand(s, testMessage("2", "!mygames"))
+			s.lastMessage(t, "channel")
+			if err := store.save(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// TestResumeRecentSession checks that a game restarting within the merge window continues the
+// session that just ended, and one restarting later doesn't
+func TestResumeRecentSession(t *testing.T) {
+	stoppedAt := time.Date(2024, 6, 10, 20, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name        string
+		restartedAt time.Duration // After the game stopped
+		wantResumed bool
+	}{
+		{"within the window", 30 * time.Second, true},
+		{"at the window", time.Minute, true},
+		{"outside the window", 2 * time.Minute, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setForTest(t, &mergeWindow, time.Minute)
+			userData := newUserGameData()
+			session := newGameSession("Minecraft", stoppedAt.Add(-time.Hour), stoppedAt)
+			userData.Sessions = []GameSession{session}
+			userData.recentlyStopped = map[string]time.Time{"Minecraft": stoppedAt}
+
+			resumed, ok := resumeRecentSessionLocked(userData, "Minecraft", stoppedAt.Add(tt.restartedAt))
+			if ok != tt.wantResumed {
+				t.Fatalf("resumed = %v, want %v", ok, tt.wantResumed)
+			}
+			if _, pending := userData.recentlyStopped["Minecraft"]; pending {
+				t.Error("the stopped game can still be resumed")
+			}
+			if tt.wantResumed {
+				if !resumed.StartTime.Equal(session.StartTime) || len(userData.Sessions) != 0 {
+					t.Errorf("resumed %+v leaving %+v, want the session taken out of the history", resumed, userData.Sessions)
+				}
+			} else if len(userData.Sessions) != 1 {
+				t.Errorf("sessions = %+v, want the ended session kept", userData.Sessions)
+			}
+		})
+	}
+}
+
+// TestMinSessionSeconds stops games just below and just above MIN_SESSION_SECONDS
+func TestMinSessionSeconds(t *testing.T) {
+	tests := []struct {
+		name        string
+		played      time.Duration
+		wantSession bool
+	}{
+		{"below", 59 * time.Second, false},
+		{"above", 61 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &emptyActivityGrace, 0)
+			setForTest(t, &mergeWindow, 0)
+			setForTest(t, &minSessionSeconds, 60)
+			s := newFakeSession()
+
+			handlePresence(s, testPresence("1", time.Now().Add(-tt.played), "Minecraft"), time.Time{})
+			handlePresence(s, testPresence("1", time.Time{}), time.Time{})
+
+			userData, _ := store.snapshotUser("guild", "1")
+			if len(userData.ActiveGames) != 0 {
+				t.Errorf("active games = %v, want none", userData.ActiveGames)
+			}
+			if got := len(userData.Sessions) == 1; got != tt.wantSession {
+				t.Errorf("sessions = %+v, want a session: %v", userData.Sessions, tt.wantSession)
+			}
+		})
+	}
+}
+
+// TestEmptyActivityGrace sends a presence without activities while a game is running and checks
+// that the session only ends if no update with the game follows within the grace period
+func TestEmptyActivityGrace(t *testing.T) {
+	tests := []struct {
+		name        string
+		transient   bool // Whether the game is reported again before the grace period ends
+		wantSession bool
+	}{
+		{"transient", true, false},
+		{"real stop", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &emptyActivityGrace, 20*time.Millisecond)
+			setForTest(t, &mergeWindow, 0)
+			s := newFakeSession()
+			startedAt := time.Now().Add(-time.Hour)
+
+			handlePresence(s, testPresence("1", startedAt, "Minecraft"), time.Time{})
+			handlePresence(s, testPresence("1", time.Time{}), time.Time{})
+			if userData, _ := store.snapshotUser("guild", "1"); len(userData.Sessions) != 0 {
+				t.Fatalf("sessions = %+v right after the empty update, want none yet", userData.Sessions)
+			}
+			if tt.transient {
+				handlePresence(s, testPresence("1", startedAt, "Minecraft"), time.Time{})
+			}
+			time.Sleep(100 * time.Millisecond) // Let the grace period run out
+
+			userData, _ := store.snapshotUser("guild", "1")
+			if got := len(userData.Sessions) == 1; got != tt.wantSession {
+				t.Errorf("sessions = %+v, want a session: %v", userData.Sessions, tt.wantSession)
+			}
+			if _, active := userData.ActiveGames["Minecraft"]; active == tt.wantSession {
+				t.Errorf("Minecraft active = %v, want %v", active, !tt.wantSession)
+			}
+		})
+	}
+}
+
+// TestSessionContinuesAfterRestart finalizes a running game at shutdown, restarts on the saved
+// data and checks that the finalized session continues under its ID if the game is still running
+func TestSessionContinuesAfterRestart(t *testing.T) {
+	tests := []struct {
+		name          string
+		relaunched    bool // Whether the game was started again after the shutdown
+		wantContinued bool
+	}{
+		{"still running", false, true},
+		{"started again", true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &emptyActivityGrace, 0)
+			setForTest(t, &mergeWindow, 0)
+			startedAt := time.Now().Add(-2 * time.Hour)
+			shutdown := time.Now().Add(-10 * time.Minute)
+			handlePresence(newFakeSession(), testPresence("1", startedAt, "Minecraft"), time.Time{})
+			store.mu.Lock()
+			sessionID := store.Guilds["guild"]["1"].ActiveIDs["Minecraft"]
+			store.mu.Unlock()
+			store.finalizeActiveSessions(shutdown)
+			if err := store.save(); err != nil {
+				t.Fatal(err)
+			}
+
+			restarted := newDataStore(store.backend)
+			if err := restarted.load(); err != nil {
+				t.Fatal(err)
+			}
+			setForTest(t, &data, restarted)
+			launchedAt := startedAt
+			if tt.relaunched {
+				launchedAt = time.Now().Add(-time.Minute)
+			}
+			handlePresence(newFakeSession(), testPresence("1", launchedAt, "Minecraft"), time.Time{})
+
+			userData, _ := restarted.snapshotUser("guild", "1")
+			if tt.wantContinued {
+				if len(userData.Sessions) != 0 {
+					t.Errorf("sessions = %+v, want the finalized one continued", userData.Sessions)
+				}
+				if !userData.ActiveGames["Minecraft"].Equal(time.UnixMilli(startedAt.UnixMilli())) || userData.ActiveIDs["Minecraft"] != sessionID {
+					t.Errorf("active since %v with ID %q, want since the first launch with ID %q", userData.ActiveGames["Minecraft"], userData.ActiveIDs["Minecraft"], sessionID)
+				}
+				return
+			}
+			if len(userData.Sessions) != 1 || userData.Sessions[0].ID != sessionID {
+				t.Errorf("sessions = %+v, want the finalized one kept", userData.Sessions)
+			}
+			if userData.ActiveIDs["Minecraft"] == "" || userData.ActiveIDs["Minecraft"] == sessionID {
+				t.Errorf("active session ID = %q, want a new one", userData.ActiveIDs["Minecraft"])
+			}
+		})
+	}
+}
+
+// TestClockWentBack ends a game whose start lies after the end, as it does when the system clock
+// jumps back, and checks that no session is recorded
+func TestClockWentBack(t *testing.T) {
+	store := newTestStore(t)
+	setForTest(t, &emptyActivityGrace, 0)
+	setForTest(t, &mergeWindow, 0)
+	store.mu.Lock()
+	store.getOrCreateUser("guild", "1").ActiveGames["Minecraft"] = time.Now().Add(time.Hour)
+	store.mu.Unlock()
+
+	handlePresence(newFakeSession(), testPresence("1", time.Time{}), time.Time{})
+
+	userData, _ := store.snapshotUser("guild", "1")
+	if len(userData.ActiveGames) != 0 {
+		t.Errorf("active games = %v, want none", userData.ActiveGames)
+	}
+	if len(userData.Sessions) != 0 {
+		t.Errorf("sessions = %+v, want none", userData.Sessions)
+	}
+}
+
+func TestUniquePlayTime(t *testing.T) {
+	base := time.Date(2024, 6, 10, 12, 0, 0, 0, time.UTC)
+	at := func(minutes int) time.Time { return base.Add(time.Duration(minutes) * time.Minute) }
+	tests := []struct {
+		name     string
+		sessions [][2]int // Start and end in minutes after base
+		active   int      // Start of an active game in minutes after base, -1 for none
+		want     time.Duration
+	}{
+		{"none", nil, -1, 0},
+		{"apart", [][2]int{{0, 60}, {120, 150}}, -1, 90 * time.Minute},
+		{"overlapping", [][2]int{{0, 60}, {30, 90}}, -1, 90 * time.Minute},
+		{"nested", [][2]int{{0, 120}, {30, 60}}, -1, 2 * time.Hour},
+		{"touching", [][2]int{{0, 60}, {60, 90}}, -1, 90 * time.Minute},
+		{"unsorted", [][2]int{{100, 130}, {0, 60}, {50, 70}}, -1, 100 * time.Minute},
+		{"active overlapping", [][2]int{{0, 60}}, 30, 3 * time.Hour}, // Now is 3h after base
+		{"ended before it started", [][2]int{{60, 0}, {0, 30}}, -1, 30 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userData := newUserGameData()
+			for i, s := range tt.sessions {
+				userData.Sessions = append(userData.Sessions, GameSession{GameName: fmt.Sprint("Game ", i), StartTime: at(s[0]), EndTime: at(s[1])})
+			}
+			if tt.active >= 0 {
+				userData.ActiveGames["Tetris"] = at(tt.active)
+			}
+			if got := uniquePlayTime(userData, at(180)); got != tt.want {
+				t.Errorf("unique play time = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestActiveGameNotCountedTwice gives a user an active game that started before their last
+// session of it ended and checks that no aggregation counts the overlap twice
+func TestActiveGameNotCountedTwice(t *testing.T) {
+	now := time.Date(2024, 6, 10, 20, 0, 0, 0, time.UTC)
+	userData := newUserGameData()
+	userData.Sessions = []GameSession{newGameSession("Minecraft", now.Add(-3*time.Hour), now.Add(-time.Hour))}
+	userData.ActiveGames["Minecraft"] = now.Add(-2 * time.Hour)
+
+	if got := gamePlayTimes(userData, now)["Minecraft"]; got != 3*time.Hour {
+		t.Errorf("gamePlayTimes = %v, want 3h", got)
+	}
+	if got := gamePlayTimesBetween(userData, now.Add(-24*time.Hour), now)["Minecraft"]; got != 3*time.Hour {
+		t.Errorf("gamePlayTimesBetween = %v, want 3h", got)
+	}
+	if got := playTimeBetween(userData, now.Add(-24*time.Hour), now); got != 3*time.Hour {
+		t.Errorf("playTimeBetween = %v, want 3h", got)
+	}
+	if got := uniquePlayTime(userData, now); got != 3*time.Hour {
+		t.Errorf("uniquePlayTime = %v, want 3h", got)
+	}
+}
+
+// failingBackend is a memory backend whose saves fail while fail is set
+type failingBackend struct {
+	*memoryBackend
+	mu   sync.Mutex
+	fail bool
+}
+
+func (b *failingBackend) setFailing(fail bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fail = fail
+}
+
+func (b *failingBackend) save(tempData persistedData) error {
+	b.mu.Lock()
+	fail := b.fail
+	b.mu.Unlock()
+	if fail {
+		return errors.New("no space left on device")
+	}
+	return b.memoryBackend.save(tempData)
+}
+
+func TestSaveBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 10 * time.Second},
+		{1, 10 * time.Second},
+		{2, 20 * time.Second},
+		{4, 80 * time.Second},
+		{10, maxSaveBackoff},
+		{1000, maxSaveBackoff},
+	}
+	for _, tt := range tests {
+		if got := saveBackoff(10*time.Second, tt.failures); got != tt.want {
+			t.Errorf("backoff after %d failures = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
+
+// TestFlusherRetriesFailedSaves lets the background saver run against a backend that fails to
+// save, then recovers, and checks that it keeps the changes, alerts and retries until they're saved
+func TestFlusherRetriesFailedSaves(t *testing.T) {
+	store := newTestStore(t)
+	backend := &failingBackend{memoryBackend: newMemoryBackend(), fail: true}
+	store.backend = backend
+	alerts := make(chan string, 10)
+	store.alert = func(message string) { alerts <- message }
+	addSession(store, "1", "Minecraft", time.Now().Add(-2*time.Hour), time.Hour)
+	store.mu.Lock()
+	store.markDirtyLocked()
+	store.mu.Unlock()
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		store.runFlusher(5*time.Millisecond, stop)
+		close(done)
+	}()
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	select {
+	case alert := <-alerts:
+		if !strings.Contains(alert, "failed 3 times") {
+			t.Errorf("alert = %q, want it to count the failures", alert)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("no alert after repeated save failures")
+	}
+	store.mu.Lock()
+	dirty := store.dirty
+	store.mu.Unlock()
+	if !dirty {
+		t.Error("the changes that couldn't be saved are no longer pending")
+	}
+
+	backend.setFailing(false)
+	select {
+	case alert := <-alerts:
+		if !strings.Contains(alert, "works again") {
+			t.Errorf("alert = %q, want the recovery", alert)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the saver didn't retry after the backend recovered")
+	}
+	loaded := newDataStore(backend)
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if userData, ok := loaded.snapshotUser("guild", "1"); !ok || len(userData.Sessions) != 1 {
+		t.Error("the session wasn't saved once the backend recovered")
+	}
+}
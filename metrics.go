@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// sessionDurationBuckets are the upper bounds in seconds of the session duration histogram
+var sessionDurationBuckets = []float64{60, 300, 900, 1800, 3600, 7200, 14400, 28800}
+
+// metrics holds the counters exported at /metrics in the Prometheus text format. Gauges are
+// read from the data store when scraped, so they can't drift from what is tracked.
+var metrics struct {
+	mu              sync.Mutex
+	sessionsStarted uint64
+	sessionsEnded   uint64
+	durationCounts  []uint64 // Per bucket of sessionDurationBuckets, not cumulative
+	durationSum     float64
+	durationCount   uint64
+}
+
+func init() {
+	metrics.durationCounts = make([]uint64, len(sessionDurationBuckets))
+}
+
+// recordSessionStarted counts a session that started
+func recordSessionStarted() {
+	metrics.mu.Lock()
+	metrics.sessionsStarted++
+	metrics.mu.Unlock()
+}
+
+// recordSessionEnded counts a session that ended and adds its duration to the histogram
+func recordSessionEnded(durationSeconds float64) {
+	metrics.mu.Lock()
+	defer metrics.mu.Unlock()
+
+	metrics.sessionsEnded++
+	for i, bound := range sessionDurationBuckets {
+		if durationSeconds <= bound {
+			metrics.durationCounts[i]++
+			break
+		}
+	}
+	metrics.durationSum += durationSeconds
+	metrics.durationCount++
+}
+
+// startMetricsServer serves /metrics on addr in the background
+func startMetricsServer(addr string) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /metrics", handleMetrics)
+
+	return startHTTPServer("Metrics server", addr, mux)
+}
+
+// handleMetrics writes all metrics in the Prometheus text exposition format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	var activeSessions, trackedUsers int
+	data.mu.Lock()
+	for _, users := range data.Guilds {
+		trackedUsers += len(users)
+		for _, userData := range users {
+			activeSessions += len(userData.ActiveGames)
+		}
+	}
+	data.mu.Unlock()
+
+	var b strings.Builder
+	metrics.mu.Lock()
+	writeMetric(&b, "gametracker_sessions_started_total", "counter", "Sessions started since the bot started.", metrics.sessionsStarted)
+	writeMetric(&b, "gametracker_sessions_ended_total", "counter", "Sessions ended and recorded since the bot started.", metrics.sessionsEnded)
+
+	b.WriteString("# HELP gametracker_session_duration_seconds Duration of recorded sessions.\n")
+	b.WriteString("# TYPE gametracker_session_duration_seconds histogram\n")
+	var cumulative uint64
+	for i, bound := range sessionDurationBuckets {
+		cumulative += metrics.durationCounts[i]
+		fmt.Fprintf(&b, "gametracker_session_duration_seconds_bucket{le=\"%g\"} %d\n", bound, cumulative)
+	}
+	fmt.Fprintf(&b, "gametracker_session_duration_seconds_bucket{le=\"+Inf\"} %d\n", metrics.durationCount)
+	fmt.Fprintf(&b, "gametracker_session_duration_seconds_sum %g\n", metrics.durationSum)
+	fmt.Fprintf(&b, "gametracker_session_duration_seconds_count %d\n", metrics.durationCount)
+	metrics.mu.Unlock()
+
+	writeMetric(&b, "gametracker_active_sessions", "gauge", "Sessions in progress right now.", activeSessions)
+	writeMetric(&b, "gametracker_tracked_users", "gauge", "Users with tracked data, counted once per guild.", trackedUsers)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write([]byte(b.String()))
+}
+
+// writeMetric writes a metric without labels with its HELP and TYPE lines
+func writeMetric(b *strings.Builder, name, kind, help string, value interface{}) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, kind, name, value)
+}
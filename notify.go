@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// notifyKind is a kind of DM users can turn on or off with !notify
+type notifyKind struct {
+	name        string
+	description string
+	enabled     func(userData *UserGameData) bool
+	set         func(userData *UserGameData, on bool)
+}
+
+// notifyKinds lists the DMs !notify toggles. A bare on or off toggles the first one.
+var notifyKinds = []notifyKind{
+	{
+		name:        "sessions",
+		description: "a summary after each session",
+		enabled:     func(userData *UserGameData) bool { return userData.NotifySessions },
+		set:         func(userData *UserGameData, on bool) { userData.NotifySessions = on },
+	},
+	{
+		name:        "budget",
+		description: "warnings when you approach or reach your daily budget",
+		enabled:     func(userData *UserGameData) bool { return !userData.MuteBudgetWarnings },
+		set:         func(userData *UserGameData, on bool) { userData.MuteBudgetWarnings = !on },
+	},
+	{
+		name:        "milestones",
+		description: "congratulations when you pass a play-time milestone on a game",
+		enabled:     func(userData *UserGameData) bool { return !userData.MuteMilestones },
+		set:         func(userData *UserGameData, on bool) { userData.MuteMilestones = !on },
+	},
+}
+
+// handleNotify implements the !notify command: show which DMs the user gets or turn one on or off
+func handleNotify(s messageSender, m *discordgo.MessageCreate, args string) {
+	userID := m.Author.ID
+	username := m.Author.Username
+
+	fields := strings.Fields(strings.ToLower(args))
+	if len(fields) == 0 {
+		userData, ok := data.snapshotUser(m.GuildID, userID)
+		if !ok {
+			userData = newUserGameData()
+		}
+		response := fmt.Sprintf("Hey %s, here are the DMs I send you:\n", username)
+		for _, kind := range notifyKinds {
+			state := "off"
+			if kind.enabled(userData) {
+				state = "on"
+			}
+			response += fmt.Sprintf("- `%s`: %s, %s\n", kind.name, kind.description, state)
+		}
+		response += fmt.Sprintf("Use `%snotify <kind> on` or `off` to change one.", commandPrefix)
+		sendChunked(s, m.ChannelID, response)
+		return
+	}
+
+	// A bare on or off is for the first kind
+	if len(fields) == 1 {
+		fields = []string{notifyKinds[0].name, fields[0]}
+	}
+	kind, ok := findNotifyKind(fields[0])
+	if !ok || len(fields) != 2 || (fields[1] != "on" && fields[1] != "off") {
+		sendUsage(s, m.ChannelID, "notify")
+		return
+	}
+	on := fields[1] == "on"
+
+	data.mu.Lock()
+	userData := data.getOrCreateUser(m.GuildID, userID)
+	kind.set(userData, on)
+	if err := data.saveLocked(); err != nil {
+		slog.Error("Error saving notifications", "username", username, "error", err)
+	}
+	data.mu.Unlock()
+
+	if !on {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I won't DM you %s anymore.", username, kind.description))
+		return
+	}
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I'll DM you %s. Make sure you accept DMs from server members.", username, kind.description))
+}
+
+// findNotifyKind looks up a kind of DM by name
+func findNotifyKind(name string) (notifyKind, bool) {
+	for _, kind := range notifyKinds {
+		if kind.name == name {
+			return kind, true
+		}
+	}
+	return notifyKind{}, false
+}
+
+// handleQuiet implements the !quiet command: show, set or clear the hours of the day budget warnings wait in
+func handleQuiet(s messageSender, m *discordgo.MessageCreate, args string) {
+	userID := m.Author.ID
+	username := m.Author.Username
+
+	if args == "" {
+		userData, ok := data.snapshotUser(m.GuildID, userID)
+		if !ok || userData.QuietHours == "" {
+			sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you don't have quiet hours set. Use `%squiet 23-7` to hold back budget warnings from 23:00 to 07:00.", username, commandPrefix))
+			return
+		}
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I hold back budget warnings %s in your timezone.", username, formatQuietHours(userData.QuietHours)))
+		return
+	}
+
+	var quietHours string
+	if !strings.EqualFold(args, "off") {
+		start, end, err := parseQuietHours(args)
+		if err != nil {
+			sendUsage(s, m.ChannelID, "quiet")
+			return
+		}
+		quietHours = fmt.Sprintf("%d-%d", start, end)
+	}
+
+	data.mu.Lock()
+	userData := data.getOrCreateUser(m.GuildID, userID)
+	userData.QuietHours = quietHours
+	if err := data.saveLocked(); err != nil {
+		slog.Error("Error saving quiet hours", "username", username, "error", err)
+	}
+	data.mu.Unlock()
+
+	if quietHours == "" {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, your quiet hours have been removed.", username))
+		return
+	}
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I'll hold back budget warnings %s in your timezone until they're over.", username, formatQuietHours(quietHours)))
+}
+
+// parseQuietHours parses quiet hours like "23-7", the whole hours of the day they start and end at
+func parseQuietHours(value string) (start, end int, err error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("quiet hours %q aren't in the form start-end", value)
+	}
+	if start, err = strconv.Atoi(strings.TrimSpace(from)); err == nil {
+		end, err = strconv.Atoi(strings.TrimSpace(to))
+	}
+	if err != nil || start < 0 || start > 23 || end < 0 || end > 23 || start == end {
+		return 0, 0, fmt.Errorf("quiet hours %q need two different hours from 0 to 23", value)
+	}
+	return start, end, nil
+}
+
+// formatQuietHours shows stored quiet hours like "from 23:00 to 07:00"
+func formatQuietHours(value string) string {
+	start, end, err := parseQuietHours(value)
+	if err != nil {
+		return value
+	}
+	return fmt.Sprintf("from %02d:00 to %02d:00", start, end)
+}
+
+// inQuietHours reports whether t falls within the user's quiet hours, in their timezone
+func inQuietHours(userData *UserGameData, t time.Time) bool {
+	start, end, err := parseQuietHours(userData.QuietHours)
+	if err != nil {
+		return false // None set
+	}
+	hour := t.In(userLocation(userData)).Hour()
+	if start < end {
+		return hour >= start && hour < end
+	}
+	// Quiet hours spanning midnight
+	return hour >= start || hour < end
+}
+
+// sessionNotification is the DM sent after a session ends to users with notifications on, total
+// being the user's play time of the game including the session
+func sessionNotification(userData *UserGameData, session GameSession, total time.Duration) string {
+	format := userDurationFormat(userData)
+	return fmt.Sprintf("You played **%s** for %s (total now %s).", sanitizeName(session.GameName), format(time.Duration(session.Duration)*time.Second), format(total))
+}
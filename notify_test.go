@@ -0,0 +1,78 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNotifyCommand(t *testing.T) {
+	tests := []struct {
+		content      string
+		wantSessions bool
+		wantBudget   bool
+		wantReply    string
+	}{
+		{"!notify", false, true, "`budget`: warnings when you approach or reach your daily budget, on"},
+		{"!notify on", true, true, "I'll DM you a summary after each session"},
+		{"!notify sessions on", true, true, "I'll DM you a summary after each session"},
+		{"!notify budget off", false, false, "I won't DM you warnings"},
+		{"!notify milestones off", false, true, "I won't DM you congratulations"},
+		{"!notify budget", false, true, "Usage"},
+		{"!notify everything on", false, true, "Usage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			s := newFakeSession()
+
+			dispatchCommand(s, testMessage("1", tt.content))
+
+			if reply := s.lastMessage(t, "channel"); !strings.Contains(reply, tt.wantReply) {
+				t.Errorf("reply = %q, want it to contain %q", reply, tt.wantReply)
+			}
+			userData, ok := store.snapshotUser("guild", "1")
+			if !ok {
+				userData = newUserGameData()
+			}
+			if wantMilestones := !strings.HasPrefix(tt.content, "!notify milestones off"); userData.MuteMilestones == wantMilestones {
+				t.Errorf("milestone congratulations %v, want %v", !userData.MuteMilestones, wantMilestones)
+			}
+			if userData.NotifySessions != tt.wantSessions || !userData.MuteBudgetWarnings != tt.wantBudget {
+				t.Errorf("session summaries %v and budget warnings %v, want %v and %v", userData.NotifySessions, !userData.MuteBudgetWarnings, tt.wantSessions, tt.wantBudget)
+			}
+		})
+	}
+}
+
+func TestQuietCommand(t *testing.T) {
+	tests := []struct {
+		content   string
+		want      string
+		wantReply string
+	}{
+		{"!quiet 23-7", "23-7", "from 23:00 to 07:00"},
+		{"!quiet 9 - 17", "9-17", "from 09:00 to 17:00"},
+		{"!quiet off", "", "removed"},
+		{"!quiet 25-7", "22-6", "Usage"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			store.mu.Lock()
+			store.getOrCreateUser("guild", "1").QuietHours = "22-6"
+			store.mu.Unlock()
+			s := newFakeSession()
+
+			dispatchCommand(s, testMessage("1", tt.content))
+
+			if reply := s.lastMessage(t, "channel"); !strings.Contains(reply, tt.wantReply) {
+				t.Errorf("reply = %q, want it to contain %q", reply, tt.wantReply)
+			}
+			if snapshot, _ := store.snapshotUser("guild", "1"); snapshot.QuietHours != tt.want {
+				t.Errorf("quiet hours = %q, want %q", snapshot.QuietHours, tt.want)
+			}
+		})
+	}
+}
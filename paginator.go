@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	myGamesPageSize  = 10              // Games per page of !mygames
+	paginatorTimeout = 5 * time.Minute // How long the page reactions of a message keep working
+	pagePrevEmoji    = "◀️"
+	pageNextEmoji    = "▶️"
+)
+
+// paginator is the state of a message whose pages are flipped with reactions
+type paginator struct {
+	userID string // Only the user who ran the command can flip pages
+	pages  []string
+	page   int
+}
+
+// Paginated messages that can still be flipped, keyed by message ID
+var (
+	paginators   = make(map[string]*paginator)
+	paginatorsMu sync.Mutex
+)
+
+// sendPaginated sends the first of pages and adds reactions that let userID flip through the rest
+// until paginatorTimeout passes. A single page is sent as a normal reply.
+func sendPaginated(s messageSender, channelID, userID string, pages []string) error {
+	tooLong := false
+	for _, page := range pages {
+		if len(page) > maxMessageLength {
+			tooLong = true
+		}
+	}
+	if len(pages) == 1 || tooLong {
+		return sendChunked(s, channelID, strings.Join(pages, "\n"))
+	}
+
+	message, err := safeSend(s, channelID, pages[0])
+	if err != nil {
+		return err
+	}
+
+	paginatorsMu.Lock()
+	paginators[message.ID] = &paginator{userID: userID, pages: pages}
+	paginatorsMu.Unlock()
+
+	for _, emoji := range []string{pagePrevEmoji, pageNextEmoji} {
+		if err := s.MessageReactionAdd(channelID, message.ID, emoji); err != nil {
+			slog.Error("Error adding page reaction", "message_id", message.ID, "error", err)
+		}
+	}
+
+	time.AfterFunc(paginatorTimeout, func() {
+		paginatorsMu.Lock()
+		delete(paginators, message.ID)
+		paginatorsMu.Unlock()
+
+		// Removing everyone's reactions needs Manage Messages, the bot's own always work
+		if err := s.MessageReactionsRemoveAll(channelID, message.ID); err != nil {
+			for _, emoji := range []string{pagePrevEmoji, pageNextEmoji} {
+				s.MessageReactionRemove(channelID, message.ID, emoji, "@me")
+			}
+		}
+	})
+	return nil
+}
+
+// messageReactionAdd flips the page of a paginated message when its user reacts with an arrow
+func messageReactionAdd(s *discordgo.Session, r *discordgo.MessageReactionAdd) {
+	if r.UserID == s.State.User.ID {
+		return
+	}
+	flipPage(s, r)
+}
+
+// flipPage turns the paginated message reacted to a page back or forward, for reactions of the
+// user it belongs to with one of the arrows
+func flipPage(s messageSender, r *discordgo.MessageReactionAdd) {
+	// Clients may or may not send the emoji variation selector
+	var delta int
+	switch strings.TrimSuffix(r.Emoji.Name, "\ufe0f") {
+	case strings.TrimSuffix(pagePrevEmoji, "\ufe0f"):
+		delta = -1
+	case strings.TrimSuffix(pageNextEmoji, "\ufe0f"):
+		delta = 1
+	default:
+		return
+	}
+
+	paginatorsMu.Lock()
+	p, ok := paginators[r.MessageID]
+	if !ok || p.userID != r.UserID {
+		paginatorsMu.Unlock()
+		return
+	}
+	page := p.page + delta
+	if page < 0 || page >= len(p.pages) {
+		paginatorsMu.Unlock()
+		return
+	}
+	p.page = page
+	content := p.pages[page]
+	paginatorsMu.Unlock()
+
+	if _, err := s.ChannelMessageEdit(r.ChannelID, r.MessageID, content); err != nil {
+		slog.Error("Error flipping page", "message_id", r.MessageID, "error", err)
+	}
+	// Take the user's reaction back so the same arrow can be pressed again, needs Manage Messages
+	s.MessageReactionRemove(r.ChannelID, r.MessageID, r.Emoji.APIName(), r.UserID)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// testReaction builds a reaction of a user to a message in the test channel
+func testReaction(userID, messageID, emoji string) *discordgo.MessageReactionAdd {
+	return &discordgo.MessageReactionAdd{MessageReaction: &discordgo.MessageReaction{
+		UserID:    userID,
+		MessageID: messageID,
+		ChannelID: "channel",
+		Emoji:     discordgo.Emoji{Name: emoji},
+	}}
+}
+
+func TestPaginator(t *testing.T) {
+	type reaction struct {
+		userID string
+		emoji  string
+	}
+	tests := []struct {
+		name      string
+		reactions []reaction
+		wantPage  int // Page shown after the reactions, -1 if the message was never edited
+	}{
+		{"next", []reaction{{"1", pageNextEmoji}}, 1},
+		{"next without variation selector", []reaction{{"1", "▶"}}, 1},
+		{"next and back", []reaction{{"1", pageNextEmoji}, {"1", pagePrevEmoji}}, 0},
+		{"past the last page", []reaction{{"1", pageNextEmoji}, {"1", pageNextEmoji}, {"1", pageNextEmoji}}, 2},
+		{"before the first page", []reaction{{"1", pagePrevEmoji}}, -1},
+		{"someone else", []reaction{{"2", pageNextEmoji}}, -1},
+		{"other emoji", []reaction{{"1", "👍"}}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setForTest(t, &paginators, make(map[string]*paginator))
+			s := newFakeSession()
+			pages := []string{"page 1", "page 2", "page 3"}
+			if err := sendPaginated(s, "channel", "1", pages); err != nil {
+				t.Fatal(err)
+			}
+			if sent := s.lastMessage(t, "channel"); sent != pages[0] {
+				t.Fatalf("sent %q, want the first page", sent)
+			}
+			if len(s.reactions) != 2 {
+				t.Errorf("reactions added = %q, want both arrows", s.reactions)
+			}
+			messageID := ""
+			for id := range paginators {
+				messageID = id
+			}
+
+			for _, r := range tt.reactions {
+				flipPage(s, testReaction(r.userID, messageID, r.emoji))
+			}
+			content, edited := s.edits[messageID]
+			if tt.wantPage < 0 {
+				if edited {
+					t.Errorf("message edited to %q, want it left alone", content)
+				}
+				return
+			}
+			if content != pages[tt.wantPage] {
+				t.Errorf("message shows %q, want %q", content, pages[tt.wantPage])
+			}
+		})
+	}
+}
+
+func TestSendPaginatedSinglePage(t *testing.T) {
+	tests := []struct {
+		name  string
+		pages []string
+	}{
+		{"one page", []string{"page 1"}},
+		{"pages too long to flip", []string{string(make([]byte, maxMessageLength+1)), "page 2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setForTest(t, &paginators, make(map[string]*paginator))
+			s := newFakeSession()
+			if err := sendPaginated(s, "channel", "1", tt.pages); err != nil {
+				t.Fatal(err)
+			}
+			if len(paginators) != 0 || len(s.reactions) != 0 {
+				t.Errorf("%d paginators and reactions %q, want a plain reply", len(paginators), s.reactions)
+			}
+		})
+	}
+}
+
+// TestMyGamesPages checks that !mygames is paginated once a user has more games than fit on a page
+func TestMyGamesPages(t *testing.T) {
+	tests := []struct {
+		games     int
+		wantPages int
+	}{
+		{1, 1},
+		{myGamesPageSize, 1},
+		{myGamesPageSize + 1, 2},
+		{3 * myGamesPageSize, 3},
+	}
+	for _, tt := range tests {
+		t.Run(fmt.Sprint(tt.games), func(t *testing.T) {
+			store := newTestStore(t)
+			for i := 0; i < tt.games; i++ {
+				addSession(store, "1", fmt.Sprintf("Game %02d", i), time.Date(2024, 5, 1+i, 0, 0, 0, 0, time.UTC), time.Hour)
+			}
+			if pages := myGamesPages("guild", "1", "user1", "", myGamesPageSize); len(pages) != tt.wantPages {
+				t.Errorf("%d pages, want %d", len(pages), tt.wantPages)
+			}
+		})
+	}
+}
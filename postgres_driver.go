@@ -0,0 +1,9 @@
+//go:build postgres
+
+package main
+
+// Registers the PostgreSQL driver used by STORAGE_BACKEND=postgres. It is kept behind a build
+// tag so the default build doesn't link it:
+//
+//	go build -tags postgres
+import _ "github.com/jackc/pgx/v5/stdlib"
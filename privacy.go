@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// handleOptOut implements the !optout command: stop tracking the user and delete their data in every guild
+func handleOptOut(s messageSender, m *discordgo.MessageCreate, args string) {
+	userID := m.Author.ID
+	username := m.Author.Username
+
+	data.mu.Lock()
+	already := data.optedOut[userID]
+	data.optedOut[userID] = true
+	for guildID, users := range data.Guilds {
+		if _, ok := users[userID]; ok {
+			delete(users, userID)
+			data.invalidateTotalsLocked(guildID)
+		}
+	}
+	if err := data.saveLocked(); err != nil {
+		slog.Error("Error saving opt-out", "username", username, "error", err)
+	} else if err := data.scrubBackupLocked(); err != nil {
+		slog.Error("Error removing user from the data file backup", "username", username, "error", err)
+	}
+	data.mu.Unlock()
+	if _, err := deleteArchivedSessions(func(guildID, archivedUserID string, session GameSession) bool {
+		return archivedUserID != userID
+	}); err != nil {
+		slog.Error("Error deleting archived sessions", "username", username, "error", err)
+	}
+
+	if already {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you're already opted out. Use `%soptin` to be tracked again.", username, commandPrefix))
+		return
+	}
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I've deleted your data and won't track you anymore. Use `%soptin` if you change your mind.", username, commandPrefix))
+}
+
+// scrubBackupLocked saves the data a second time with the JSON backend, so the backup it keeps of
+// the previous data file no longer holds data that was just deleted. The caller must hold ds.mu.
+func (ds *DataStore) scrubBackupLocked() error {
+	if _, ok := ds.backend.(*jsonBackend); !ok {
+		return nil
+	}
+	return ds.saveLocked()
+}
+
+// handleOptIn implements the !optin command: resume tracking a user who opted out
+func handleOptIn(s messageSender, m *discordgo.MessageCreate, args string) {
+	userID := m.Author.ID
+	username := m.Author.Username
+
+	data.mu.Lock()
+	optedOut := data.optedOut[userID]
+	if optedOut {
+		delete(data.optedOut, userID)
+		if err := data.saveLocked(); err != nil {
+			slog.Error("Error saving opt-in", "username", username, "error", err)
+		}
+	}
+	data.mu.Unlock()
+
+	if !optedOut {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you're already being tracked!", username))
+		return
+	}
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, welcome back! I'll track your games again from your next presence update.", username))
+}
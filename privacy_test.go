@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestOptOutScrubsBackup(t *testing.T) {
+	store := newTestStore(t)
+	setForTest(t, &commandCooldown, 0)
+	path := filepath.Join(t.TempDir(), "game_data.json")
+	backend := &jsonBackend{path: path, backupPath: path + backupFileSuffix}
+	store.backend = backend
+
+	addSession(store, "1", "Minecraft", time.Now().Add(-2*time.Hour), time.Hour)
+	addSession(store, "2", "Tetris", time.Now().Add(-2*time.Hour), time.Hour)
+	// Two saves, so there is a backup holding both users
+	for i := 0; i < 2; i++ {
+		if err := store.save(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dispatchCommand(newFakeSession(), testMessage("1", "!optout"))
+
+	for _, file := range []string{backend.path, backend.backupPath} {
+		saved, _, err := readDataFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := saved.Guilds["guild"]["1"]; ok {
+			t.Errorf("%s still holds the opted out user", filepath.Base(file))
+		}
+		if _, ok := saved.Guilds["guild"]["2"]; !ok {
+			t.Errorf("%s lost the other user", filepath.Base(file))
+		}
+	}
+}
+
+// TestOptedOutCommandsStoreNothing checks that commands saving settings or sessions don't store a
+// user again after they opted out
+func TestOptedOutCommandsStoreNothing(t *testing.T) {
+	start := time.Now().Add(-3 * time.Hour).UTC()
+	export := fmt.Sprintf(`[{"game_name":"Minecraft","start_time":%q,"end_time":%q}]`, start.Format(time.RFC3339), start.Add(time.Hour).Format(time.RFC3339))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, export)
+	}))
+	defer server.Close()
+
+	tests := []string{"!import", "!budget 3h", "!remind 2h", "!notify on", "!goal set 10h", "!goal Minecraft 5h", "!format hours", "!settz Europe/Berlin"}
+	for _, content := range tests {
+		t.Run(content, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			s := newFakeSession()
+
+			dispatchCommand(s, testMessage("1", "!optout"))
+			m := testMessage("1", content)
+			m.Attachments = []*discordgo.MessageAttachment{{URL: server.URL, Size: len(export)}}
+			dispatchCommand(s, m)
+
+			if _, ok := store.Guilds["guild"]["1"]; ok {
+				t.Errorf("stored the opted out user again, replied %q", s.lastMessage(t, "channel"))
+			}
+		})
+	}
+}
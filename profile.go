@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	profileTopGames = 3        // Number of games shown by !profile
+	profileBarWidth = 10       // Length of the bar of the most played game in !profile
+	profileColor    = 0x5865F2 // Side color of the !profile embed
+)
+
+// handleProfile implements the !profile command: the user's key stats in one embed
+func handleProfile(s messageSender, m *discordgo.MessageCreate, args string) {
+	username := m.Author.Username
+
+	userData, ok := data.snapshotUser(m.GuildID, m.Author.ID)
+	if !ok || (len(userData.Sessions) == 0 && len(userData.ActiveGames) == 0 && len(userData.TrimmedTotals) == 0) {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I haven't tracked any games for you yet!", username))
+		return
+	}
+
+	now := time.Now()
+	location := userLocation(userData)
+	format := userDurationFormat(userData)
+	ranked := rankGames(gamePlayTimes(userData, now))
+	var total time.Duration
+	for _, game := range ranked {
+		total += game.duration
+	}
+
+	topGames := ""
+	for i, game := range ranked {
+		if i == profileTopGames {
+			break
+		}
+		filled := 0
+		if ranked[0].duration > 0 {
+			filled = int(float64(profileBarWidth) * float64(game.duration) / float64(ranked[0].duration))
+		}
+		bar := strings.Repeat("█", filled) + strings.Repeat("░", profileBarWidth-filled)
+		topGames += fmt.Sprintf("%d. **%s**: %s\n`%s`\n", i+1, sanitizeName(game.name), format(game.duration), bar)
+	}
+	if topGames == "" {
+		topGames = "Nothing yet"
+	}
+
+	longest, inProgress := longestSession(userData, now)
+	longestText := fmt.Sprintf("%s of **%s** on %s", format(time.Duration(longest.Duration)*time.Second), sanitizeName(longest.GameName), longest.StartTime.In(location).Format(dateFormat))
+	if inProgress {
+		longestText += ", still going"
+	}
+
+	current, _ := playStreaks(userData, now.In(location))
+
+	playingNow := "Nothing"
+	if len(userData.ActiveGames) > 0 {
+		playing := make([]string, 0, len(userData.ActiveGames))
+		for _, gameName := range sortedKeys(userData.ActiveGames) {
+			startTime := userData.ActiveGames[gameName]
+			played := max(capSessionEnd(startTime, now).Sub(activeStart(userData, gameName, startTime)), 0)
+			playing = append(playing, fmt.Sprintf("**%s** for %s", sanitizeName(gameName), format(played)))
+		}
+		playingNow = strings.Join(playing, "\n")
+	}
+
+	embed := &discordgo.MessageEmbed{
+		Title: fmt.Sprintf("%s's profile", username),
+		Color: profileColor,
+		Thumbnail: &discordgo.MessageEmbedThumbnail{
+			URL: m.Author.AvatarURL(""),
+		},
+		Fields: []*discordgo.MessageEmbedField{
+			{Name: "Total play time", Value: format(total), Inline: true},
+			{Name: "Games", Value: fmt.Sprint(len(ranked)), Inline: true},
+			{Name: "Current streak", Value: formatDays(current), Inline: true},
+			{Name: "Top games", Value: topGames},
+			{Name: "Longest session", Value: longestText},
+			{Name: "Playing now", Value: playingNow},
+		},
+	}
+	safeSendComplex(s, m.ChannelID, &discordgo.MessageSend{Embeds: []*discordgo.MessageEmbed{embed}})
+}
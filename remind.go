@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// handleRemind implements the !remind command: show, set or clear the break reminder, a DM sent
+// once a single session has gone on for longer than the user's threshold
+func handleRemind(s messageSender, m *discordgo.MessageCreate, args string) {
+	userID := m.Author.ID
+	username := m.Author.Username
+
+	if args == "" {
+		userData, ok := data.snapshotUser(m.GuildID, userID)
+		if !ok || userData.BreakReminder <= 0 {
+			sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you don't have a break reminder set. Use `%sremind 2h` to set one.", username, commandPrefix))
+			return
+		}
+		threshold := time.Duration(userData.BreakReminder) * time.Second
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I'll remind you to take a break after %s of playing without one.", username, formatDuration(threshold)))
+		return
+	}
+
+	var threshold time.Duration
+	if !strings.EqualFold(args, "off") {
+		var err error
+		threshold, err = parsePlayDuration(args)
+		if err != nil || threshold <= 0 {
+			sendUsage(s, m.ChannelID, "remind")
+			return
+		}
+	}
+
+	data.mu.Lock()
+	userData := data.getOrCreateUser(m.GuildID, userID)
+	userData.BreakReminder = threshold.Seconds()
+	userData.RemindedSessions = nil // Sessions already going get a reminder for the new threshold
+	if err := data.saveLocked(); err != nil {
+		slog.Error("Error saving break reminder", "username", username, "error", err)
+	}
+	data.mu.Unlock()
+
+	if threshold == 0 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, your break reminder has been removed.", username))
+		return
+	}
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I'll DM you a reminder to take a break when you've played for %s in one go.", username, formatDuration(threshold)))
+}
+
+// checkBreakReminders DMs users whose current session has gone on for longer than their break
+// reminder threshold. Sessions are identified by game and start time, so each one gets one reminder.
+func checkBreakReminders(s *discordgo.Session, now time.Time) {
+	reminders := make(map[string]string) // Key: User ID, one reminder per sweep even if in several guilds
+
+	data.mu.Lock()
+	for _, users := range data.Guilds {
+		for userID, userData := range users {
+			if userData.BreakReminder <= 0 {
+				continue
+			}
+			threshold := time.Duration(userData.BreakReminder) * time.Second
+
+			// Forget reminders of sessions that are over
+			for gameName, startTime := range userData.RemindedSessions {
+				if !userData.ActiveGames[gameName].Equal(startTime) {
+					delete(userData.RemindedSessions, gameName)
+					data.markDirtyLocked()
+				}
+			}
+
+			for gameName, startTime := range userData.ActiveGames {
+				played := now.Sub(startTime)
+				if played < threshold || userData.RemindedSessions[gameName].Equal(startTime) {
+					continue
+				}
+				if userData.RemindedSessions == nil {
+					userData.RemindedSessions = make(map[string]time.Time)
+				}
+				userData.RemindedSessions[gameName] = startTime
+				data.markDirtyLocked() // Persist so a restart doesn't remind again
+				reminders[userID] = fmt.Sprintf("You've been playing **%s** for %s. How about a short break?", sanitizeName(gameName), formatDuration(played))
+			}
+		}
+	}
+	data.mu.Unlock()
+
+	for userID, message := range reminders {
+		if err := sendDM(s, userID, message); err != nil {
+			slog.Warn("Could not send break reminder", "user_id", userID, "error", err)
+		}
+	}
+}
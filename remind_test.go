@@ -0,0 +1,30 @@
+package main
+
+import "testing"
+
+func TestRemindCommand(t *testing.T) {
+	tests := []struct {
+		content       string
+		wantThreshold float64
+	}{
+		{"!remind 2h", 2 * 3600},
+		{"!remind off", 0},
+		{"!remind OFF", 0},
+		{"!remind nonsense", 3600},
+	}
+	for _, tt := range tests {
+		t.Run(tt.content, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			store.mu.Lock()
+			store.getOrCreateUser("guild", "1").BreakReminder = 3600
+			store.mu.Unlock()
+
+			dispatchCommand(newFakeSession(), testMessage("1", tt.content))
+
+			if snapshot, _ := store.snapshotUser("guild", "1"); snapshot.BreakReminder != tt.wantThreshold {
+				t.Errorf("break reminder = %v, want %v", snapshot.BreakReminder, tt.wantThreshold)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log/slog"
+	"sort"
+	"time"
+)
+
+const retentionCheckInterval = time.Hour // How often old sessions are pruned
+
+// retentionDays is how many days of sessions are kept, configurable via DATA_RETENTION_DAYS.
+// 0 keeps everything.
+var retentionDays int
+
+// maxSessionsPerUser is how many sessions are kept per user and guild, configurable via
+// MAX_SESSIONS_PER_USER. Older sessions are folded into TrimmedTotals. 0 keeps every session.
+var maxSessionsPerUser int
+
+// retentionCutoff returns the time before which sessions are pruned
+func retentionCutoff(now time.Time) time.Time {
+	return now.AddDate(0, 0, -retentionDays)
+}
+
+// pruneOldSessions drops completed sessions that ended before cutoff and returns how many were
+// removed. Active sessions live in ActiveGames and are never pruned.
+func (ds *DataStore) pruneOldSessions(cutoff time.Time) int {
+	ds.mu.Lock()
+	removed := 0
+	for guildID, users := range ds.Guilds {
+		for _, userData := range users {
+			kept := userData.Sessions[:0]
+			for _, session := range userData.Sessions {
+				if session.EndTime.Before(cutoff) {
+					removed++
+					ds.invalidateTotalsLocked(guildID)
+					continue
+				}
+				kept = append(kept, session)
+			}
+			userData.Sessions = kept
+		}
+	}
+
+	if removed > 0 {
+		ds.markDirtyLocked()
+		slog.Info("Pruned sessions", "sessions", removed, "before", cutoff.Format(time.RFC3339))
+	}
+	ds.mu.Unlock()
+
+	// Archived sessions count towards TrimmedTotals, which loses them again
+	archived, err := deleteArchivedSessions(func(guildID, userID string, session GameSession) bool {
+		return !session.EndTime.Before(cutoff)
+	})
+	if err != nil {
+		slog.Error("Error pruning archived sessions", "error", err)
+	}
+	if n := archived.count(); n > 0 {
+		ds.mu.Lock()
+		ds.unfoldDeletedLocked(archived)
+		ds.mu.Unlock()
+		slog.Info("Pruned archived sessions", "sessions", n, "before", cutoff.Format(time.RFC3339))
+		removed += n
+	}
+	return removed
+}
+
+// runRetention prunes old sessions every retentionCheckInterval until stop is closed
+func runRetention(stop <-chan struct{}) {
+	ticker := time.NewTicker(retentionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case now := <-ticker.C:
+			data.pruneOldSessions(retentionCutoff(now))
+		}
+	}
+}
+
+// trimSessionsLocked drops a user's oldest sessions beyond maxSessionsPerUser, adding their play time
+// to TrimmedTotals so all-time totals stay the same, and returns how many were dropped. The caller
+// must hold ds.mu.
+func (ds *DataStore) trimSessionsLocked(guildID string, userData *UserGameData) int {
+	excess := len(userData.Sessions) - maxSessionsPerUser
+	if maxSessionsPerUser <= 0 || excess <= 0 {
+		return 0
+	}
+
+	// Sessions are recorded in order but imports can add older ones
+	sort.SliceStable(userData.Sessions, func(i, j int) bool {
+		return userData.Sessions[i].StartTime.Before(userData.Sessions[j].StartTime)
+	})
+	for _, session := range userData.Sessions[:excess] {
+		foldSession(userData, session)
+	}
+	userData.Sessions = append([]GameSession(nil), userData.Sessions[excess:]...)
+
+	ds.invalidateTotalsLocked(guildID)
+	ds.markDirtyLocked()
+	slog.Debug("Trimmed sessions beyond MAX_SESSIONS_PER_USER", "guild_id", guildID, "trimmed", excess)
+	return excess
+}
+
+// foldSession adds a session that is taken out of Sessions to TrimmedTotals and TrimmedCounts, so
+// totals and session counts still include it
+func foldSession(userData *UserGameData, session GameSession) {
+	if userData.TrimmedTotals == nil {
+		userData.TrimmedTotals = make(map[string]float64)
+	}
+	if userData.TrimmedCounts == nil {
+		userData.TrimmedCounts = make(map[string]int)
+	}
+	userData.TrimmedTotals[session.GameName] += session.Duration
+	userData.TrimmedCounts[session.GameName]++
+}
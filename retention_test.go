@@ -0,0 +1,182 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrimSessions(t *testing.T) {
+	tests := []struct {
+		name        string
+		max         int
+		sessions    int
+		wantTrimmed int
+	}{
+		{"no cap", 0, 5, 0},
+		{"under the cap", 10, 5, 0},
+		{"at the cap", 5, 5, 0},
+		{"over the cap", 3, 5, 2},
+		{"cap of one", 1, 5, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &maxSessionsPerUser, tt.max)
+			start := time.Now().Add(-100 * time.Hour)
+			for i := 0; i < tt.sessions; i++ {
+				game := "Minecraft"
+				if i%2 == 1 {
+					game = "Tetris"
+				}
+				addSession(store, "1", game, start.Add(time.Duration(i)*2*time.Hour), time.Duration(i+1)*time.Hour)
+			}
+
+			store.mu.Lock()
+			beforeTotals := store.guildTotalsLocked("guild")
+			userData := store.Guilds["guild"]["1"]
+			beforeTimes := gamePlayTimes(userData, time.Now())
+			beforeCounts := gameSessionCounts(userData)
+			oldest := userData.Sessions[0].StartTime
+			trimmed := store.trimSessionsLocked("guild", userData)
+			afterTotals := store.guildTotalsLocked("guild")
+			afterTimes := gamePlayTimes(userData, time.Now())
+			afterCounts := gameSessionCounts(userData)
+			kept := len(userData.Sessions)
+			firstKept := userData.Sessions[0].StartTime
+			store.mu.Unlock()
+
+			if trimmed != tt.wantTrimmed {
+				t.Errorf("trimmed %d sessions, want %d", trimmed, tt.wantTrimmed)
+			}
+			if kept != tt.sessions-tt.wantTrimmed {
+				t.Errorf("kept %d sessions, want %d", kept, tt.sessions-tt.wantTrimmed)
+			}
+			if tt.wantTrimmed > 0 && !firstKept.After(oldest) {
+				t.Errorf("the oldest session was kept")
+			}
+			for game, want := range beforeTimes {
+				if afterTimes[game] != want {
+					t.Errorf("total of %s = %v after trimming, want %v", game, afterTimes[game], want)
+				}
+				if afterCounts[game] != beforeCounts[game] {
+					t.Errorf("session count of %s = %d after trimming, want %d", game, afterCounts[game], beforeCounts[game])
+				}
+			}
+			if afterTotals.sessions != beforeTotals.sessions || afterTotals.seconds != beforeTotals.seconds {
+				t.Errorf("guild totals = %d sessions, %.0fs after trimming, want %d sessions, %.0fs", afterTotals.sessions, afterTotals.seconds, beforeTotals.sessions, beforeTotals.seconds)
+			}
+		})
+	}
+}
+
+// TestTrimmedMyGames checks that sessions trimmed as they are recorded still show in !mygames
+func TestTrimmedMyGames(t *testing.T) {
+	newTestStore(t)
+	setForTest(t, &commandCooldown, 0)
+	setForTest(t, &emptyActivityGrace, 0)
+	setForTest(t, &mergeWindow, 0)
+	setForTest(t, &maxSessionsPerUser, 2)
+	s := newFakeSession()
+
+	// Three sessions of an hour each, played through presence updates
+	for i := 0; i < 3; i++ {
+		handlePresence(s, testPresence("1", time.Now().Add(-time.Hour), "Minecraft"), time.Time{})
+		handlePresence(s, testPresence("1", time.Time{}), time.Time{})
+
+		// Move the session back a few days so the next one doesn't overlap it, and make it
+		// exactly an hour long
+		data.mu.Lock()
+		sessions := data.Guilds["guild"]["1"].Sessions
+		last := &sessions[len(sessions)-1]
+		last.StartTime = last.StartTime.AddDate(0, 0, i-3)
+		last.EndTime = last.StartTime.Add(time.Hour)
+		last.Duration = time.Hour.Seconds()
+		data.mu.Unlock()
+	}
+
+	userData, _ := data.snapshotUser("guild", "1")
+	if len(userData.Sessions) != 2 {
+		t.Errorf("%d sessions stored, want the cap of 2", len(userData.Sessions))
+	}
+
+	dispatchCommand(s, testMessage("1", "!mygames"))
+	reply := s.lastMessage(t, "channel")
+	for _, want := range []string{"**Minecraft**: 3h", "(3 sessions)"} {
+		if !strings.Contains(reply, want) {
+			t.Errorf("!mygames reply %q doesn't contain %q", reply, want)
+		}
+	}
+}
+
+// TestTrimmedOnlyGame checks that a game whose sessions were all trimmed can still be looked up
+func TestTrimmedOnlyGame(t *testing.T) {
+	store := newTestStore(t)
+	setForTest(t, &commandCooldown, 0)
+	setForTest(t, &maxSessionsPerUser, 1)
+	start := time.Now().Add(-10 * time.Hour)
+	addSession(store, "1", "Tetris", start, time.Hour)
+	addSession(store, "1", "Minecraft", start.Add(2*time.Hour), time.Hour)
+	store.mu.Lock()
+	store.trimSessionsLocked("guild", store.Guilds["guild"]["1"])
+	store.mu.Unlock()
+
+	userData, _ := store.snapshotUser("guild", "1")
+	if matches := findGame(userData, "tetris"); len(matches) != 1 || matches[0] != "Tetris" {
+		t.Errorf("findGame = %v, want Tetris", matches)
+	}
+
+	s := newFakeSession()
+	dispatchCommand(s, testMessage("1", "!gamestats tetris"))
+	reply := s.lastMessage(t, "channel")
+	for _, want := range []string{"Stats for **Tetris**", "Total play time: 1h", "Sessions: 1"} {
+		if !strings.Contains(reply, want) {
+			t.Errorf("!gamestats reply %q doesn't contain %q", reply, want)
+		}
+	}
+
+	dispatchCommand(s, testMessage("1", "!resetgame tetris"))
+	if reply := s.lastMessage(t, "channel"); !strings.Contains(reply, "reset your play time of **Tetris**") {
+		t.Errorf("!resetgame reply = %q, want the play time reset", reply)
+	}
+	userData, _ = store.snapshotUser("guild", "1")
+	if _, ok := userData.TrimmedTotals["Tetris"]; ok {
+		t.Error("Tetris is still in the trimmed totals after !resetgame")
+	}
+}
+
+// TestPruneOldSessions seeds old and recent sessions and checks that only the old ones are
+// removed, while active games and play time already folded into TrimmedTotals stay
+func TestPruneOldSessions(t *testing.T) {
+	store := newTestStore(t)
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -30)
+	addSession(store, "1", "Minecraft", now.AddDate(0, 0, -60), time.Hour)
+	addSession(store, "1", "Minecraft", now.AddDate(0, 0, -10), 2*time.Hour)
+	addSession(store, "2", "Tetris", now.AddDate(0, 0, -45), time.Hour)
+	store.mu.Lock()
+	userData := store.getOrCreateUser("guild", "2")
+	userData.ActiveGames["Tetris"] = now.AddDate(0, 0, -40)
+	userData.TrimmedTotals = map[string]float64{"Tetris": 3600}
+	userData.TrimmedCounts = map[string]int{"Tetris": 1}
+	store.mu.Unlock()
+
+	if removed := store.pruneOldSessions(cutoff); removed != 2 {
+		t.Errorf("pruned %d sessions, want 2", removed)
+	}
+
+	first, _ := store.snapshotUser("guild", "1")
+	if len(first.Sessions) != 1 || first.Sessions[0].Duration != 2*3600 {
+		t.Errorf("sessions of user 1 = %+v, want the recent one only", first.Sessions)
+	}
+	second, _ := store.snapshotUser("guild", "2")
+	if len(second.Sessions) != 0 {
+		t.Errorf("sessions of user 2 = %+v, want none", second.Sessions)
+	}
+	if _, ok := second.ActiveGames["Tetris"]; !ok {
+		t.Error("the active game was pruned")
+	}
+	if second.TrimmedTotals["Tetris"] != 3600 || second.TrimmedCounts["Tetris"] != 1 {
+		t.Errorf("trimmed totals = %v, %v, want them untouched", second.TrimmedTotals, second.TrimmedCounts)
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// slashCommand describes an application command handled by interactionCreate
+type slashCommand struct {
+	definition *discordgo.ApplicationCommand
+	handler    func(s *discordgo.Session, i *discordgo.InteractionCreate)
+}
+
+// slashCommands lists the application commands registered when the bot connects
+var slashCommands []slashCommand
+
+func init() {
+	typeChoices := make([]*discordgo.ApplicationCommandOptionChoice, 0, len(trackableActivityTypes))
+	for _, activityType := range trackableActivityTypes {
+		name := activityTypeNames[activityType]
+		typeChoices = append(typeChoices, &discordgo.ApplicationCommandOptionChoice{Name: name, Value: name})
+	}
+
+	slashCommands = []slashCommand{
+		{
+			definition: &discordgo.ApplicationCommand{
+				Name:        "mygames",
+				Description: "Show your total play time per game",
+				Options: []*discordgo.ApplicationCommandOption{
+					{
+						Type:        discordgo.ApplicationCommandOptionString,
+						Name:        "type",
+						Description: "Only show one activity type",
+						Choices:     typeChoices,
+					},
+				},
+			},
+			handler: handleSlashMyGames,
+		},
+		{
+			definition: &discordgo.ApplicationCommand{
+				Name:        "leaderboard",
+				Description: "Show the top players in this server, overall or for one game",
+			},
+			handler: handleSlashLeaderboard,
+		},
+	}
+
+	componentHandlers = map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate){
+		leaderboardGameSelectID: handleLeaderboardGameSelect,
+	}
+}
+
+// componentHandlers handle interactions with message components, keyed by the component's custom ID
+var componentHandlers map[string]func(s *discordgo.Session, i *discordgo.InteractionCreate)
+
+// registerSlashCommands creates the global application commands. Creating a command
+// that already exists updates it, so this is safe to run on every connect.
+func registerSlashCommands(s *discordgo.Session) {
+	for _, cmd := range slashCommands {
+		if _, err := s.ApplicationCommandCreate(s.State.User.ID, "", cmd.definition); err != nil {
+			slog.Error("Error registering slash command", "command", cmd.definition.Name, "error", err)
+		}
+	}
+}
+
+// interactionCreate dispatches slash commands and component interactions to their handlers
+func interactionCreate(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	if i.Type == discordgo.InteractionMessageComponent {
+		if handler, ok := componentHandlers[i.MessageComponentData().CustomID]; ok {
+			handler(s, i)
+		}
+		return
+	}
+	if i.Type != discordgo.InteractionApplicationCommand {
+		return
+	}
+	name := i.ApplicationCommandData().Name
+	for _, cmd := range slashCommands {
+		if cmd.definition.Name == name {
+			cmd.handler(s, i)
+			return
+		}
+	}
+}
+
+// handleSlashMyGames implements /mygames, replying only to the user who ran it
+func handleSlashMyGames(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	user := interactionUser(i)
+	if user == nil {
+		return
+	}
+
+	typeName := ""
+	for _, option := range i.ApplicationCommandData().Options {
+		if option.Name == "type" {
+			typeName = option.StringValue()
+		}
+	}
+
+	respondEphemeral(s, i, myGamesSummary(i.GuildID, user.ID, user.Username, typeName))
+}
+
+// interactionUser returns the user who triggered an interaction, set on Member in guilds and on User in DMs
+func interactionUser(i *discordgo.InteractionCreate) *discordgo.User {
+	if i.Member != nil && i.Member.User != nil {
+		return i.Member.User
+	}
+	return i.User
+}
+
+// respondEphemeral replies to an interaction with a message only its user can see
+func respondEphemeral(s *discordgo.Session, i *discordgo.InteractionCreate, content string) {
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{
+		Type: discordgo.InteractionResponseChannelMessageWithSource,
+		Data: &discordgo.InteractionResponseData{
+			Content: content,
+			Flags:   discordgo.MessageFlagsEphemeral,
+		},
+	})
+	if err != nil {
+		slog.Error("Error responding to interaction", "error", err)
+	}
+}
+
+const (
+	leaderboardGameSelectID = "leaderboard_game" // Custom ID of the /leaderboard game menu
+	leaderboardSize         = 10                 // Players shown by /leaderboard
+	leaderboardAllGames     = "*"                // Menu value for the leaderboard over all games
+	maxSelectOptions        = 25                 // Most options Discord allows in a select menu
+	maxSelectValueLength    = 100                // Longest value Discord allows for a select option
+)
+
+// handleSlashLeaderboard implements /leaderboard: the top players over all games, with a menu to
+// show the leaderboard of a single game instead
+func handleSlashLeaderboard(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	respondLeaderboard(s, i, discordgo.InteractionResponseChannelMessageWithSource, "")
+}
+
+// handleLeaderboardGameSelect re-renders a /leaderboard reply for the game picked in its menu
+func handleLeaderboardGameSelect(s *discordgo.Session, i *discordgo.InteractionCreate) {
+	gameName := ""
+	if values := i.MessageComponentData().Values; len(values) > 0 && values[0] != leaderboardAllGames {
+		gameName = values[0]
+	}
+	respondLeaderboard(s, i, discordgo.InteractionResponseUpdateMessage, gameName)
+}
+
+// respondLeaderboard replies to an interaction with the leaderboard of a game, or of all games if
+// gameName is empty, followed by the menu to pick a game
+func respondLeaderboard(s *discordgo.Session, i *discordgo.InteractionCreate, responseType discordgo.InteractionResponseType, gameName string) {
+	now := time.Now()
+
+	data.mu.Lock()
+	users := data.Guilds[i.GuildID]
+	games := rankGuildGames(users, func(userData *UserGameData) map[string]time.Duration {
+		return gamePlayTimes(userData, now)
+	})
+	type player struct {
+		userID   string
+		duration time.Duration
+	}
+	var players []player
+	for userID, userData := range users {
+		var total time.Duration
+		for name, duration := range gamePlayTimes(userData, now) {
+			if gameName == "" || name == gameName {
+				total += duration
+			}
+		}
+		if total > 0 {
+			players = append(players, player{userID, total})
+		}
+	}
+	data.mu.Unlock()
+
+	sort.Slice(players, func(a, b int) bool {
+		if players[a].duration != players[b].duration {
+			return players[a].duration > players[b].duration
+		}
+		return players[a].userID < players[b].userID
+	})
+	if len(players) > leaderboardSize {
+		players = players[:leaderboardSize]
+	}
+
+	var content string
+	switch {
+	case len(players) == 0 && gameName == "":
+		content = "I haven't tracked any games in this server yet!"
+	case len(players) == 0:
+		content = fmt.Sprintf("Nobody in this server has played **%s** yet.", sanitizeName(gameName))
+	case gameName == "":
+		content = "Top players in this server:\n"
+	default:
+		content = fmt.Sprintf("Top **%s** players in this server:\n", sanitizeName(gameName))
+	}
+	for rank, p := range players {
+		content += fmt.Sprintf("%d. <@%s>: %s\n", rank+1, p.userID, formatDuration(p.duration))
+	}
+
+	// The most played games fill the menu, Discord limits how many options and how long a value can be
+	options := []discordgo.SelectMenuOption{{Label: "All games", Value: leaderboardAllGames, Default: gameName == ""}}
+	for _, game := range games {
+		if len(options) == maxSelectOptions {
+			break
+		}
+		if len(game.name) > maxSelectValueLength {
+			continue
+		}
+		options = append(options, discordgo.SelectMenuOption{Label: game.name, Value: game.name, Default: game.name == gameName})
+	}
+
+	response := &discordgo.InteractionResponseData{
+		Content:         content,
+		Flags:           discordgo.MessageFlagsEphemeral,
+		AllowedMentions: &discordgo.MessageAllowedMentions{},
+	}
+	if len(games) > 0 {
+		response.Components = []discordgo.MessageComponent{
+			discordgo.ActionsRow{Components: []discordgo.MessageComponent{
+				discordgo.SelectMenu{CustomID: leaderboardGameSelectID, Placeholder: "Pick a game", Options: options},
+			}},
+		}
+	}
+	err := s.InteractionRespond(i.Interaction, &discordgo.InteractionResponse{Type: responseType, Data: response})
+	if err != nil {
+		slog.Error("Error responding to interaction", "command", "leaderboard", "error", err)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build sqlite
+
+package main
+
+// Registers the SQLite driver used by STORAGE_BACKEND=sqlite. It needs cgo and is kept behind
+// a build tag so the default build doesn't:
+//
+//	go build -tags sqlite
+import _ "github.com/mattn/go-sqlite3"
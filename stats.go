@@ -0,0 +1,571 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	dateFormat       = "Jan 2, 2006" // Format used when showing session dates to users
+	playtimeTopGames = 3             // Number of games shown by !playtime
+	recentSessions   = 10            // Number of sessions listed by !sessions
+)
+
+// handleGameStats implements the !gamestats command: detailed stats for one of the user's games
+func handleGameStats(s messageSender, m *discordgo.MessageCreate, args string) {
+	userID := m.Author.ID
+	username := m.Author.Username
+
+	query := strings.TrimSpace(args)
+	if query == "" {
+		sendUsage(s, m.ChannelID, "gamestats")
+		return
+	}
+
+	userData, ok := data.snapshotUser(m.GuildID, userID)
+	if !ok {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, no sessions found for **%s**.", username, sanitizeName(query)))
+		return
+	}
+	userData = withArchivedSessions(userData, m.GuildID, userID) // All-time stats reach into archived months
+	query, ok = resolveGame(s, m, userData, query)
+	if !ok {
+		return
+	}
+
+	format := userDurationFormat(userData)
+	gameName := ""
+	var count int
+	var total, longest time.Duration
+	var first, last time.Time
+	for _, session := range userData.Sessions {
+		if !strings.EqualFold(session.GameName, query) {
+			continue
+		}
+		gameName = session.GameName
+		duration := time.Duration(session.Duration) * time.Second
+		count++
+		total += duration
+		if duration > longest {
+			longest = duration
+		}
+		if first.IsZero() || session.StartTime.Before(first) {
+			first = session.StartTime
+		}
+		if session.StartTime.After(last) {
+			last = session.StartTime
+		}
+	}
+
+	// Time from a session still in progress counts towards the total
+	var current time.Duration
+	playing := false
+	now := time.Now()
+	for activeName, startTime := range userData.ActiveGames {
+		if strings.EqualFold(activeName, query) {
+			gameName = activeName
+			if start, end := activeStart(userData, activeName, startTime), capSessionEnd(startTime, now); end.After(start) {
+				current = end.Sub(start)
+			}
+			playing = true
+		}
+	}
+
+	// Sessions dropped by MAX_SESSIONS_PER_USER count towards the total and average only
+	var trimmed time.Duration
+	trimmedCount := 0
+	for trimmedName, seconds := range userData.TrimmedTotals {
+		if strings.EqualFold(trimmedName, query) {
+			if gameName == "" {
+				gameName = trimmedName
+			}
+			trimmed += time.Duration(seconds) * time.Second
+			trimmedCount += userData.TrimmedCounts[trimmedName]
+		}
+	}
+
+	if count == 0 && !playing && trimmed == 0 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, no sessions found for **%s**.", username, sanitizeName(query)))
+		return
+	}
+
+	response := fmt.Sprintf("Stats for **%s**, %s:\n", sanitizeName(gameName), username)
+	response += fmt.Sprintf("- Total play time: %s\n", format(total+trimmed+current))
+	if count+trimmedCount > 0 {
+		response += fmt.Sprintf("- Sessions: %d\n", count+trimmedCount)
+		response += fmt.Sprintf("- Average session: %s\n", format((total+trimmed)/time.Duration(count+trimmedCount)))
+	}
+	if count > 0 {
+		response += fmt.Sprintf("- Longest session: %s\n", format(longest))
+		location := userLocation(userData)
+		response += fmt.Sprintf("- First played: %s\n", first.In(location).Format(dateFormat))
+		response += fmt.Sprintf("- Last played: %s\n", last.In(location).Format(dateFormat))
+	}
+	if playing {
+		response += fmt.Sprintf("- Playing right now (%s so far)\n", format(current))
+	}
+
+	sendChunked(s, m.ChannelID, response)
+}
+
+// handleWeekly implements the !weekly command: the user's play time per game over the last 7 days
+func handleWeekly(s messageSender, m *discordgo.MessageCreate, args string) {
+	reportPlayTimes(s, m, "in the last 7 days", func(now time.Time) time.Time {
+		return now.AddDate(0, 0, -7)
+	})
+}
+
+// handleMonthly implements the !monthly command: what the user played this calendar month, in their timezone
+func handleMonthly(s messageSender, m *discordgo.MessageCreate, args string) {
+	reportPlayTimes(s, m, "this month", func(now time.Time) time.Time {
+		year, month, _ := now.Date()
+		return time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+	})
+}
+
+// reportPlayTimes replies with the user's play time per game from windowStart(now) until now, where
+// now is in the user's timezone. period describes the window in the reply, like "this month".
+func reportPlayTimes(s messageSender, m *discordgo.MessageCreate, period string, windowStart func(now time.Time) time.Time) {
+	username := m.Author.Username
+
+	var playTimes map[string]time.Duration
+	format := formatDuration
+	if userData, ok := data.snapshotUser(m.GuildID, m.Author.ID); ok {
+		format = userDurationFormat(userData)
+		now := time.Now().In(userLocation(userData))
+		playTimes = gamePlayTimesBetween(userData, windowStart(now), now)
+	}
+
+	if len(playTimes) == 0 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you haven't played anything %s!", username, period))
+		return
+	}
+
+	var periodTotal time.Duration
+	response := fmt.Sprintf("Here's what you played %s, %s:\n", period, username)
+	for _, total := range rankGames(playTimes) {
+		response += fmt.Sprintf("- **%s**: %s\n", sanitizeName(total.name), format(total.duration))
+		periodTotal += total.duration
+	}
+	response += fmt.Sprintf("**Total**: %s\n", format(periodTotal))
+
+	sendChunked(s, m.ChannelID, response)
+}
+
+// gamePlayTimesBetween calculates a user's play time per game within [from, to), counting only the
+// part of each session, including active ones, that falls inside the window
+func gamePlayTimesBetween(userData *UserGameData, from, to time.Time) map[string]time.Duration {
+	playTimes := make(map[string]time.Duration)
+	for _, session := range userData.Sessions {
+		if d := overlap(session.StartTime, session.EndTime, from, to); d > 0 {
+			playTimes[session.GameName] += d
+		}
+	}
+	for gameName, startTime := range userData.ActiveGames {
+		if d := overlap(activeStart(userData, gameName, startTime), capSessionEnd(startTime, to), from, to); d > 0 {
+			playTimes[gameName] += d
+		}
+	}
+	return playTimes
+}
+
+// handlePlaytime implements the !playtime command: another member's totals, for admins only
+// so members' play time isn't visible to everyone
+func handlePlaytime(s messageSender, m *discordgo.MessageCreate, args string) {
+	if !canAdminister(s, m) {
+		sendChunked(s, m.ChannelID, "Sorry, only admins can look up other members.")
+		return
+	}
+	if len(m.Mentions) != 1 {
+		sendUsage(s, m.ChannelID, "playtime")
+		return
+	}
+	target := m.Mentions[0]
+
+	// Durations are shown the way the admin asking prefers them
+	format := formatDuration
+	if userData, ok := data.snapshotUser(m.GuildID, m.Author.ID); ok {
+		format = userDurationFormat(userData)
+	}
+	var ranked []*gameTotal
+	if userData, ok := data.snapshotUser(m.GuildID, target.ID); ok {
+		ranked = rankGames(gamePlayTimes(userData, time.Now()))
+	}
+
+	if len(ranked) == 0 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("I haven't tracked any games for %s yet.", target.Username))
+		return
+	}
+
+	var total time.Duration
+	for _, game := range ranked {
+		total += game.duration
+	}
+	if len(ranked) > playtimeTopGames {
+		ranked = ranked[:playtimeTopGames]
+	}
+
+	response := fmt.Sprintf("%s has played %s in total. Top games:\n", target.Username, format(total))
+	for i, game := range ranked {
+		response += fmt.Sprintf("%d. **%s**: %s\n", i+1, sanitizeName(game.name), format(game.duration))
+	}
+	sendChunked(s, m.ChannelID, response)
+}
+
+// handleCompare implements the !compare command: play time of the games both users played, side by side
+func handleCompare(s messageSender, m *discordgo.MessageCreate, args string) {
+	if len(m.Mentions) != 1 {
+		sendUsage(s, m.ChannelID, "compare")
+		return
+	}
+	other := m.Mentions[0]
+	if other.ID == m.Author.ID {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, you can't compare yourself with yourself!", m.Author.Username))
+		return
+	}
+
+	now := time.Now()
+	var own, theirs map[string]time.Duration
+	format := formatDuration
+	if userData, ok := data.snapshotUser(m.GuildID, m.Author.ID); ok {
+		own = gamePlayTimes(userData, now)
+		format = userDurationFormat(userData)
+	}
+	if userData, ok := data.snapshotUser(m.GuildID, other.ID); ok {
+		theirs = gamePlayTimes(userData, now)
+	}
+
+	var ownTotal, theirTotal time.Duration
+	for _, duration := range own {
+		ownTotal += duration
+	}
+	for _, duration := range theirs {
+		theirTotal += duration
+	}
+
+	// Order the shared games by combined play time
+	shared := make(map[string]time.Duration)
+	for gameName, duration := range own {
+		if theirDuration, ok := theirs[gameName]; ok {
+			shared[gameName] = duration + theirDuration
+		}
+	}
+
+	response := fmt.Sprintf("**%s** vs **%s**\n", m.Author.Username, other.Username)
+	if len(shared) == 0 {
+		response += "You haven't played any of the same games yet.\n"
+	} else {
+		rows := fmt.Sprintf("%-24s %12s %12s\n", "Game", truncate(m.Author.Username, 12), truncate(other.Username, 12))
+		for _, game := range rankGames(shared) {
+			// Markdown isn't rendered in the code block, only a backtick could break out of it
+			name := strings.ReplaceAll(game.name, "`", "'")
+			rows += fmt.Sprintf("%-24s %12s %12s\n", truncate(name, 24), format(own[game.name]), format(theirs[game.name]))
+		}
+		response += "```\n" + rows + "```\n"
+	}
+
+	switch {
+	case ownTotal > theirTotal:
+		response += fmt.Sprintf("%s has played more overall: %s vs %s.", m.Author.Username, format(ownTotal), format(theirTotal))
+	case theirTotal > ownTotal:
+		response += fmt.Sprintf("%s has played more overall: %s vs %s.", other.Username, format(theirTotal), format(ownTotal))
+	default:
+		response += fmt.Sprintf("It's a tie at %s each!", format(ownTotal))
+	}
+	sendChunked(s, m.ChannelID, response)
+}
+
+// truncate shortens s to at most n runes so it fits in a table column
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// handleSessions implements the !sessions command: the user's most recent sessions, newest first
+func handleSessions(s messageSender, m *discordgo.MessageCreate, args string) {
+	username := m.Author.Username
+	query := strings.TrimSpace(args)
+
+	var sessions []GameSession
+	location := time.UTC
+	format := formatDuration
+	if userData, ok := data.snapshotUser(m.GuildID, m.Author.ID); ok {
+		location = userLocation(userData)
+		format = userDurationFormat(userData)
+		if query != "" {
+			if query, ok = resolveGame(s, m, userData, query); !ok {
+				return
+			}
+		}
+		for _, session := range userData.Sessions {
+			if query == "" || strings.EqualFold(session.GameName, query) {
+				sessions = append(sessions, session)
+			}
+		}
+	}
+
+	if len(sessions) == 0 {
+		if query != "" {
+			sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, no sessions found for **%s**.", username, sanitizeName(query)))
+		} else {
+			sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I haven't tracked any sessions for you yet!", username))
+		}
+		return
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].EndTime.After(sessions[j].EndTime)
+	})
+
+	response := fmt.Sprintf("Your most recent sessions, %s:\n", username)
+	shown := 0
+	for _, session := range sessions {
+		if shown == recentSessions {
+			break
+		}
+		line := fmt.Sprintf("- %s: **%s** for %s\n", session.StartTime.In(location).Format(dateFormat), sanitizeName(session.GameName), format(time.Duration(session.Duration)*time.Second))
+		// Leave room for the note about the sessions that don't fit
+		if len(response)+len(line) > maxMessageLength-50 {
+			break
+		}
+		response += line
+		shown++
+	}
+	if remaining := len(sessions) - shown; remaining > 0 {
+		response += fmt.Sprintf("...and %d more", remaining)
+	}
+	sendChunked(s, m.ChannelID, response)
+}
+
+// heatmapBarWidth is the length of the longest bar drawn by !heatmap
+const heatmapBarWidth = 20
+
+// handleHeatmap implements the !heatmap command: the user's play time by hour of day
+func handleHeatmap(s messageSender, m *discordgo.MessageCreate, args string) {
+	username := m.Author.Username
+
+	userData, ok := data.snapshotUser(m.GuildID, m.Author.ID)
+	if !ok {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I haven't tracked any games for you yet!", username))
+		return
+	}
+
+	now := time.Now().In(userLocation(userData))
+	var hours [24]time.Duration
+	for _, session := range userData.Sessions {
+		addToHourBuckets(&hours, session.StartTime, session.EndTime, now.Location())
+	}
+	for gameName, startTime := range userData.ActiveGames {
+		addToHourBuckets(&hours, activeStart(userData, gameName, startTime), capSessionEnd(startTime, now), now.Location())
+	}
+
+	var busiest time.Duration
+	for _, duration := range hours {
+		if duration > busiest {
+			busiest = duration
+		}
+	}
+	if busiest == 0 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I haven't tracked any games for you yet!", username))
+		return
+	}
+
+	format := userDurationFormat(userData)
+	rows := ""
+	for hour, duration := range hours {
+		bar := strings.Repeat("█", int(float64(heatmapBarWidth)*float64(duration)/float64(busiest)))
+		if bar == "" && duration > 0 {
+			bar = "▏" // Show that there was some play time in this hour
+		}
+		rows += fmt.Sprintf("%02d:00 %-*s %s\n", hour, heatmapBarWidth, bar, format(duration))
+	}
+	sendChunked(s, m.ChannelID, fmt.Sprintf("When you play, %s (hour of day, %s):\n```\n%s```", username, now.Format("MST"), rows))
+}
+
+// addToHourBuckets spreads the interval [start, end) over the hours of the day it covers in location
+func addToHourBuckets(hours *[24]time.Duration, start, end time.Time, location *time.Location) {
+	start = start.In(location)
+	first := time.Date(start.Year(), start.Month(), start.Day(), start.Hour(), 0, 0, 0, start.Location())
+	for hourStart := first; hourStart.Before(end); hourStart = hourStart.Add(time.Hour) {
+		hours[hourStart.Hour()] += overlap(start, end, hourStart, hourStart.Add(time.Hour))
+	}
+}
+
+// favoriteFlavors are the remarks !favorite picks from, %s is replaced by the game's name
+var favoriteFlavors = []string{
+	"You're practically married to %s!",
+	"%s should start paying you rent.",
+	"Somewhere, the %s developers are thanking you.",
+	"At this point %s is less a game and more a lifestyle.",
+	"Touch grass? Not while %s exists.",
+}
+
+// handleFavorite implements the !favorite command: the user's most played game
+func handleFavorite(s messageSender, m *discordgo.MessageCreate, args string) {
+	username := m.Author.Username
+
+	var ranked []*gameTotal
+	format := formatDuration
+	if userData, ok := data.snapshotUser(m.GuildID, m.Author.ID); ok {
+		format = userDurationFormat(userData)
+		ranked = rankGames(gamePlayTimes(userData, time.Now()))
+	}
+	if len(ranked) == 0 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I haven't tracked any games for you yet!", username))
+		return
+	}
+
+	favorite := ranked[0]
+	flavor := fmt.Sprintf(favoriteFlavors[rand.Intn(len(favoriteFlavors))], "**"+sanitizeName(favorite.name)+"**")
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, your favorite game is **%s** with %s played. %s", username, sanitizeName(favorite.name), format(favorite.duration), flavor))
+}
+
+// handleLongest implements the !longest command: the user's single longest session, including one in progress
+func handleLongest(s messageSender, m *discordgo.MessageCreate, args string) {
+	username := m.Author.Username
+
+	userData, ok := data.snapshotUser(m.GuildID, m.Author.ID)
+	if !ok {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I haven't tracked any sessions for you yet!", username))
+		return
+	}
+
+	longest, inProgress := longestSession(userData, time.Now())
+	if longest == nil {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I haven't tracked any sessions for you yet!", username))
+		return
+	}
+
+	format := userDurationFormat(userData)
+	duration := time.Duration(longest.Duration) * time.Second
+	response := fmt.Sprintf("Hey %s, your longest session is %s of **%s**, started on %s.", username, format(duration), sanitizeName(longest.GameName), longest.StartTime.In(userLocation(userData)).Format(dateFormat))
+	if inProgress {
+		response += " It's still going!"
+	}
+	sendChunked(s, m.ChannelID, response)
+}
+
+// longestSession returns the user's longest session and whether it is still in progress, counting
+// active games up to now. It returns nil if the user has no sessions.
+func longestSession(userData *UserGameData, now time.Time) (*GameSession, bool) {
+	var longest *GameSession
+	for i := range userData.Sessions {
+		if longest == nil || userData.Sessions[i].Duration > longest.Duration {
+			longest = &userData.Sessions[i]
+		}
+	}
+
+	// A session still going can already be the record
+	inProgress := false
+	for gameName, startTime := range userData.ActiveGames {
+		duration := capSessionEnd(startTime, now).Sub(startTime).Seconds()
+		if longest == nil || duration > longest.Duration {
+			longest = &GameSession{GameName: gameName, StartTime: startTime, EndTime: now, Duration: duration}
+			inProgress = true
+		}
+	}
+	return longest, inProgress
+}
+
+// handleStreak implements the !streak command: the user's current and longest run of consecutive days played
+func handleStreak(s messageSender, m *discordgo.MessageCreate, args string) {
+	username := m.Author.Username
+
+	userData, ok := data.snapshotUser(m.GuildID, m.Author.ID)
+	if !ok || (len(userData.Sessions) == 0 && len(userData.ActiveGames) == 0) {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I haven't tracked any games for you yet!", username))
+		return
+	}
+
+	now := time.Now().In(userLocation(userData))
+	current, best := playStreaks(userData, now)
+
+	response := fmt.Sprintf("Hey %s, your current streak is %s and your best is %s.", username, formatDays(current), formatDays(best))
+	if current == 0 {
+		response = fmt.Sprintf("Hey %s, you don't have a streak going right now. Your best is %s.", username, formatDays(best))
+	} else if current == best && best > 1 {
+		response += " You're on your best streak ever!"
+	}
+	sendChunked(s, m.ChannelID, response)
+}
+
+// playStreaks returns the user's current and longest streaks of days played in now's location.
+// A streak ending yesterday is still current, since today isn't over yet.
+func playStreaks(userData *UserGameData, now time.Time) (current, best int) {
+	played := make(map[time.Time]bool)
+	addDays := func(start, end time.Time) {
+		for day := startOfDay(start.In(now.Location())); day.Before(end); day = day.AddDate(0, 0, 1) {
+			played[day] = true
+		}
+	}
+	for _, session := range userData.Sessions {
+		addDays(session.StartTime, session.EndTime)
+	}
+	for gameName, startTime := range userData.ActiveGames {
+		addDays(activeStart(userData, gameName, startTime), capSessionEnd(startTime, now))
+	}
+
+	days := make([]time.Time, 0, len(played))
+	for day := range played {
+		days = append(days, day)
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+
+	run := 0
+	for i, day := range days {
+		if i > 0 && days[i-1].AddDate(0, 0, 1).Equal(day) {
+			run++
+		} else {
+			run = 1
+		}
+		if run > best {
+			best = run
+		}
+	}
+
+	today := startOfDay(now)
+	day := today
+	if !played[day] {
+		day = today.AddDate(0, 0, -1)
+	}
+	for played[day] {
+		current++
+		day = day.AddDate(0, 0, -1)
+	}
+	return current, best
+}
+
+// formatDays formats a number of days, like "1 day" or "5 days"
+func formatDays(days int) string {
+	if days == 1 {
+		return "1 day"
+	}
+	return fmt.Sprintf("%d days", days)
+}
+
+// handleWhenJoined implements the !whenjoined command: when the bot started tracking the user in this server
+func handleWhenJoined(s messageSender, m *discordgo.MessageCreate, args string) {
+	username := m.Author.Username
+
+	userData, ok := data.snapshotUser(m.GuildID, m.Author.ID)
+	if !ok || userData.FirstSeen.IsZero() {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I haven't tracked any games for you yet!", username))
+		return
+	}
+
+	firstSeen := userData.FirstSeen.In(userLocation(userData)).Format(dateFormat)
+	days := int(time.Since(userData.FirstSeen).Hours() / 24)
+	if days == 0 {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I've been tracking you since %s, less than a day ago.", username, firstSeen))
+		return
+	}
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I've been tracking you since %s, that's %s ago.", username, firstSeen, formatDays(days)))
+}
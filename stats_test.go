@@ -0,0 +1,121 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestActiveGameCapped checks that reports stop counting a game in progress at MAX_SESSION_HOURS
+func TestActiveGameCapped(t *testing.T) {
+	tests := []struct {
+		name        string
+		started     time.Duration // How long ago the active game started
+		recorded    time.Duration // Length of a session of it recorded since, 0 for none
+		wantSoFar   string
+		wantProfile string
+	}{
+		{"under the cap", time.Hour, 0, "(1h so far)", "**Minecraft** for 1h"},
+		{"over the cap", 10 * time.Hour, 0, "(2h so far)", "**Minecraft** for 2h"},
+		{"partly recorded", time.Hour, 30 * time.Minute, "(30m so far)", "**Minecraft** for 30m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			setForTest(t, &maxSessionDuration, 2*time.Hour)
+			start := time.Now().Add(-tt.started)
+			if tt.recorded > 0 {
+				addSession(store, "1", "Minecraft", start, tt.recorded)
+			}
+			store.mu.Lock()
+			store.getOrCreateUser("guild", "1").ActiveGames["Minecraft"] = start
+			store.mu.Unlock()
+			s := newFakeSession()
+
+			dispatchCommand(s, testMessage("1", "!gamestats minecraft"))
+			if reply := s.lastMessage(t, "channel"); !strings.Contains(reply, tt.wantSoFar) {
+				t.Errorf("!gamestats reply %q doesn't contain %q", reply, tt.wantSoFar)
+			}
+
+			dispatchCommand(s, testMessage("1", "!profile"))
+			s.mu.Lock()
+			embeds := s.sent[len(s.sent)-1].embeds
+			s.mu.Unlock()
+			found := false
+			for _, embed := range embeds {
+				for _, field := range embed.Fields {
+					if field.Name == "Playing now" {
+						found = true
+						if !strings.Contains(field.Value, tt.wantProfile) {
+							t.Errorf("!profile playing now = %q, want %q", field.Value, tt.wantProfile)
+						}
+					}
+				}
+			}
+			if !found {
+				t.Error("!profile has no playing now field")
+			}
+		})
+	}
+}
+
+func TestPlayStreaksCapsActiveGames(t *testing.T) {
+	setForTest(t, &maxSessionDuration, 2*time.Hour)
+	now := time.Date(2024, 6, 10, 20, 0, 0, 0, time.UTC)
+	userData := newUserGameData()
+	userData.ActiveGames["Minecraft"] = time.Date(2024, 6, 5, 12, 0, 0, 0, time.UTC)
+
+	// Only the first two hours count, so the days since aren't played
+	current, best := playStreaks(userData, now)
+	if current != 0 || best != 1 {
+		t.Errorf("streaks = %d current, %d best, want 0 and 1", current, best)
+	}
+}
+
+func TestHeatmapCapsActiveGames(t *testing.T) {
+	newTestStore(t)
+	setForTest(t, &commandCooldown, 0)
+	setForTest(t, &maxSessionDuration, time.Hour)
+	data.mu.Lock()
+	data.getOrCreateUser("guild", "1").ActiveGames["Minecraft"] = time.Now().Add(-10 * time.Hour)
+	data.mu.Unlock()
+	s := newFakeSession()
+
+	dispatchCommand(s, testMessage("1", "!heatmap"))
+	reply := s.lastMessage(t, "channel")
+	// An hour of play touches one or two hours of the day
+	rows := 0
+	for _, line := range strings.Split(reply, "\n") {
+		if strings.Contains(line, "█") || strings.Contains(line, "▏") {
+			rows++
+		}
+	}
+	if rows == 0 || rows > 2 {
+		t.Errorf("!heatmap shows play time in %d hours, want at most 2 of the capped hour:\n%s", rows, reply)
+	}
+}
+
+func TestSessionsEscapesQuery(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+	}{
+		{"bold", "**Minecraft**"},
+		{"code", "`Tetris`"},
+		{"underline", "__Doom__"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			s := newFakeSession()
+
+			dispatchCommand(s, testMessage("1", "!sessions "+tt.query))
+			want := "no sessions found for **" + sanitizeName(tt.query) + "**."
+			if reply := s.lastMessage(t, "channel"); !strings.Contains(reply, want) {
+				t.Errorf("reply = %q, want it to contain %q", reply, want)
+			}
+		})
+	}
+}
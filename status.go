@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const statusRotationInterval = 30 * time.Second // How long each status message is shown
+
+// statusMessages are the statuses the bot cycles through, configurable via STATUS_MESSAGES as a
+// list separated by semicolons. {active} is replaced by the number of games being played right now.
+var statusMessages = []string{
+	"Tracking your games!",
+	"Tracking {active} right now",
+	"{prefix}help for commands",
+}
+
+// parseStatusMessages reads a semicolon-separated list of status messages
+func parseStatusMessages(value string) ([]string, error) {
+	var messages []string
+	for _, message := range strings.Split(value, ";") {
+		if message = strings.TrimSpace(message); message != "" {
+			messages = append(messages, message)
+		}
+	}
+	if len(messages) == 0 {
+		return nil, fmt.Errorf("no status messages given")
+	}
+	return messages, nil
+}
+
+// runStatusRotation shows the next status message every statusRotationInterval until stop is closed.
+// The first message is set by ready.
+func runStatusRotation(s *discordgo.Session, stop <-chan struct{}) {
+	if len(statusMessages) < 2 && !strings.Contains(statusMessages[0], "{active}") {
+		return // Nothing would ever change
+	}
+
+	ticker := time.NewTicker(statusRotationInterval)
+	defer ticker.Stop()
+	next := 1 % len(statusMessages)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := s.UpdateGameStatus(0, renderStatus(statusMessages[next])); err != nil {
+				slog.Error("Error updating status", "error", err)
+			}
+			next = (next + 1) % len(statusMessages)
+		}
+	}
+}
+
+// renderStatus fills in the placeholders of a status message
+func renderStatus(message string) string {
+	if strings.Contains(message, "{active}") {
+		active := 0
+		data.mu.Lock()
+		for _, users := range data.Guilds {
+			for _, userData := range users {
+				active += len(userData.ActiveGames)
+			}
+		}
+		data.mu.Unlock()
+
+		games := fmt.Sprintf("%d games", active)
+		if active == 1 {
+			games = "1 game"
+		}
+		message = strings.ReplaceAll(message, "{active}", games)
+	}
+	return strings.ReplaceAll(message, "{prefix}", commandPrefix)
+}
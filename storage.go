@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// dataFileMode is the permission of the JSON data file, readable by everyone and writable by the bot only
+const dataFileMode = 0644
+
+// compressData makes the JSON backend gzip the data file, configurable via COMPRESS_DATA. Data
+// files whose name ends in .gz are always compressed.
+var compressData bool
+
+// storageBackend persists the data store. load returns the stored data together with
+// the time it was last saved, save replaces the stored data with a full snapshot.
+type storageBackend interface {
+	load() (persistedData, time.Time, error)
+	save(tempData persistedData) error
+	close() error
+}
+
+// sessionInserter is implemented by backends that can store one finished session
+// without rewriting everything else
+type sessionInserter interface {
+	insertSession(guildID, userID string, session GameSession) error
+}
+
+// saveTimeRecorder is implemented by backends that can record the time of a save on its own,
+// without rewriting the data, for autosaves while nothing but active games moved on
+type saveTimeRecorder interface {
+	recordSaveTime() error
+}
+
+// currentSchemaVersion is the version of the data file layout written by this build.
+// Bump it and add a step to migrate when the layout changes incompatibly.
+//   - 0: unversioned files, either a plain map of users or guild scoped
+//   - 1: adds the version field
+const currentSchemaVersion = 1
+
+// persistedData is the layout of the stored data
+type persistedData struct {
+	Version  int                                 `json:"version"`
+	Guilds   map[string]map[string]*UserGameData `json:"guilds"`              // Key: Guild ID, then User ID
+	OptedOut []string                            `json:"opted_out,omitempty"` // IDs of users who asked not to be tracked
+}
+
+// newStorageBackend creates the backend selected by STORAGE_BACKEND, "json" (the default), "sqlite",
+// "postgres" or "memory"
+func newStorageBackend(kind string) (storageBackend, error) {
+	switch strings.ToLower(strings.TrimSpace(kind)) {
+	case "", "json":
+		// Create the data file's directory so a fresh deployment can point anywhere
+		if err := os.MkdirAll(filepath.Dir(dataFilePath), 0755); err != nil {
+			return nil, fmt.Errorf("error creating data directory: %w", err)
+		}
+		return &jsonBackend{
+			path:       dataFilePath,
+			backupPath: dataFilePath + backupFileSuffix,
+			compress:   compressData || strings.HasSuffix(dataFilePath, ".gz"),
+		}, nil
+	case "sqlite":
+		return newSQLiteBackend(sqliteFilePath)
+	case "postgres":
+		return newPostgresBackend(os.Getenv("DATABASE_URL"))
+	case "memory":
+		slog.Warn("Using the in-memory storage backend, data is lost when the bot stops")
+		return newMemoryBackend(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", kind)
+	}
+}
+
+// checkDriver fails unless the database/sql driver a backend needs is compiled in. The drivers
+// are behind build tags, a default build can't use the database backends.
+func checkDriver(driverName, backend string) error {
+	if !slices.Contains(sql.Drivers(), driverName) {
+		return fmt.Errorf("STORAGE_BACKEND=%s needs a build with the %[1]s driver: go build -tags %[1]s", backend)
+	}
+	return nil
+}
+
+// jsonBackend stores all data in a single JSON file, rewritten on every save
+type jsonBackend struct {
+	path       string
+	backupPath string // Previous good copy of the file, used if the file can't be read
+	compress   bool   // Write the file gzip compressed, either format is read regardless
+}
+
+func (b *jsonBackend) load() (persistedData, time.Time, error) {
+	tempData, modTime, err := readDataFile(b.path)
+	if err != nil {
+		// The data file is missing or corrupt, fall back to the previous good copy
+		backupData, backupModTime, backupErr := readDataFile(b.backupPath)
+		if backupErr != nil {
+			if errors.Is(err, os.ErrNotExist) && errors.Is(backupErr, os.ErrNotExist) {
+				slog.Info("Data file does not exist, starting with empty data", "path", b.path)
+				// Not an error if file doesn't exist yet
+				return persistedData{Guilds: make(map[string]map[string]*UserGameData)}, time.Time{}, nil
+			}
+			return persistedData{}, time.Time{}, err
+		}
+		slog.Warn("Could not load data file, recovered data from backup", "path", b.path, "backup_path", b.backupPath, "error", err)
+		tempData, modTime = backupData, backupModTime
+	}
+	return tempData, modTime, nil
+}
+
+func (b *jsonBackend) save(tempData persistedData) error {
+	dataBytes, err := json.MarshalIndent(tempData, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshaling data: %w", err)
+	}
+	if b.compress {
+		var compressed bytes.Buffer
+		writer := gzip.NewWriter(&compressed)
+		if _, err := writer.Write(dataBytes); err != nil {
+			return fmt.Errorf("error compressing data: %w", err)
+		}
+		if err := writer.Close(); err != nil {
+			return fmt.Errorf("error compressing data: %w", err)
+		}
+		dataBytes = compressed.Bytes()
+	}
+
+	// Write to a temporary file in the same directory and rename it over the data file,
+	// so a crash mid-write never leaves a truncated data file behind
+	tmpFile, err := os.CreateTemp(filepath.Dir(b.path), filepath.Base(b.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating temporary data file for %s: %w", b.path, err)
+	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath) // Clean up if anything below fails, no-op after the rename
+
+	if _, err := tmpFile.Write(dataBytes); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("error writing data to file: %w", err)
+	}
+	if err := tmpFile.Sync(); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("error syncing data file: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("error closing data file: %w", err)
+	}
+	if err := os.Chmod(tmpPath, dataFileMode); err != nil {
+		return fmt.Errorf("error setting data file permissions: %w", err)
+	}
+
+	// Keep the previous good file as a backup before swapping in the new one
+	if err := os.Rename(b.path, b.backupPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error backing up data file: %w", err)
+	}
+	if err := os.Rename(tmpPath, b.path); err != nil {
+		return fmt.Errorf("error replacing data file %s: %w", b.path, err)
+	}
+	return nil
+}
+
+func (b *jsonBackend) close() error {
+	return nil
+}
+
+// readDataFile reads and decodes a data file, also returning its modification time
+func readDataFile(path string) (persistedData, time.Time, error) {
+	dataBytes, err := os.ReadFile(path)
+	if err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error reading data file %s: %w", path, err)
+	}
+
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+
+	// Compressed files are recognized by the gzip magic bytes, so COMPRESS_DATA can be switched freely
+	if bytes.HasPrefix(dataBytes, []byte{0x1f, 0x8b}) {
+		reader, err := gzip.NewReader(bytes.NewReader(dataBytes))
+		if err != nil {
+			return persistedData{}, time.Time{}, fmt.Errorf("error decompressing data file %s: %w", path, err)
+		}
+		dataBytes, err = io.ReadAll(reader)
+		if err != nil {
+			return persistedData{}, time.Time{}, fmt.Errorf("error decompressing data file %s: %w", path, err)
+		}
+	}
+
+	tempData, err := unmarshalData(dataBytes)
+	if err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading data file %s: %w", path, err)
+	}
+	return tempData, modTime, nil
+}
+
+// unmarshalData decodes the data file, migrating files written by older versions
+func unmarshalData(dataBytes []byte) (persistedData, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(dataBytes, &header); err != nil {
+		return persistedData{}, fmt.Errorf("error unmarshaling data: %w", err)
+	}
+	if header.Version > currentSchemaVersion {
+		return persistedData{}, fmt.Errorf("data file version %d is newer than supported version %d", header.Version, currentSchemaVersion)
+	}
+	return migrate(header.Version, dataBytes)
+}
+
+// migrate decodes a data file written with schema version fromVersion and upgrades it to
+// the current version
+func migrate(fromVersion int, raw []byte) (persistedData, error) {
+	var tempData persistedData
+	var err error
+	switch fromVersion {
+	case 0:
+		tempData, err = migrateUnversioned(raw)
+	case currentSchemaVersion:
+		if err = json.Unmarshal(raw, &tempData); err != nil {
+			err = fmt.Errorf("error unmarshaling data: %w", err)
+		}
+	default:
+		err = fmt.Errorf("unknown data file version %d", fromVersion)
+	}
+	if err != nil {
+		return persistedData{}, err
+	}
+	if tempData.Guilds == nil {
+		tempData.Guilds = make(map[string]map[string]*UserGameData)
+	}
+
+	if fromVersion != currentSchemaVersion {
+		slog.Info("Migrated data file", "from_version", fromVersion, "to_version", currentSchemaVersion)
+	}
+	tempData.Version = currentSchemaVersion
+	return tempData, nil
+}
+
+// migrateUnversioned decodes a file from before schema versions. Files written before guild
+// scoping are a plain map of user IDs, and their data is migrated to the legacy guild.
+func migrateUnversioned(dataBytes []byte) (persistedData, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(dataBytes, &raw); err != nil {
+		return persistedData{}, fmt.Errorf("error unmarshaling data: %w", err)
+	}
+
+	tempData := persistedData{Guilds: make(map[string]map[string]*UserGameData)}
+	if _, ok := raw["guilds"]; ok {
+		if err := json.Unmarshal(dataBytes, &tempData); err != nil {
+			return persistedData{}, fmt.Errorf("error unmarshaling data: %w", err)
+		}
+		return tempData, nil
+	}
+
+	// User IDs are numeric snowflakes, so a file without a "guilds" key is the legacy layout
+	legacyUsers := make(map[string]*UserGameData)
+	if err := json.Unmarshal(dataBytes, &legacyUsers); err != nil {
+		return persistedData{}, fmt.Errorf("error unmarshaling legacy data: %w", err)
+	}
+	if len(legacyUsers) > 0 {
+		slog.Info("Migrating users from the legacy data layout", "users", len(legacyUsers), "guild_id", legacyGuildID)
+		tempData.Guilds[legacyGuildID] = legacyUsers
+	}
+	return tempData, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// memoryBackend keeps the data in memory only, nothing survives a restart. It lets the tracking
+// logic run without touching disk or a database, for tests and throwaway instances.
+type memoryBackend struct {
+	mu      sync.Mutex
+	stored  []byte // The last save, encoded like the JSON data file so nothing is shared with the store
+	savedAt time.Time
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{}
+}
+
+func (b *memoryBackend) load() (persistedData, time.Time, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.stored == nil {
+		return persistedData{Version: currentSchemaVersion, Guilds: make(map[string]map[string]*UserGameData)}, time.Time{}, nil
+	}
+	tempData, err := unmarshalData(b.stored)
+	if err != nil {
+		return persistedData{}, time.Time{}, err
+	}
+	return tempData, b.savedAt, nil
+}
+
+func (b *memoryBackend) save(tempData persistedData) error {
+	dataBytes, err := json.Marshal(tempData)
+	if err != nil {
+		return fmt.Errorf("error marshaling data: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.stored = dataBytes
+	b.savedAt = time.Now()
+	return nil
+}
+
+func (b *memoryBackend) recordSaveTime() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.savedAt = time.Now()
+	return nil
+}
+
+func (b *memoryBackend) close() error {
+	return nil
+}
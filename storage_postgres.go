@@ -0,0 +1,443 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// postgresDriverName is the database/sql driver used for PostgreSQL. The driver itself is only
+// compiled in with the postgres build tag, see postgres_driver.go.
+const postgresDriverName = "pgx"
+
+// postgresMigrations creates and upgrades the schema, applied in order and recorded in
+// schema_migrations. Never edit an entry once released, append a new one instead.
+var postgresMigrations = []string{
+	`CREATE TABLE users (
+		guild_id TEXT NOT NULL,
+		user_id  TEXT NOT NULL,
+		settings JSONB NOT NULL DEFAULT '{}',
+		PRIMARY KEY (guild_id, user_id)
+	);
+	CREATE TABLE sessions (
+		id               BIGSERIAL PRIMARY KEY,
+		guild_id         TEXT NOT NULL,
+		user_id          TEXT NOT NULL,
+		game_name        TEXT NOT NULL,
+		start_time       TIMESTAMPTZ NOT NULL,
+		end_time         TIMESTAMPTZ NOT NULL,
+		duration_seconds DOUBLE PRECISION NOT NULL,
+		activity_type    TEXT NOT NULL DEFAULT '',
+		instance_id      TEXT NOT NULL DEFAULT '',
+		inserted_at      TIMESTAMPTZ NOT NULL DEFAULT now(),
+		UNIQUE (guild_id, user_id, game_name, start_time)
+	);
+	CREATE TABLE active_games (
+		guild_id   TEXT NOT NULL,
+		user_id    TEXT NOT NULL,
+		game_name  TEXT NOT NULL,
+		start_time TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (guild_id, user_id, game_name)
+	);
+	CREATE TABLE opted_out (
+		user_id TEXT PRIMARY KEY
+	);
+	CREATE TABLE meta (
+		key   TEXT PRIMARY KEY,
+		value TEXT NOT NULL
+	);`,
+	`ALTER TABLE sessions ADD COLUMN session_id TEXT NOT NULL DEFAULT '';`,
+	`ALTER TABLE active_games ADD COLUMN instance_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE active_games ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();
+	ALTER TABLE active_games DROP CONSTRAINT active_games_pkey;
+	ALTER TABLE active_games ADD PRIMARY KEY (guild_id, user_id, game_name, instance_id);`,
+	`ALTER TABLE users ADD COLUMN instance_id TEXT NOT NULL DEFAULT '';
+	ALTER TABLE users ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now();`,
+}
+
+// postgresBackend stores data in a PostgreSQL database that several bot instances can share.
+// Finished sessions are inserted as rows, and full saves only replace the sessions and active
+// games this instance knows about, so instances never overwrite each other's inserts. Instances
+// tracking the same member record each play once, see insertSessionRow.
+type postgresBackend struct {
+	db *sql.DB
+	// Identifies the rows this instance inserted, so a save can tell its own sessions
+	// from ones another instance inserted since this one loaded
+	instanceID string
+	// Database time of the last load. Sessions inserted before it were part of the loaded data.
+	loadedAt time.Time
+}
+
+// newPostgresBackend connects to the database at url and applies pending migrations
+func newPostgresBackend(url string) (*postgresBackend, error) {
+	if url == "" {
+		return nil, fmt.Errorf("DATABASE_URL must be set for the postgres storage backend")
+	}
+	if err := checkDriver(postgresDriverName, "postgres"); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(postgresDriverName, url)
+	if err != nil {
+		return nil, fmt.Errorf("error opening PostgreSQL database: %w", err)
+	}
+	if err := migratePostgres(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	idBytes := make([]byte, 8)
+	if _, err := rand.Read(idBytes); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error generating instance ID: %w", err)
+	}
+	return &postgresBackend{db: db, instanceID: hex.EncodeToString(idBytes)}, nil
+}
+
+// migratePostgres applies the migrations the database hasn't seen yet. The table lock makes
+// instances starting at the same time wait for each other instead of migrating twice.
+func migratePostgres(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting migration: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return fmt.Errorf("error creating migrations table: %w", err)
+	}
+	if _, err := tx.Exec(`LOCK TABLE schema_migrations IN EXCLUSIVE MODE`); err != nil {
+		return fmt.Errorf("error locking migrations table: %w", err)
+	}
+
+	var applied int
+	if err := tx.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&applied); err != nil {
+		return fmt.Errorf("error reading schema version: %w", err)
+	}
+	for version := applied + 1; version <= len(postgresMigrations); version++ {
+		if _, err := tx.Exec(postgresMigrations[version-1]); err != nil {
+			return fmt.Errorf("error applying migration %d: %w", version, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO schema_migrations (version) VALUES ($1)`, version); err != nil {
+			return fmt.Errorf("error recording migration %d: %w", version, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing migrations: %w", err)
+	}
+	return nil
+}
+
+func (b *postgresBackend) load() (persistedData, time.Time, error) {
+	if err := b.db.QueryRow(`SELECT now()`).Scan(&b.loadedAt); err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error reading database time: %w", err)
+	}
+
+	tempData := persistedData{Guilds: make(map[string]map[string]*UserGameData)}
+	user := func(guildID, userID string) *UserGameData {
+		users, ok := tempData.Guilds[guildID]
+		if !ok {
+			users = make(map[string]*UserGameData)
+			tempData.Guilds[guildID] = users
+		}
+		userData, ok := users[userID]
+		if !ok {
+			userData = newUserGameData()
+			users[userID] = userData
+		}
+		return userData
+	}
+
+	rows, err := b.db.Query(`SELECT guild_id, user_id, settings FROM users`)
+	if err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading users: %w", err)
+	}
+	for rows.Next() {
+		var guildID, userID string
+		var settings []byte
+		if err := rows.Scan(&guildID, &userID, &settings); err != nil {
+			rows.Close()
+			return persistedData{}, time.Time{}, fmt.Errorf("error loading users: %w", err)
+		}
+		userData := user(guildID, userID)
+		if err := json.Unmarshal(settings, userData); err != nil {
+			rows.Close()
+			return persistedData{}, time.Time{}, fmt.Errorf("error unmarshaling settings for user %s: %w", userID, err)
+		}
+		// The settings document never holds sessions, they live in their own tables
+		userData.Sessions = []GameSession{}
+		userData.ActiveGames = make(map[string]time.Time)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading users: %w", err)
+	}
+
+	rows, err = b.db.Query(`SELECT guild_id, user_id, game_name, start_time, end_time, duration_seconds, activity_type, session_id FROM sessions ORDER BY id`)
+	if err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading sessions: %w", err)
+	}
+	for rows.Next() {
+		var guildID, userID string
+		var session GameSession
+		if err := rows.Scan(&guildID, &userID, &session.GameName, &session.StartTime, &session.EndTime, &session.Duration, &session.ActivityType, &session.ID); err != nil {
+			rows.Close()
+			return persistedData{}, time.Time{}, fmt.Errorf("error loading sessions: %w", err)
+		}
+		if guildID != legacyGuildID {
+			session.GuildID = guildID
+		}
+		userData := user(guildID, userID)
+		userData.Sessions = append(userData.Sessions, session)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading sessions: %w", err)
+	}
+
+	rows, err = b.db.Query(`SELECT guild_id, user_id, game_name, start_time FROM active_games`)
+	if err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading active games: %w", err)
+	}
+	for rows.Next() {
+		var guildID, userID, gameName string
+		var startTime time.Time
+		if err := rows.Scan(&guildID, &userID, &gameName, &startTime); err != nil {
+			rows.Close()
+			return persistedData{}, time.Time{}, fmt.Errorf("error loading active games: %w", err)
+		}
+		// Each instance tracking the member has a row, the earliest start is when they began playing
+		activeGames := user(guildID, userID).ActiveGames
+		if previous, ok := activeGames[gameName]; !ok || startTime.Before(previous) {
+			activeGames[gameName] = startTime
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading active games: %w", err)
+	}
+
+	rows, err = b.db.Query(`SELECT user_id FROM opted_out`)
+	if err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading opted out users: %w", err)
+	}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return persistedData{}, time.Time{}, fmt.Errorf("error loading opted out users: %w", err)
+		}
+		tempData.OptedOut = append(tempData.OptedOut, userID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading opted out users: %w", err)
+	}
+
+	var savedAt time.Time
+	var value string
+	err = b.db.QueryRow(`SELECT value FROM meta WHERE key = 'saved_at'`).Scan(&value)
+	if err == nil {
+		savedAt, _ = time.Parse(time.RFC3339Nano, value)
+	} else if err != sql.ErrNoRows {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading save time: %w", err)
+	}
+	return tempData, savedAt, nil
+}
+
+// save writes the snapshot user by user. Each user's row is locked for the rest of the
+// transaction, and only sessions and active games that were loaded or written by this instance
+// are replaced, so those another instance wrote in the meantime survive.
+func (b *postgresBackend) save(tempData persistedData) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	// Lock users in a fixed order so two instances saving at once can't deadlock
+	for _, guildID := range sortedKeys(tempData.Guilds) {
+		users := tempData.Guilds[guildID]
+		for _, userID := range sortedKeys(users) {
+			userData := users[userID]
+			// Sessions and active games go into their own tables, keep them out of the settings
+			settings := *userData
+			settings.Sessions = nil
+			settings.ActiveGames = nil
+			settingsBytes, err := json.Marshal(settings)
+			if err != nil {
+				return fmt.Errorf("error marshaling settings for user %s: %w", userID, err)
+			}
+			// The upsert locks the user's row until the transaction ends
+			if _, err := tx.Exec(`INSERT INTO users (guild_id, user_id, settings, instance_id) VALUES ($1, $2, $3, $4)
+				ON CONFLICT (guild_id, user_id) DO UPDATE SET settings = excluded.settings, instance_id = excluded.instance_id, updated_at = now()`,
+				guildID, userID, string(settingsBytes), b.instanceID); err != nil {
+				return fmt.Errorf("error saving user %s: %w", userID, err)
+			}
+
+			if _, err := tx.Exec(`DELETE FROM sessions WHERE guild_id = $1 AND user_id = $2 AND (instance_id = $3 OR inserted_at <= $4)`,
+				guildID, userID, b.instanceID, b.loadedAt); err != nil {
+				return fmt.Errorf("error clearing sessions of user %s: %w", userID, err)
+			}
+			for _, session := range userData.Sessions {
+				if err := b.insertSessionRow(tx, guildID, userID, session); err != nil {
+					return err
+				}
+			}
+
+			if _, err := tx.Exec(`DELETE FROM active_games WHERE guild_id = $1 AND user_id = $2 AND (instance_id = $3 OR updated_at <= $4)`,
+				guildID, userID, b.instanceID, b.loadedAt); err != nil {
+				return fmt.Errorf("error clearing active games of user %s: %w", userID, err)
+			}
+			for gameName, startTime := range userData.ActiveGames {
+				if _, err := tx.Exec(`INSERT INTO active_games (guild_id, user_id, game_name, start_time, instance_id) VALUES ($1, $2, $3, $4, $5)
+					ON CONFLICT (guild_id, user_id, game_name, instance_id) DO UPDATE SET start_time = excluded.start_time, updated_at = now()`,
+					guildID, userID, gameName, startTime, b.instanceID); err != nil {
+					return fmt.Errorf("error saving active game: %w", err)
+				}
+			}
+		}
+	}
+
+	if err := b.deleteMissingUsers(tx, tempData.Guilds); err != nil {
+		return err
+	}
+
+	// Opted out users lose their data everywhere, including rows other instances wrote
+	if _, err := tx.Exec(`DELETE FROM opted_out`); err != nil {
+		return fmt.Errorf("error clearing opted out users: %w", err)
+	}
+	for _, userID := range tempData.OptedOut {
+		if _, err := tx.Exec(`INSERT INTO opted_out (user_id) VALUES ($1)`, userID); err != nil {
+			return fmt.Errorf("error saving opted out user %s: %w", userID, err)
+		}
+		for _, table := range []string{"users", "sessions", "active_games"} {
+			if _, err := tx.Exec(`DELETE FROM `+table+` WHERE user_id = $1`, userID); err != nil {
+				return fmt.Errorf("error deleting data of opted out user %s: %w", userID, err)
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`INSERT INTO meta (key, value) VALUES ('saved_at', $1) ON CONFLICT (key) DO UPDATE SET value = excluded.value`,
+		time.Now().Format(time.RFC3339Nano)); err != nil {
+		return fmt.Errorf("error saving save time: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// insertSession stores one finished session and removes the matching active game of every
+// instance. A session another instance already stored is skipped, see insertSessionRow.
+func (b *postgresBackend) insertSession(guildID, userID string, session GameSession) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	// Wait for a save or insert of the same user that is in progress. The row is created if it
+	// doesn't exist yet, so there is always one to lock.
+	if _, err := tx.Exec(`INSERT INTO users (guild_id, user_id, instance_id) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`, guildID, userID, b.instanceID); err != nil {
+		return fmt.Errorf("error adding user %s: %w", userID, err)
+	}
+	if _, err := tx.Exec(`SELECT 1 FROM users WHERE guild_id = $1 AND user_id = $2 FOR UPDATE`, guildID, userID); err != nil {
+		return fmt.Errorf("error locking user %s: %w", userID, err)
+	}
+	if err := b.insertSessionRow(tx, guildID, userID, session); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM active_games WHERE guild_id = $1 AND user_id = $2 AND game_name = $3`,
+		guildID, userID, session.GameName); err != nil {
+		return fmt.Errorf("error removing active game: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// deleteMissingUsers deletes the rows of users who are missing from the snapshot because they
+// were deleted, e.g. by !clearall. Like in save, rows another instance wrote since this one
+// loaded are kept.
+func (b *postgresBackend) deleteMissingUsers(tx *sql.Tx, guilds map[string]map[string]*UserGameData) error {
+	rows, err := tx.Query(`SELECT guild_id, user_id FROM users WHERE instance_id = $1 OR updated_at <= $2 ORDER BY guild_id, user_id`,
+		b.instanceID, b.loadedAt)
+	if err != nil {
+		return fmt.Errorf("error listing users: %w", err)
+	}
+	var missing [][2]string
+	for rows.Next() {
+		var guildID, userID string
+		if err := rows.Scan(&guildID, &userID); err != nil {
+			rows.Close()
+			return fmt.Errorf("error listing users: %w", err)
+		}
+		if _, ok := guilds[guildID][userID]; !ok {
+			missing = append(missing, [2]string{guildID, userID})
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error listing users: %w", err)
+	}
+
+	// Key: table, Value: column with the time a row was written
+	writtenAt := map[string]string{"users": "updated_at", "sessions": "inserted_at", "active_games": "updated_at"}
+	for _, key := range missing {
+		for _, table := range []string{"users", "sessions", "active_games"} {
+			if _, err := tx.Exec(`DELETE FROM `+table+` WHERE guild_id = $1 AND user_id = $2 AND (instance_id = $3 OR `+writtenAt[table]+` <= $4)`,
+				key[0], key[1], b.instanceID, b.loadedAt); err != nil {
+				return fmt.Errorf("error deleting data of user %s: %w", key[1], err)
+			}
+		}
+	}
+	return nil
+}
+
+func (b *postgresBackend) recordSaveTime() error {
+	if _, err := b.db.Exec(`INSERT INTO meta (key, value) VALUES ('saved_at', $1) ON CONFLICT (key) DO UPDATE SET value = excluded.value`,
+		time.Now().Format(time.RFC3339Nano)); err != nil {
+		return fmt.Errorf("error saving save time: %w", err)
+	}
+	return nil
+}
+
+func (b *postgresBackend) close() error {
+	return b.db.Close()
+}
+
+// sortedKeys returns the keys of a map in ascending order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// insertSessionRow stores a session unless another instance already stored one of the same game
+// overlapping it. Instances tracking the same member see the same play, but with start times
+// that differ by however far apart they noticed it, so the overlap tells their copies apart
+// where the exact start time doesn't. The caller must hold the lock on the user's row.
+func (b *postgresBackend) insertSessionRow(tx *sql.Tx, guildID, userID string, session GameSession) error {
+	// A session continued after a restart keeps its start time, so it replaces the row recorded at shutdown
+	_, err := tx.Exec(`INSERT INTO sessions (guild_id, user_id, game_name, start_time, end_time, duration_seconds, activity_type, instance_id, session_id)
+		SELECT $1::text, $2::text, $3::text, $4::timestamptz, $5::timestamptz, $6::double precision, $7::text, $8::text, $9::text
+		WHERE NOT EXISTS (SELECT 1 FROM sessions WHERE guild_id = $1 AND user_id = $2 AND game_name = $3
+			AND instance_id <> $8 AND start_time < $5 AND end_time > $4)
+		ON CONFLICT (guild_id, user_id, game_name, start_time) DO UPDATE
+		SET end_time = EXCLUDED.end_time, duration_seconds = EXCLUDED.duration_seconds, session_id = EXCLUDED.session_id
+		WHERE sessions.end_time < EXCLUDED.end_time`,
+		guildID, userID, session.GameName, session.StartTime, session.EndTime, session.Duration, session.ActivityType, b.instanceID, session.ID)
+	if err != nil {
+		return fmt.Errorf("error saving session: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+//go:build postgres
+
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestPostgresBackends connects n instances to the database in TEST_DATABASE_URL, emptied
+// first, and skips the test without one
+func newTestPostgresBackends(t *testing.T, n int) []*postgresBackend {
+	t.Helper()
+	url := os.Getenv("TEST_DATABASE_URL")
+	if url == "" {
+		t.Skip("TEST_DATABASE_URL is not set")
+	}
+	backends := make([]*postgresBackend, n)
+	for i := range backends {
+		backend, err := newPostgresBackend(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { backend.close() })
+		backends[i] = backend
+	}
+	if _, err := backends[0].db.Exec(`TRUNCATE users, sessions, active_games, opted_out, meta`); err != nil {
+		t.Fatal(err)
+	}
+	for _, backend := range backends {
+		if _, _, err := backend.load(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return backends
+}
+
+// testSnapshot is the data of one user in the test guild
+func testSnapshot(sessions []GameSession, activeGames map[string]time.Time) persistedData {
+	userData := newUserGameData()
+	userData.Sessions = sessions
+	for game, start := range activeGames {
+		userData.ActiveGames[game] = start
+	}
+	return persistedData{Version: currentSchemaVersion, Guilds: map[string]map[string]*UserGameData{"guild": {"1": userData}}}
+}
+
+func TestPostgresInstancesRecordPlayOnce(t *testing.T) {
+	start := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+	tests := []struct {
+		name         string
+		first        GameSession
+		second       GameSession
+		wantSessions int
+	}{
+		{"same start", newGameSession("Minecraft", start, start.Add(time.Hour)), newGameSession("Minecraft", start, start.Add(time.Hour)), 1},
+		{"noticed apart", newGameSession("Minecraft", start, start.Add(time.Hour)), newGameSession("Minecraft", start.Add(5*time.Second), start.Add(time.Hour+3*time.Second)), 1},
+		{"other game", newGameSession("Minecraft", start, start.Add(time.Hour)), newGameSession("Tetris", start, start.Add(time.Hour)), 2},
+		{"one after the other", newGameSession("Minecraft", start, start.Add(time.Hour)), newGameSession("Minecraft", start.Add(2*time.Hour), start.Add(3*time.Hour)), 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backends := newTestPostgresBackends(t, 2)
+			if err := backends[0].insertSession("guild", "1", tt.first); err != nil {
+				t.Fatal(err)
+			}
+			if err := backends[1].insertSession("guild", "1", tt.second); err != nil {
+				t.Fatal(err)
+			}
+			loaded, _, err := backends[0].load()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(loaded.Guilds["guild"]["1"].Sessions); got != tt.wantSessions {
+				t.Errorf("%d sessions stored, want %d", got, tt.wantSessions)
+			}
+		})
+	}
+}
+
+func TestPostgresSaveKeepsOtherInstances(t *testing.T) {
+	backends := newTestPostgresBackends(t, 2)
+	start := time.Now().Add(-3 * time.Hour).Truncate(time.Microsecond)
+
+	// The second instance sees a game start and a session end after the first one loaded
+	if err := backends[1].save(testSnapshot(nil, map[string]time.Time{"Tetris": start})); err != nil {
+		t.Fatal(err)
+	}
+	if err := backends[1].insertSession("guild", "1", newGameSession("Minecraft", start, start.Add(time.Hour))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first instance saves what it knows, which is neither
+	if err := backends[0].save(testSnapshot(nil, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, _, err := backends[0].load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userData := loaded.Guilds["guild"]["1"]
+	if len(userData.Sessions) != 1 {
+		t.Errorf("%d sessions after the other instance saved, want 1", len(userData.Sessions))
+	}
+	if got, ok := userData.ActiveGames["Tetris"]; !ok || !got.Equal(start) {
+		t.Errorf("active games after the other instance saved = %v, want Tetris since %v", userData.ActiveGames, start)
+	}
+}
+
+func TestPostgresActiveGamesOfBothInstances(t *testing.T) {
+	backends := newTestPostgresBackends(t, 2)
+	start := time.Now().Add(-time.Hour).Truncate(time.Microsecond)
+
+	if err := backends[0].save(testSnapshot(nil, map[string]time.Time{"Minecraft": start})); err != nil {
+		t.Fatal(err)
+	}
+	if err := backends[1].save(testSnapshot(nil, map[string]time.Time{"Minecraft": start.Add(5 * time.Second)})); err != nil {
+		t.Fatal(err)
+	}
+	loaded, _, err := backends[0].load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := loaded.Guilds["guild"]["1"].ActiveGames["Minecraft"]; !got.Equal(start) {
+		t.Errorf("Minecraft active since %v, want the earliest start %v", got, start)
+	}
+}
+
+func TestPostgresClearAllPersists(t *testing.T) {
+	checkClearAllPersists(t, newTestPostgresBackends(t, 1)[0])
+}
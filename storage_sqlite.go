@@ -0,0 +1,485 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// sqliteDriverName is the database/sql driver used for SQLite. The driver itself is only
+// compiled in with the sqlite build tag, see sqlite_driver.go.
+const sqliteDriverName = "sqlite3"
+
+// sqliteSchema creates the tables used by the SQLite backend. Per-user settings such as the
+// daily budget are stored as a JSON document so new settings don't need a schema change.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS users (
+	guild_id TEXT NOT NULL,
+	user_id  TEXT NOT NULL,
+	settings TEXT NOT NULL DEFAULT '{}',
+	PRIMARY KEY (guild_id, user_id)
+);
+CREATE TABLE IF NOT EXISTS sessions (
+	id               INTEGER PRIMARY KEY AUTOINCREMENT,
+	guild_id         TEXT NOT NULL,
+	user_id          TEXT NOT NULL,
+	game_name        TEXT NOT NULL,
+	start_time       TEXT NOT NULL,
+	end_time         TEXT NOT NULL,
+	duration_seconds REAL NOT NULL,
+	activity_type    TEXT NOT NULL DEFAULT '',
+	session_id       TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS sessions_user ON sessions (guild_id, user_id);
+CREATE TABLE IF NOT EXISTS active_games (
+	guild_id   TEXT NOT NULL,
+	user_id    TEXT NOT NULL,
+	game_name  TEXT NOT NULL,
+	start_time TEXT NOT NULL,
+	PRIMARY KEY (guild_id, user_id, game_name)
+);
+CREATE TABLE IF NOT EXISTS opted_out (
+	user_id TEXT PRIMARY KEY
+);
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+`
+
+// sqliteBackend stores sessions and active games as rows, so finishing a session
+// inserts one row instead of rewriting the whole dataset. Full saves only write the rows
+// that differ from what the database holds, which the backend keeps track of.
+type sqliteBackend struct {
+	db *sql.DB
+	// What the database holds as of the last load or write
+	users    map[sqliteUser]*sqliteRows
+	optedOut map[string]bool
+}
+
+// sqliteUser identifies the rows of a user in a guild
+type sqliteUser struct {
+	guildID, userID string
+}
+
+// sqliteSession is a session as stored in a row
+type sqliteSession struct {
+	gameName, startTime, endTime string
+	duration                     float64
+	activityType, sessionID      string
+}
+
+// sqliteRows is what the database holds for a user
+type sqliteRows struct {
+	settings    string
+	sessions    map[sqliteSession][]int64 // Row IDs, a session recorded twice has two rows
+	activeGames map[string]string         // Key: game name, Value: start time as stored
+}
+
+func newSQLiteSession(session GameSession) sqliteSession {
+	return sqliteSession{
+		gameName:     session.GameName,
+		startTime:    session.StartTime.Format(time.RFC3339Nano),
+		endTime:      session.EndTime.Format(time.RFC3339Nano),
+		duration:     session.Duration,
+		activityType: session.ActivityType,
+		sessionID:    session.ID,
+	}
+}
+
+// rows returns what the database holds for a user, creating an empty entry if needed
+func (b *sqliteBackend) rows(key sqliteUser) *sqliteRows {
+	rows, ok := b.users[key]
+	if !ok {
+		rows = &sqliteRows{sessions: make(map[sqliteSession][]int64), activeGames: make(map[string]string)}
+		b.users[key] = rows
+	}
+	return rows
+}
+
+// newSQLiteBackend opens the database at path and creates the schema if needed
+func newSQLiteBackend(path string) (*sqliteBackend, error) {
+	if err := checkDriver(sqliteDriverName, "sqlite"); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(sqliteDriverName, path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening SQLite database: %w", err)
+	}
+	// SQLite allows a single writer, serialize access through one connection
+	db.SetMaxOpenConns(1)
+
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating SQLite schema: %w", err)
+	}
+	// Databases created before activity types were tracked lack the column
+	if _, err := db.Exec(`ALTER TABLE sessions ADD COLUMN activity_type TEXT NOT NULL DEFAULT ''`); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+		db.Close()
+		return nil, fmt.Errorf("error migrating SQLite schema: %w", err)
+	}
+	// Databases created before sessions had IDs lack the column too
+	if _, err := db.Exec(`ALTER TABLE sessions ADD COLUMN session_id TEXT NOT NULL DEFAULT ''`); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+		db.Close()
+		return nil, fmt.Errorf("error migrating SQLite schema: %w", err)
+	}
+	return &sqliteBackend{db: db, users: make(map[sqliteUser]*sqliteRows), optedOut: make(map[string]bool)}, nil
+}
+
+func (b *sqliteBackend) load() (persistedData, time.Time, error) {
+	b.users = make(map[sqliteUser]*sqliteRows)
+	b.optedOut = make(map[string]bool)
+	tempData := persistedData{Guilds: make(map[string]map[string]*UserGameData)}
+	user := func(guildID, userID string) *UserGameData {
+		users, ok := tempData.Guilds[guildID]
+		if !ok {
+			users = make(map[string]*UserGameData)
+			tempData.Guilds[guildID] = users
+		}
+		userData, ok := users[userID]
+		if !ok {
+			userData = newUserGameData()
+			users[userID] = userData
+		}
+		return userData
+	}
+
+	rows, err := b.db.Query(`SELECT guild_id, user_id, settings FROM users`)
+	if err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading users: %w", err)
+	}
+	for rows.Next() {
+		var guildID, userID, settings string
+		if err := rows.Scan(&guildID, &userID, &settings); err != nil {
+			rows.Close()
+			return persistedData{}, time.Time{}, fmt.Errorf("error loading users: %w", err)
+		}
+		userData := user(guildID, userID)
+		if err := json.Unmarshal([]byte(settings), userData); err != nil {
+			rows.Close()
+			return persistedData{}, time.Time{}, fmt.Errorf("error unmarshaling settings for user %s: %w", userID, err)
+		}
+		// The settings document never holds sessions, they live in their own tables
+		userData.Sessions = []GameSession{}
+		userData.ActiveGames = make(map[string]time.Time)
+		b.rows(sqliteUser{guildID, userID}).settings = settings
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading users: %w", err)
+	}
+
+	rows, err = b.db.Query(`SELECT id, guild_id, user_id, game_name, start_time, end_time, duration_seconds, activity_type, session_id FROM sessions ORDER BY id`)
+	if err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading sessions: %w", err)
+	}
+	for rows.Next() {
+		var id int64
+		var guildID, userID, gameName, startTime, endTime, activityType, sessionID string
+		var duration float64
+		if err := rows.Scan(&id, &guildID, &userID, &gameName, &startTime, &endTime, &duration, &activityType, &sessionID); err != nil {
+			rows.Close()
+			return persistedData{}, time.Time{}, fmt.Errorf("error loading sessions: %w", err)
+		}
+		session := GameSession{GameName: gameName, Duration: duration, ActivityType: activityType, ID: sessionID}
+		if session.StartTime, err = time.Parse(time.RFC3339Nano, startTime); err == nil {
+			session.EndTime, err = time.Parse(time.RFC3339Nano, endTime)
+		}
+		if err != nil {
+			rows.Close()
+			return persistedData{}, time.Time{}, fmt.Errorf("error parsing session time: %w", err)
+		}
+		// The guild is stored with every row, sessions from before guild scoping have none
+		if guildID != legacyGuildID {
+			session.GuildID = guildID
+		}
+		userData := user(guildID, userID)
+		userData.Sessions = append(userData.Sessions, session)
+		stored := b.rows(sqliteUser{guildID, userID})
+		row := sqliteSession{gameName, startTime, endTime, duration, activityType, sessionID}
+		stored.sessions[row] = append(stored.sessions[row], id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading sessions: %w", err)
+	}
+
+	rows, err = b.db.Query(`SELECT guild_id, user_id, game_name, start_time FROM active_games`)
+	if err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading active games: %w", err)
+	}
+	for rows.Next() {
+		var guildID, userID, gameName, startTime string
+		if err := rows.Scan(&guildID, &userID, &gameName, &startTime); err != nil {
+			rows.Close()
+			return persistedData{}, time.Time{}, fmt.Errorf("error loading active games: %w", err)
+		}
+		start, err := time.Parse(time.RFC3339Nano, startTime)
+		if err != nil {
+			rows.Close()
+			return persistedData{}, time.Time{}, fmt.Errorf("error parsing active game time: %w", err)
+		}
+		user(guildID, userID).ActiveGames[gameName] = start
+		b.rows(sqliteUser{guildID, userID}).activeGames[gameName] = startTime
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading active games: %w", err)
+	}
+
+	rows, err = b.db.Query(`SELECT user_id FROM opted_out`)
+	if err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading opted out users: %w", err)
+	}
+	for rows.Next() {
+		var userID string
+		if err := rows.Scan(&userID); err != nil {
+			rows.Close()
+			return persistedData{}, time.Time{}, fmt.Errorf("error loading opted out users: %w", err)
+		}
+		tempData.OptedOut = append(tempData.OptedOut, userID)
+		b.optedOut[userID] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading opted out users: %w", err)
+	}
+
+	var savedAt time.Time
+	var value string
+	err = b.db.QueryRow(`SELECT value FROM meta WHERE key = 'saved_at'`).Scan(&value)
+	if err == nil {
+		savedAt, _ = time.Parse(time.RFC3339Nano, value)
+	} else if err != sql.ErrNoRows {
+		return persistedData{}, time.Time{}, fmt.Errorf("error loading save time: %w", err)
+	}
+	return tempData, savedAt, nil
+}
+
+// save writes the rows that differ between the snapshot and the database. The data is only
+// compared with what this backend loaded and wrote, so it must load before it saves.
+func (b *sqliteBackend) save(tempData persistedData) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	// The new state only replaces the old one once committed, a failed save leaves the database as it was
+	users := make(map[sqliteUser]*sqliteRows)
+	for guildID, guildUsers := range tempData.Guilds {
+		for userID, userData := range guildUsers {
+			key := sqliteUser{guildID, userID}
+			rows, err := saveSQLiteUser(tx, key, userData, b.users[key])
+			if err != nil {
+				return err
+			}
+			users[key] = rows
+		}
+	}
+	// Users missing from the snapshot were deleted
+	for key := range b.users {
+		if _, ok := users[key]; ok {
+			continue
+		}
+		for _, table := range []string{"users", "sessions", "active_games"} {
+			if _, err := tx.Exec(`DELETE FROM `+table+` WHERE guild_id = ? AND user_id = ?`, key.guildID, key.userID); err != nil {
+				return fmt.Errorf("error deleting data of user %s: %w", key.userID, err)
+			}
+		}
+	}
+
+	optedOut := make(map[string]bool, len(tempData.OptedOut))
+	for _, userID := range tempData.OptedOut {
+		optedOut[userID] = true
+		if b.optedOut[userID] {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO opted_out (user_id) VALUES (?)`, userID); err != nil {
+			return fmt.Errorf("error saving opted out user %s: %w", userID, err)
+		}
+	}
+	for userID := range b.optedOut {
+		if optedOut[userID] {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM opted_out WHERE user_id = ?`, userID); err != nil {
+			return fmt.Errorf("error removing opted out user %s: %w", userID, err)
+		}
+	}
+
+	if err := touchSavedAt(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	b.users = users
+	b.optedOut = optedOut
+	return nil
+}
+
+// saveSQLiteUser writes the rows of a user that differ from stored, what the database held
+// before, and returns what it holds afterwards. stored is nil for a user without rows.
+func saveSQLiteUser(tx *sql.Tx, key sqliteUser, userData *UserGameData, stored *sqliteRows) (*sqliteRows, error) {
+	if stored == nil {
+		stored = &sqliteRows{}
+	}
+	rows := &sqliteRows{sessions: make(map[sqliteSession][]int64), activeGames: make(map[string]string)}
+
+	// Sessions and active games go into their own tables, keep them out of the settings
+	settings := *userData
+	settings.Sessions = nil
+	settings.ActiveGames = nil
+	settingsBytes, err := json.Marshal(settings)
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling settings for user %s: %w", key.userID, err)
+	}
+	rows.settings = string(settingsBytes)
+	if rows.settings != stored.settings {
+		if _, err := tx.Exec(`INSERT INTO users (guild_id, user_id, settings) VALUES (?, ?, ?)
+			ON CONFLICT (guild_id, user_id) DO UPDATE SET settings = excluded.settings`,
+			key.guildID, key.userID, rows.settings); err != nil {
+			return nil, fmt.Errorf("error saving user %s: %w", key.userID, err)
+		}
+	}
+
+	// Keep the rows of sessions that are still there, insert the new ones and delete the rest
+	unused := make(map[sqliteSession][]int64, len(stored.sessions))
+	for row, ids := range stored.sessions {
+		unused[row] = ids
+	}
+	for _, session := range userData.Sessions {
+		row := newSQLiteSession(session)
+		if ids := unused[row]; len(ids) > 0 {
+			rows.sessions[row] = append(rows.sessions[row], ids[0])
+			unused[row] = ids[1:]
+			continue
+		}
+		id, err := insertSessionRow(tx, key.guildID, key.userID, session)
+		if err != nil {
+			return nil, err
+		}
+		rows.sessions[row] = append(rows.sessions[row], id)
+	}
+	for _, ids := range unused {
+		for _, id := range ids {
+			if _, err := tx.Exec(`DELETE FROM sessions WHERE id = ?`, id); err != nil {
+				return nil, fmt.Errorf("error deleting session: %w", err)
+			}
+		}
+	}
+
+	for gameName, startTime := range userData.ActiveGames {
+		start := startTime.Format(time.RFC3339Nano)
+		rows.activeGames[gameName] = start
+		if stored.activeGames[gameName] == start {
+			continue
+		}
+		if _, err := tx.Exec(`INSERT INTO active_games (guild_id, user_id, game_name, start_time) VALUES (?, ?, ?, ?)
+			ON CONFLICT (guild_id, user_id, game_name) DO UPDATE SET start_time = excluded.start_time`,
+			key.guildID, key.userID, gameName, start); err != nil {
+			return nil, fmt.Errorf("error saving active game: %w", err)
+		}
+	}
+	for gameName := range stored.activeGames {
+		if _, ok := userData.ActiveGames[gameName]; ok {
+			continue
+		}
+		if _, err := tx.Exec(`DELETE FROM active_games WHERE guild_id = ? AND user_id = ? AND game_name = ?`,
+			key.guildID, key.userID, gameName); err != nil {
+			return nil, fmt.Errorf("error removing active game: %w", err)
+		}
+	}
+	return rows, nil
+}
+
+// insertSession stores one finished session and removes the matching active game
+func (b *sqliteBackend) insertSession(guildID, userID string, session GameSession) error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	// A session continued after a restart replaces the row recorded for it at shutdown
+	if session.ID != "" {
+		if _, err := tx.Exec(`DELETE FROM sessions WHERE session_id = ?`, session.ID); err != nil {
+			return fmt.Errorf("error replacing session: %w", err)
+		}
+	}
+	id, err := insertSessionRow(tx, guildID, userID, session)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`DELETE FROM active_games WHERE guild_id = ? AND user_id = ? AND game_name = ?`,
+		guildID, userID, session.GameName); err != nil {
+		return fmt.Errorf("error removing active game: %w", err)
+	}
+	if err := touchSavedAt(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+
+	stored := b.rows(sqliteUser{guildID, userID})
+	if session.ID != "" {
+		for row := range stored.sessions {
+			if row.sessionID == session.ID {
+				delete(stored.sessions, row)
+			}
+		}
+	}
+	row := newSQLiteSession(session)
+	stored.sessions[row] = append(stored.sessions[row], id)
+	delete(stored.activeGames, session.GameName)
+	return nil
+}
+
+func (b *sqliteBackend) recordSaveTime() error {
+	tx, err := b.db.Begin()
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+	defer tx.Rollback() // No-op once committed
+
+	if err := touchSavedAt(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+func (b *sqliteBackend) close() error {
+	return b.db.Close()
+}
+
+// insertSessionRow stores a session and returns the ID of its row
+func insertSessionRow(tx *sql.Tx, guildID, userID string, session GameSession) (int64, error) {
+	result, err := tx.Exec(`INSERT INTO sessions (guild_id, user_id, game_name, start_time, end_time, duration_seconds, activity_type, session_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		guildID, userID, session.GameName,
+		session.StartTime.Format(time.RFC3339Nano), session.EndTime.Format(time.RFC3339Nano), session.Duration, session.ActivityType, session.ID)
+	if err != nil {
+		return 0, fmt.Errorf("error saving session: %w", err)
+	}
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("error saving session: %w", err)
+	}
+	return id, nil
+}
+
+// touchSavedAt records the current time as the last save, used to close sessions after downtime
+func touchSavedAt(tx *sql.Tx) error {
+	_, err := tx.Exec(`INSERT INTO meta (key, value) VALUES ('saved_at', ?) ON CONFLICT (key) DO UPDATE SET value = excluded.value`,
+		time.Now().Format(time.RFC3339Nano))
+	if err != nil {
+		return fmt.Errorf("error saving save time: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+//go:build sqlite
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newTestSQLiteBackend opens the database at path, by default a new one in a temporary
+// directory, and loads it
+func newTestSQLiteBackend(t *testing.T, path string) *sqliteBackend {
+	t.Helper()
+	if path == "" {
+		path = filepath.Join(t.TempDir(), "game_data.db")
+	}
+	backend, err := newSQLiteBackend(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.close() })
+	if _, _, err := backend.load(); err != nil {
+		t.Fatal(err)
+	}
+	return backend
+}
+
+// sessionRowIDs returns the row ID of each stored session, keyed by game name
+func sessionRowIDs(t *testing.T, backend *sqliteBackend) map[string]int64 {
+	t.Helper()
+	rows, err := backend.db.Query(`SELECT id, game_name FROM sessions`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	ids := make(map[string]int64)
+	for rows.Next() {
+		var id int64
+		var gameName string
+		if err := rows.Scan(&id, &gameName); err != nil {
+			t.Fatal(err)
+		}
+		ids[gameName] = id
+	}
+	return ids
+}
+
+// TestSQLiteSaveWritesChanges checks that a save keeps the rows of unchanged sessions and only
+// inserts and deletes the ones that changed
+func TestSQLiteSaveWritesChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game_data.db")
+	backend := newTestSQLiteBackend(t, path)
+	start := time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+	minecraft := newGameSession("Minecraft", start, start.Add(time.Hour))
+	tetris := newGameSession("Tetris", start.Add(2*time.Hour), start.Add(3*time.Hour))
+	snapshot := func(sessions ...GameSession) persistedData {
+		userData := newUserGameData()
+		userData.Sessions = sessions
+		userData.ActiveGames["Elden Ring"] = start.Add(4 * time.Hour)
+		return persistedData{Version: currentSchemaVersion, Guilds: map[string]map[string]*UserGameData{"guild": {"1": userData}}}
+	}
+
+	if err := backend.save(snapshot(minecraft)); err != nil {
+		t.Fatal(err)
+	}
+	before := sessionRowIDs(t, backend)
+	if err := backend.save(snapshot(minecraft, tetris)); err != nil {
+		t.Fatal(err)
+	}
+	after := sessionRowIDs(t, backend)
+	if after["Minecraft"] != before["Minecraft"] {
+		t.Errorf("Minecraft row rewritten by a save that didn't change it, ID %d became %d", before["Minecraft"], after["Minecraft"])
+	}
+	if _, ok := after["Tetris"]; !ok {
+		t.Error("Tetris not saved")
+	}
+
+	if err := backend.save(snapshot(tetris)); err != nil {
+		t.Fatal(err)
+	}
+	if ids := sessionRowIDs(t, backend); len(ids) != 1 || ids["Tetris"] != after["Tetris"] {
+		t.Errorf("rows after removing Minecraft = %v, want only Tetris's row %d", ids, after["Tetris"])
+	}
+
+	loaded, _, err := newTestSQLiteBackend(t, path).load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	userData := loaded.Guilds["guild"]["1"]
+	if len(userData.Sessions) != 1 || userData.Sessions[0].GameName != "Tetris" || len(userData.ActiveGames) != 1 {
+		t.Errorf("loaded sessions %+v and active games %v, want Tetris and Elden Ring", userData.Sessions, userData.ActiveGames)
+	}
+}
+
+// TestSQLiteInsertThenSave checks that a save after inserting a session doesn't store it again
+func TestSQLiteInsertThenSave(t *testing.T) {
+	backend := newTestSQLiteBackend(t, "")
+	start := time.Date(2024, 5, 1, 18, 0, 0, 0, time.UTC)
+	session := newGameSession("Minecraft", start, start.Add(time.Hour))
+	session.ID = newSessionID()
+	if err := backend.insertSession("guild", "1", session); err != nil {
+		t.Fatal(err)
+	}
+
+	userData := newUserGameData()
+	userData.Sessions = []GameSession{session}
+	if err := backend.save(persistedData{Version: currentSchemaVersion, Guilds: map[string]map[string]*UserGameData{"guild": {"1": userData}}}); err != nil {
+		t.Fatal(err)
+	}
+	var count int
+	if err := backend.db.QueryRow(`SELECT COUNT(*) FROM sessions`).Scan(&count); err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("%d session rows, want 1", count)
+	}
+}
+
+func TestSQLiteClearAllPersists(t *testing.T) {
+	checkClearAllPersists(t, newTestSQLiteBackend(t, ""))
+}
@@ -0,0 +1,306 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestStoreBackends plays a session into a store on each backend, saves it and checks that a new
+// store on the same backend loads it back
+func TestStoreBackends(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend func(t *testing.T) storageBackend
+	}{
+		{"memory", func(t *testing.T) storageBackend { return newMemoryBackend() }},
+		{"json", func(t *testing.T) storageBackend {
+			path := filepath.Join(t.TempDir(), "game_data.json")
+			return &jsonBackend{path: path, backupPath: path + backupFileSuffix}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := tt.backend(t)
+			store := newDataStore(backend)
+			setForTest(t, &data, store)
+			setForTest(t, &emptyActivityGrace, 0)
+			setForTest(t, &mergeWindow, 0)
+			s := newFakeSession()
+
+			handlePresence(s, testPresence("1", time.Now().Add(-time.Hour), "Minecraft"), time.Time{})
+			handlePresence(s, testPresence("1", time.Time{}), time.Time{})
+			handlePresence(s, testPresence("1", time.Now(), "Tetris"), time.Time{})
+			if err := store.save(); err != nil {
+				t.Fatal(err)
+			}
+
+			loaded := newDataStore(backend)
+			if err := loaded.load(); err != nil {
+				t.Fatal(err)
+			}
+			userData, ok := loaded.snapshotUser("guild", "1")
+			if !ok {
+				t.Fatal("user missing after loading")
+			}
+			if len(userData.Sessions) != 1 || userData.Sessions[0].GameName != "Minecraft" {
+				t.Errorf("sessions after loading = %+v, want one of Minecraft", userData.Sessions)
+			}
+			if _, ok := userData.ActiveGames["Tetris"]; !ok {
+				t.Errorf("active games after loading = %v, want Tetris", userData.ActiveGames)
+			}
+		})
+	}
+}
+
+func TestClearAllPersists(t *testing.T) {
+	tests := []struct {
+		name    string
+		backend func(t *testing.T) storageBackend
+	}{
+		{"memory", func(t *testing.T) storageBackend { return newMemoryBackend() }},
+		{"json", func(t *testing.T) storageBackend {
+			path := filepath.Join(t.TempDir(), "game_data.json")
+			return &jsonBackend{path: path, backupPath: path + backupFileSuffix}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checkClearAllPersists(t, tt.backend(t))
+		})
+	}
+}
+
+// checkClearAllPersists saves two users to the backend, clears the test guild with !clearall and
+// checks that a new store on the same backend loads neither of them back
+func checkClearAllPersists(t *testing.T, backend storageBackend) {
+	t.Helper()
+	store := newDataStore(backend)
+	setForTest(t, &data, store)
+	setForTest(t, &commandCooldown, 0)
+	setForTest(t, &botAdmins, map[string]bool{"1": true})
+	addSession(store, "1", "Minecraft", time.Now().Add(-3*time.Hour), time.Hour)
+	addSession(store, "2", "Tetris", time.Now().Add(-3*time.Hour), time.Hour)
+	if err := store.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newFakeSession()
+	dispatchCommand(s, testMessage("1", "!clearall"))
+	dispatchCommand(s, testMessage("1", "confirm"))
+
+	loaded := newDataStore(backend)
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	for _, userID := range []string{"1", "2"} {
+		if userData, ok := loaded.snapshotUser("guild", userID); ok && len(userData.Sessions) > 0 {
+			t.Errorf("user %s has %d sessions after !clearall and loading again", userID, len(userData.Sessions))
+		}
+	}
+}
+
+func TestCheckDriver(t *testing.T) {
+	for _, backend := range []string{"sqlite", "postgres"} {
+		if err := checkDriver("no-such-driver", backend); err == nil || !strings.Contains(err.Error(), "-tags "+backend) {
+			t.Errorf("checkDriver for a missing %s driver = %v, want an error naming the build tag", backend, err)
+		}
+	}
+}
+
+// testHistory returns data with a sizeable history, many sessions of a few users
+func testHistory() persistedData {
+	tempData := persistedData{Version: currentSchemaVersion, Guilds: map[string]map[string]*UserGameData{"guild": {}}}
+	start := time.Date(2024, 1, 1, 18, 0, 0, 0, time.UTC)
+	for _, userID := range []string{"1", "2", "3"} {
+		userData := newUserGameData()
+		for i := 0; i < 500; i++ {
+			sessionStart := start.Add(time.Duration(i) * 24 * time.Hour)
+			session := newGameSession([]string{"Minecraft", "Tetris", "Elden Ring"}[i%3], sessionStart, sessionStart.Add(90*time.Minute))
+			session.ID = newSessionID()
+			userData.Sessions = append(userData.Sessions, session)
+		}
+		tempData.Guilds["guild"][userID] = userData
+	}
+	return tempData
+}
+
+func TestCompressedDataFile(t *testing.T) {
+	tests := []struct {
+		name         string
+		path         string
+		compressData bool
+		wantGzip     bool
+	}{
+		{"plain", "game_data.json", false, false},
+		{"COMPRESS_DATA", "game_data.json", true, true},
+		{".gz path", "game_data.json.gz", false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), tt.path)
+			setForTest(t, &dataFilePath, path)
+			setForTest(t, &compressData, tt.compressData)
+			backend, err := newStorageBackend("json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := backend.save(testHistory()); err != nil {
+				t.Fatal(err)
+			}
+
+			raw, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if isGzip := bytes.HasPrefix(raw, []byte{0x1f, 0x8b}); isGzip != tt.wantGzip {
+				t.Errorf("file compressed = %v, want %v", isGzip, tt.wantGzip)
+			}
+
+			// Either setting reads either format
+			for _, compress := range []bool{false, true} {
+				loaded, _, err := (&jsonBackend{path: path, backupPath: path + backupFileSuffix, compress: compress}).load()
+				if err != nil {
+					t.Fatal(err)
+				}
+				for userID, userData := range loaded.Guilds["guild"] {
+					if len(userData.Sessions) != 500 {
+						t.Errorf("user %s has %d sessions after loading with compress %v, want 500", userID, len(userData.Sessions), compress)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestCompressedDataFileIsSmaller(t *testing.T) {
+	dir := t.TempDir()
+	sizes := make(map[bool]int64)
+	for _, compress := range []bool{false, true} {
+		path := filepath.Join(dir, fmt.Sprintf("game_data_%v.json", compress))
+		if err := (&jsonBackend{path: path, backupPath: path + backupFileSuffix, compress: compress}).save(testHistory()); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes[compress] = info.Size()
+	}
+	if sizes[true]*4 > sizes[false] {
+		t.Errorf("compressed file is %d bytes, plain %d, want it at least 4 times smaller", sizes[true], sizes[false])
+	}
+}
+
+// TestLoadRecoversFromBackup corrupts the data file and checks that loading falls back to the
+// copy of the previous save
+func TestLoadRecoversFromBackup(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game_data.json")
+	backend := &jsonBackend{path: path, backupPath: path + backupFileSuffix}
+	first := testHistory()
+	second := testHistory()
+	delete(second.Guilds["guild"], "1")
+	for _, tempData := range []persistedData{first, second} {
+		if err := backend.save(tempData); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(path, []byte(`{"guilds": {"guild": {`), dataFileMode); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, _, err := backend.load()
+	if err != nil {
+		t.Fatalf("load with a corrupt data file = %v, want the backup", err)
+	}
+	if len(loaded.Guilds["guild"]) != len(first.Guilds["guild"]) {
+		t.Errorf("loaded %d users, want the %d of the first save", len(loaded.Guilds["guild"]), len(first.Guilds["guild"]))
+	}
+}
+
+// TestMigrateUnversioned loads data files from before schema versions, with and without guilds
+func TestMigrateUnversioned(t *testing.T) {
+	tests := []struct {
+		fixture string
+		guildID string
+	}{
+		{"v0_legacy.json", legacyGuildID},
+		{"v0_guilds.json", "987654321098765432"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fixture, func(t *testing.T) {
+			loaded, _, err := readDataFile(filepath.Join("testdata", tt.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaded.Version != currentSchemaVersion {
+				t.Errorf("version = %d, want %d", loaded.Version, currentSchemaVersion)
+			}
+			userData, ok := loaded.Guilds[tt.guildID]["123456789012345678"]
+			if !ok {
+				t.Fatalf("guilds = %v, want the user in guild %s", loaded.Guilds, tt.guildID)
+			}
+			if len(userData.Sessions) != 1 || userData.Sessions[0].GameName != "Minecraft" || userData.Sessions[0].Duration != 9000 {
+				t.Errorf("sessions = %+v, want the 2h 30m of Minecraft", userData.Sessions)
+			}
+			if _, ok := userData.ActiveGames["Tetris"]; !ok {
+				t.Errorf("active games = %v, want Tetris", userData.ActiveGames)
+			}
+		})
+	}
+}
+
+// TestDataFileDirectoryCreated points DATA_FILE_PATH into directories that don't exist yet
+func TestDataFileDirectoryCreated(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data", "bot", "game_data.json")
+	setForTest(t, &dataFilePath, path)
+	setForTest(t, &compressData, false)
+	backend, err := newStorageBackend("json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := backend.save(testHistory()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("data file wasn't written: %v", err)
+	}
+}
+
+// TestSessionGuildRoundTrip checks that the guild of a session survives saving and loading, and
+// that sessions stored before it was recorded still load
+func TestSessionGuildRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "game_data.json")
+	backend := &jsonBackend{path: path, backupPath: path + backupFileSuffix}
+	store := newDataStore(backend)
+	setForTest(t, &data, store)
+	setForTest(t, &emptyActivityGrace, 0)
+	setForTest(t, &mergeWindow, 0)
+	s := newFakeSession()
+	handlePresence(s, testPresence("1", time.Now().Add(-time.Hour), "Minecraft"), time.Time{})
+	handlePresence(s, testPresence("1", time.Time{}), time.Time{})
+	store.mu.Lock()
+	userData := store.Guilds["guild"]["1"]
+	userData.Sessions = append(userData.Sessions, newGameSession("Tetris", time.Now().Add(-3*time.Hour), time.Now().Add(-2*time.Hour)))
+	store.mu.Unlock()
+	if err := store.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newDataStore(backend)
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	userData, _ = loaded.snapshotUser("guild", "1")
+	guilds := make(map[string]string)
+	for _, session := range userData.Sessions {
+		guilds[session.GameName] = session.GuildID
+	}
+	if guilds["Minecraft"] != "guild" || guilds["Tetris"] != "" || len(guilds) != 2 {
+		t.Errorf("guilds of the loaded sessions = %v, want Minecraft in guild and Tetris in none", guilds)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const summaryTopGames = 5 // Number of games listed in the daily summary
+
+var (
+	// Channel the daily summary is posted to, configurable via SUMMARY_CHANNEL_ID. Empty disables it.
+	summaryChannelID string
+	// Hour of the day (0-23, local time) the summary is posted, configurable via SUMMARY_HOUR
+	summaryHour int
+)
+
+// nextSummaryTime returns the first summaryHour o'clock after now
+func nextSummaryTime(now time.Time) time.Time {
+	next := startOfDay(now).Add(time.Duration(summaryHour) * time.Hour)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 1)
+	}
+	return next
+}
+
+// runDailySummary posts the summary of the previous day once a day until stop is closed
+func runDailySummary(s *discordgo.Session, stop <-chan struct{}) {
+	for {
+		timer := time.NewTimer(time.Until(nextSummaryTime(time.Now())))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			postDailySummary(s, now)
+		}
+	}
+}
+
+// postDailySummary posts yesterday's top games in the summary channel's guild
+func postDailySummary(s *discordgo.Session, now time.Time) {
+	channel, err := s.State.Channel(summaryChannelID)
+	if err != nil {
+		// The state cache may not have the channel, ask the API instead
+		channel, err = s.Channel(summaryChannelID)
+		if err != nil {
+			slog.Warn("Could not look up summary channel", "channel_id", summaryChannelID, "error", err)
+			return
+		}
+	}
+
+	today := startOfDay(now)
+	yesterday := today.AddDate(0, 0, -1)
+
+	data.mu.Lock()
+	ranked := rankGuildGames(data.Guilds[channel.GuildID], func(userData *UserGameData) map[string]time.Duration {
+		return gamePlayTimesBetween(userData, yesterday, today)
+	})
+	data.mu.Unlock()
+
+	response := fmt.Sprintf("**Yesterday's top games** (%s)\n", yesterday.Format(dateFormat))
+	if len(ranked) == 0 {
+		response += "Nobody played anything I could track."
+	} else {
+		if len(ranked) > summaryTopGames {
+			ranked = ranked[:summaryTopGames]
+		}
+		for i, total := range ranked {
+			players := "players"
+			if total.players == 1 {
+				players = "player"
+			}
+			response += fmt.Sprintf("%d. **%s**: %s (%d %s)\n", i+1, sanitizeName(total.name), formatDuration(total.duration), total.players, players)
+		}
+	}
+
+	if err := sendChunked(s, summaryChannelID, response); err != nil {
+		slog.Error("Error posting daily summary", "error", err)
+	}
+}
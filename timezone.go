@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// handleSetTZ implements the !settz command: set the timezone dates are shown in, or reset it to UTC
+func handleSetTZ(s messageSender, m *discordgo.MessageCreate, args string) {
+	username := m.Author.Username
+
+	name := strings.TrimSpace(args)
+
+	// LoadLocation treats "" and "Local" as the bot's own timezone, which isn't what users mean
+	location, err := time.LoadLocation(name)
+	if err != nil || strings.EqualFold(name, "local") {
+		sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, `%s` isn't a timezone I know. Use a name from the tz database like `America/New_York` or `Europe/Berlin`.", username, name))
+		return
+	}
+
+	data.mu.Lock()
+	userData := data.getOrCreateUser(m.GuildID, m.Author.ID)
+	userData.Timezone = location.String()
+	if location == time.UTC {
+		userData.Timezone = ""
+	}
+	if err := data.saveLocked(); err != nil {
+		slog.Error("Error saving timezone", "username", username, "error", err)
+	}
+	data.mu.Unlock()
+
+	sendChunked(s, m.ChannelID, fmt.Sprintf("Hey %s, I'll show your dates in %s from now on. It's %s there right now.", username, location.String(), time.Now().In(location).Format("15:04 MST")))
+}
+
+// userLocation returns the timezone the user chose with !settz, UTC if they didn't
+func userLocation(userData *UserGameData) *time.Location {
+	if userData.Timezone == "" {
+		return time.UTC
+	}
+	location, err := time.LoadLocation(userData.Timezone)
+	if err != nil {
+		// The zone was valid when it was set, but the tz database may have changed since
+		return time.UTC
+	}
+	return location
+}
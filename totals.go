@@ -0,0 +1,69 @@
+package main
+
+// guildTotals are aggregates over the recorded sessions of a guild, kept up to date as sessions
+// are recorded so !stats and !botinfo don't have to scan every session
+type guildTotals struct {
+	sessions      int
+	seconds       float64
+	longest       GameSession
+	longestUserID string
+}
+
+// add counts one more recorded session of a user
+func (t *guildTotals) add(userID string, session GameSession) {
+	t.sessions++
+	t.seconds += session.Duration
+	if session.Duration > t.longest.Duration {
+		t.longest = session
+		t.longestUserID = userID
+	}
+}
+
+// guildTotalsLocked returns the totals of a guild, computing them from its sessions if they
+// aren't cached. The caller must hold ds.mu.
+func (ds *DataStore) guildTotalsLocked(guildID string) guildTotals {
+	if totals, ok := ds.totals[guildID]; ok {
+		return *totals
+	}
+
+	totals := &guildTotals{}
+	for userID, userData := range ds.Guilds[guildID] {
+		for _, session := range userData.Sessions {
+			totals.add(userID, session)
+		}
+		// Sessions folded into the trimmed totals still count
+		for _, seconds := range userData.TrimmedTotals {
+			totals.seconds += seconds
+		}
+		for _, count := range userData.TrimmedCounts {
+			totals.sessions += count
+		}
+	}
+	if ds.totals == nil {
+		ds.totals = make(map[string]*guildTotals)
+	}
+	ds.totals[guildID] = totals
+	return *totals
+}
+
+// countSessionLocked adds a session that was just recorded to its guild's cached totals. The
+// caller must hold ds.mu.
+func (ds *DataStore) countSessionLocked(guildID, userID string, session GameSession) {
+	if totals, ok := ds.totals[guildID]; ok {
+		totals.add(userID, session)
+	}
+}
+
+// invalidateTotalsLocked drops the cached totals of a guild after its sessions were removed or
+// replaced, they are computed again when next needed. The caller must hold ds.mu.
+func (ds *DataStore) invalidateTotalsLocked(guildID string) {
+	delete(ds.totals, guildID)
+}
+
+// rebuildTotalsLocked computes the totals of every guild from scratch. The caller must hold ds.mu.
+func (ds *DataStore) rebuildTotalsLocked() {
+	ds.totals = make(map[string]*guildTotals, len(ds.Guilds))
+	for guildID := range ds.Guilds {
+		ds.guildTotalsLocked(guildID)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// checkTotals compares the cached totals of the test guild with a full recompute
+func checkTotals(t *testing.T, store *DataStore, step int) {
+	t.Helper()
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	cached := store.guildTotalsLocked("guild")
+	store.invalidateTotalsLocked("guild")
+	full := store.guildTotalsLocked("guild")
+	if cached.sessions != full.sessions || int(cached.seconds) != int(full.seconds) || cached.longest.Duration != full.longest.Duration {
+		t.Errorf("after step %d cached totals = %d sessions, %.0fs, longest %.0fs, want %d sessions, %.0fs, longest %.0fs",
+			step, cached.sessions, cached.seconds, cached.longest.Duration, full.sessions, full.seconds, full.longest.Duration)
+	}
+}
+
+func TestGuildTotalsCache(t *testing.T) {
+	now := time.Now()
+	start := func(userID string, ago time.Duration, games ...string) func(*fakeSession) {
+		return func(s *fakeSession) { handlePresence(s, testPresence(userID, now.Add(-ago), games...), time.Time{}) }
+	}
+	stop := func(userID string) func(*fakeSession) {
+		return func(s *fakeSession) { handlePresence(s, testPresence(userID, time.Time{}), time.Time{}) }
+	}
+	command := func(userID, content string) func(*fakeSession) {
+		return func(s *fakeSession) { dispatchCommand(s, testMessage(userID, content)) }
+	}
+	tests := []struct {
+		name        string
+		mergeWindow time.Duration
+		steps       []func(*fakeSession)
+	}{
+		{"one session", 0, []func(*fakeSession){start("1", time.Hour, "Minecraft"), stop("1")}},
+		{"several users", 0, []func(*fakeSession){
+			start("1", 3*time.Hour, "Minecraft"), start("2", 2*time.Hour, "Tetris"), stop("1"),
+			start("2", 2*time.Hour, "Tetris", "Minecraft"), stop("2"),
+		}},
+		{"switching games", 0, []func(*fakeSession){
+			start("1", 2*time.Hour, "Minecraft"), start("1", time.Hour, "Tetris"), stop("1"),
+		}},
+		{"resumed session", time.Hour, []func(*fakeSession){
+			start("1", 2*time.Hour, "Minecraft"), stop("1"), start("1", 2*time.Hour, "Minecraft"), stop("1"),
+		}},
+		{"reset and clear", 0, []func(*fakeSession){
+			start("1", 2*time.Hour, "Minecraft"), stop("1"), start("2", time.Hour, "Tetris"), stop("2"),
+			command("1", "!resetgame minecraft"), start("1", time.Hour, "Tetris"), stop("1"),
+			command("2", "!cleargames"),
+		}},
+		{"opt out", 0, []func(*fakeSession){
+			start("1", 2*time.Hour, "Minecraft"), stop("1"), start("2", time.Hour, "Tetris"), stop("2"),
+			command("1", "!optout"),
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &commandCooldown, 0)
+			setForTest(t, &emptyActivityGrace, 0)
+			setForTest(t, &mergeWindow, tt.mergeWindow)
+			s := newFakeSession()
+
+			// Fill the cache first, so the steps have to keep it up to date
+			store.mu.Lock()
+			store.rebuildTotalsLocked()
+			store.mu.Unlock()
+			for i, step := range tt.steps {
+				step(s)
+				checkTotals(t, store, i)
+			}
+		})
+	}
+}
+
+func TestGuildTotalsRebuiltOnLoad(t *testing.T) {
+	store := newTestStore(t)
+	addSession(store, "1", "Minecraft", time.Now().Add(-3*time.Hour), time.Hour)
+	addSession(store, "2", "Tetris", time.Now().Add(-3*time.Hour), 2*time.Hour)
+	if err := store.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := newDataStore(store.backend)
+	if err := loaded.load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.totals["guild"]; !ok {
+		t.Fatal("totals weren't cached on load")
+	}
+	checkTotals(t, loaded, 0)
+	loaded.mu.Lock()
+	totals := loaded.guildTotalsLocked("guild")
+	loaded.mu.Unlock()
+	if totals.sessions != 2 || totals.seconds != 3*3600 || totals.longestUserID != "2" {
+		t.Errorf("totals after loading = %+v, want 2 sessions, 3h, longest by user 2", totals)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const (
+	voiceGameName     = "Voice Chat" // Name voice sessions are recorded under
+	voiceActivityType = "voice"      // GameSession.ActivityType of voice sessions
+)
+
+// trackVoice records time spent in voice channels as sessions of voiceGameName, configurable via
+// TRACK_VOICE
+var trackVoice bool
+
+// isVoiceSession reports whether an active game of the user is time in a voice channel, which
+// presence updates leave alone
+func isVoiceSession(userData *UserGameData, gameName string) bool {
+	return userData.ActiveTypes[gameName] == voiceActivityType
+}
+
+// voiceStateUpdate is called when a user joins, leaves or moves between voice channels, or
+// changes their mute or deafen state
+func voiceStateUpdate(s *discordgo.Session, v *discordgo.VoiceStateUpdate) {
+	if !trackVoice || v.VoiceState == nil || v.UserID == "" || v.GuildID == "" {
+		return
+	}
+	if v.Member != nil && v.Member.User != nil && v.Member.User.Bot {
+		return
+	}
+
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	if data.optedOut[v.UserID] {
+		return
+	}
+	applyVoiceStateLocked(v.GuildID, v.UserID, v.ChannelID, time.Now())
+}
+
+// applyVoiceStateLocked brings a user's voice session in line with the voice channel they are in,
+// channelID is empty if they are in none. Moving to another channel ends the session and starts a
+// new one. The caller must hold data.mu.
+func applyVoiceStateLocked(guildID, userID, channelID string, now time.Time) {
+	if channelID == "" && data.Guilds[guildID][userID] == nil {
+		return // Left a channel before we knew them, nothing to end
+	}
+	userData := data.getOrCreateUser(guildID, userID)
+	_, active := userData.ActiveGames[voiceGameName]
+	if active && !isVoiceSession(userData, voiceGameName) {
+		return // A game that happens to have the same name, leave it to presence updates
+	}
+
+	if active && channelID == userData.voiceChannel && channelID != "" {
+		return // Only the mute or deafen state changed
+	}
+	if active {
+		endVoiceSessionLocked(guildID, userID, userData, now)
+	}
+	userData.voiceChannel = channelID
+	if channelID == "" {
+		return
+	}
+
+	userData.ActiveGames[voiceGameName] = now
+	if userData.ActiveTypes == nil {
+		userData.ActiveTypes = make(map[string]string)
+	}
+	userData.ActiveTypes[voiceGameName] = voiceActivityType
+	if userData.ActiveIDs == nil {
+		userData.ActiveIDs = make(map[string]string)
+	}
+	userData.ActiveIDs[voiceGameName] = newSessionID()
+	recordSessionStarted()
+	slog.Info("Joined voice", "user_id", userID, "guild_id", guildID, "channel_id", channelID)
+}
+
+// endVoiceSessionLocked records the user's voice session as ending at endTime. The caller must hold data.mu.
+func endVoiceSessionLocked(guildID, userID string, userData *UserGameData, endTime time.Time) {
+	startTime := userData.ActiveGames[voiceGameName]
+	session := newGameSession(voiceGameName, startTime, capSessionEnd(startTime, endTime))
+	session.ActivityType = voiceActivityType
+	session.GuildID = guildID
+	session.ID = userData.ActiveIDs[voiceGameName]
+	if session.ID == "" {
+		session.ID = newSessionID()
+	}
+	delete(userData.ActiveGames, voiceGameName)
+	delete(userData.ActiveTypes, voiceGameName)
+	delete(userData.ActiveIDs, voiceGameName)
+	delete(userData.restoredGames, voiceGameName)
+	userData.voiceChannel = ""
+
+	if session.Duration <= 0 || session.Duration < minSessionSeconds {
+		data.markDirtyLocked()
+		return
+	}
+	userData.Sessions = append(userData.Sessions, session)
+	data.countSessionLocked(guildID, userID, session)
+	recordSessionEnded(session.Duration)
+	slog.Info("Left voice", "user_id", userID, "guild_id", guildID, "duration_seconds", session.Duration)
+	if err := data.insertSessionLocked(guildID, userID, session); err != nil {
+		slog.Error("Error saving session", "user_id", userID, "guild_id", guildID, "game", voiceGameName, "error", err)
+		data.markDirtyLocked()
+	}
+	data.trimSessionsLocked(guildID, userData)
+}
+
+// seedVoiceStates reconciles voice sessions with the voice states of a guild when the bot
+// connects: members in a channel get a session, continuing the one ended by a shutdown if there
+// is one, and sessions restored from disk of members who left while the bot was down end at the
+// last save
+func seedVoiceStates(g *discordgo.Guild) {
+	if !trackVoice {
+		return
+	}
+	channels := make(map[string]string) // Key: User ID, Value: channel ID
+	for _, state := range g.VoiceStates {
+		if state != nil && state.ChannelID != "" {
+			channels[state.UserID] = state.ChannelID
+		}
+	}
+
+	data.mu.Lock()
+	defer data.mu.Unlock()
+	now := time.Now()
+	for userID, userData := range data.Guilds[g.ID] {
+		if _, ok := userData.ActiveGames[voiceGameName]; !ok || !isVoiceSession(userData, voiceGameName) || channels[userID] != "" {
+			continue
+		}
+		endTime := now
+		if userData.restoredGames[voiceGameName] && data.lastSavedAt.After(userData.ActiveGames[voiceGameName]) {
+			endTime = data.lastSavedAt
+		}
+		endVoiceSessionLocked(g.ID, userID, userData, endTime)
+	}
+	for userID, channelID := range channels {
+		if data.optedOut[userID] {
+			continue
+		}
+		userData := data.getOrCreateUser(g.ID, userID)
+		if _, ok := userData.ActiveGames[voiceGameName]; ok && userData.voiceChannel == "" {
+			// Restored from disk, Discord doesn't say whether they switched channels meanwhile
+			userData.voiceChannel = channelID
+			delete(userData.restoredGames, voiceGameName)
+			continue
+		}
+		// There is no join time to compare with, so a voice session finalized at shutdown
+		// always continues if the user is still in a channel
+		if session, ok := reopenFinalizedSessionLocked(userData, voiceGameName, time.Time{}); ok {
+			userData.ActiveGames[voiceGameName] = session.StartTime
+			if userData.ActiveTypes == nil {
+				userData.ActiveTypes = make(map[string]string)
+			}
+			userData.ActiveTypes[voiceGameName] = voiceActivityType
+			if userData.ActiveIDs == nil {
+				userData.ActiveIDs = make(map[string]string)
+			}
+			userData.ActiveIDs[voiceGameName] = session.ID
+			userData.voiceChannel = channelID
+			data.invalidateTotalsLocked(g.ID)
+			data.markDirtyLocked()
+			slog.Info("Still in voice after a restart, continued the finalized session", "user_id", userID, "guild_id", g.ID, "session_id", session.ID)
+			continue
+		}
+		applyVoiceStateLocked(g.ID, userID, channelID, now)
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+func TestVoiceSessions(t *testing.T) {
+	base := time.Date(2024, 6, 10, 18, 0, 0, 0, time.UTC)
+	type event struct {
+		after   time.Duration // Since base
+		channel string        // Empty for leaving
+	}
+	tests := []struct {
+		name          string
+		events        []event
+		wantDurations []time.Duration // Of the recorded voice sessions, in order
+		wantChannel   string          // Channel the user is still in
+	}{
+		{"join and leave", []event{{0, "a"}, {time.Hour, ""}}, []time.Duration{time.Hour}, ""},
+		{"still in voice", []event{{0, "a"}}, nil, "a"},
+		{"move", []event{{0, "a"}, {time.Hour, "b"}, {90 * time.Minute, ""}}, []time.Duration{time.Hour, 30 * time.Minute}, ""},
+		{"mute in the same channel", []event{{0, "a"}, {10 * time.Minute, "a"}, {time.Hour, ""}}, []time.Duration{time.Hour}, ""},
+		{"leave without joining", []event{{0, ""}}, nil, ""},
+		{"rejoin", []event{{0, "a"}, {time.Hour, ""}, {2 * time.Hour, "a"}, {3 * time.Hour, ""}}, []time.Duration{time.Hour, time.Hour}, ""},
+		{"too short", []event{{0, "a"}, {time.Second, ""}}, nil, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &trackVoice, true)
+			setForTest(t, &minSessionSeconds, 60)
+			store.mu.Lock()
+			for _, e := range tt.events {
+				applyVoiceStateLocked("guild", "1", e.channel, base.Add(e.after))
+			}
+			store.mu.Unlock()
+
+			var durations []time.Duration
+			userData, ok := store.snapshotUser("guild", "1")
+			if !ok {
+				userData = newUserGameData()
+			}
+			for _, session := range userData.Sessions {
+				if session.GameName != voiceGameName || session.ActivityType != voiceActivityType {
+					t.Errorf("session %+v isn't a voice session", session)
+				}
+				durations = append(durations, time.Duration(session.Duration)*time.Second)
+			}
+			if len(durations) != len(tt.wantDurations) {
+				t.Fatalf("voice sessions of %v, want %v", durations, tt.wantDurations)
+			}
+			for i := range durations {
+				if durations[i] != tt.wantDurations[i] {
+					t.Errorf("voice sessions of %v, want %v", durations, tt.wantDurations)
+					break
+				}
+			}
+
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			live := store.Guilds["guild"]["1"]
+			if live == nil {
+				if tt.wantChannel != "" {
+					t.Fatal("user unknown, want them in voice")
+				}
+				return
+			}
+			_, active := live.ActiveGames[voiceGameName]
+			if live.voiceChannel != tt.wantChannel || active != (tt.wantChannel != "") {
+				t.Errorf("in channel %q with voice active %v, want channel %q", live.voiceChannel, active, tt.wantChannel)
+			}
+		})
+	}
+}
+
+func TestVoiceStateUpdateIgnored(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		optedOut bool
+		bot      bool
+		want     bool // Whether a voice session starts
+	}{
+		{"tracked", true, false, false, true},
+		{"TRACK_VOICE off", false, false, false, false},
+		{"opted out", true, true, false, false},
+		{"bot", true, false, true, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := newTestStore(t)
+			setForTest(t, &trackVoice, tt.enabled)
+			store.optedOut["1"] = tt.optedOut
+			update := &discordgo.VoiceStateUpdate{VoiceState: &discordgo.VoiceState{
+				GuildID:   "guild",
+				UserID:    "1",
+				ChannelID: "a",
+				Member:    &discordgo.Member{User: &discordgo.User{ID: "1", Bot: tt.bot}},
+			}}
+
+			voiceStateUpdate(nil, update)
+
+			store.mu.Lock()
+			defer store.mu.Unlock()
+			active := false
+			if userData := store.Guilds["guild"]["1"]; userData != nil {
+				_, active = userData.ActiveGames[voiceGameName]
+			}
+			if active != tt.want {
+				t.Errorf("voice session active = %v, want %v", active, tt.want)
+			}
+		})
+	}
+}
+
+// TestVoiceSurvivesPresenceUpdates checks that presence updates, which never list voice, don't end
+// a voice session, and that !mygames shows voice time like a game
+func TestVoiceSurvivesPresenceUpdates(t *testing.T) {
+	store := newTestStore(t)
+	setForTest(t, &trackVoice, true)
+	setForTest(t, &commandCooldown, 0)
+	setForTest(t, &emptyActivityGrace, 0)
+	s := newFakeSession()
+	joined := time.Now().Add(-2 * time.Hour)
+
+	store.mu.Lock()
+	applyVoiceStateLocked("guild", "1", "a", joined)
+	store.mu.Unlock()
+	handlePresence(s, testPresence("1", time.Now().Add(-time.Hour), "Minecraft"), time.Time{})
+	handlePresence(s, testPresence("1", time.Time{}), time.Time{})
+
+	userData, _ := store.snapshotUser("guild", "1")
+	if !userData.ActiveGames[voiceGameName].Equal(joined) {
+		t.Errorf("voice active since %v, want %v", userData.ActiveGames[voiceGameName], joined)
+	}
+
+	dispatchCommand(s, testMessage("1", "!mygames"))
+	if reply := s.lastMessage(t, "channel"); !strings.Contains(reply, "**"+voiceGameName+"**: 2h") {
+		t.Errorf("!mygames reply %q doesn't show 2h of voice", reply)
+	}
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+const wrapupTopGames = 3 // Number of games listed in the weekly wrap-up
+
+// weeklyWrapup enables the Monday wrap-up DM, configurable via WEEKLY_WRAPUP
+var weeklyWrapup = true
+
+// runWeeklyWrapup DMs every user who played last week their wrap-up, at startup in case a
+// Monday was missed and then at the start of every week, until stop is closed
+func runWeeklyWrapup(s *discordgo.Session, stop <-chan struct{}) {
+	sendWeeklyWrapups(s, time.Now())
+	for {
+		timer := time.NewTimer(time.Until(startOfWeek(time.Now()).AddDate(0, 0, 7)))
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case now := <-timer.C:
+			sendWeeklyWrapups(s, now)
+		}
+	}
+}
+
+// sendWeeklyWrapups sends the wrap-up of the week before now to each user who played in it and
+// hasn't received it yet. Users in several guilds get one wrap-up covering all of them.
+func sendWeeklyWrapups(s *discordgo.Session, now time.Time) {
+	thisWeek := startOfWeek(now)
+	lastWeek := thisWeek.AddDate(0, 0, -7)
+	weekBefore := lastWeek.AddDate(0, 0, -7)
+	week := lastWeek.Format("2006-01-02")
+
+	lastWeekTimes := make(map[string]map[string]time.Duration) // Key: User ID, then game name
+	weekBeforeTotals := make(map[string]time.Duration)
+
+	data.mu.Lock()
+	sent := make(map[string]bool)
+	for _, users := range data.Guilds {
+		for userID, userData := range users {
+			if userData.WrapupWeek == week {
+				sent[userID] = true
+			}
+		}
+	}
+	for _, users := range data.Guilds {
+		for userID, userData := range users {
+			if sent[userID] {
+				continue
+			}
+			playTimes := gamePlayTimesBetween(userData, lastWeek, thisWeek)
+			if len(playTimes) == 0 {
+				continue
+			}
+			if lastWeekTimes[userID] == nil {
+				lastWeekTimes[userID] = make(map[string]time.Duration)
+			}
+			for gameName, duration := range playTimes {
+				lastWeekTimes[userID][gameName] += duration
+			}
+			weekBeforeTotals[userID] += playTimeBetween(userData, weekBefore, lastWeek)
+		}
+	}
+	// Mark the wrap-up as sent in every guild before sending, so a restart never sends it twice
+	for _, users := range data.Guilds {
+		for userID, userData := range users {
+			if _, ok := lastWeekTimes[userID]; ok {
+				userData.WrapupWeek = week
+			}
+		}
+	}
+	if len(lastWeekTimes) > 0 {
+		data.markDirtyLocked()
+	}
+	data.mu.Unlock()
+
+	for userID, playTimes := range lastWeekTimes {
+		message := weeklyWrapupMessage(lastWeek, playTimes, weekBeforeTotals[userID])
+		if err := sendDM(s, userID, message); err != nil {
+			if dmsClosed(err) {
+				continue
+			}
+			slog.Warn("Could not send weekly wrap-up", "user_id", userID, "error", err)
+		}
+	}
+}
+
+// weeklyWrapupMessage formats a user's wrap-up of the week starting at weekStart, comparing it to
+// the play time of the week before
+func weeklyWrapupMessage(weekStart time.Time, playTimes map[string]time.Duration, previousTotal time.Duration) string {
+	var total time.Duration
+	for _, duration := range playTimes {
+		total += duration
+	}
+
+	response := fmt.Sprintf("**Your week in games** (week of %s)\n", weekStart.Format(dateFormat))
+	response += fmt.Sprintf("You played %s in total.\n", formatDuration(total))
+
+	ranked := rankGames(playTimes)
+	if len(ranked) > wrapupTopGames {
+		ranked = ranked[:wrapupTopGames]
+	}
+	for i, game := range ranked {
+		response += fmt.Sprintf("%d. **%s**: %s\n", i+1, sanitizeName(game.name), formatDuration(game.duration))
+	}
+
+	switch {
+	case previousTotal < time.Second:
+		response += "You didn't play anything the week before."
+	case total > previousTotal:
+		response += fmt.Sprintf("That's up %d%% from the week before (%s).", percentChange(previousTotal, total), formatDuration(previousTotal))
+	case total < previousTotal:
+		response += fmt.Sprintf("That's down %d%% from the week before (%s).", -percentChange(previousTotal, total), formatDuration(previousTotal))
+	default:
+		response += "That's exactly as much as the week before."
+	}
+	return response
+}
+
+// percentChange returns how much to differs from from, in percent of from
+func percentChange(from, to time.Duration) int {
+	return int(100 * float64(to-from) / float64(from))
+}